@@ -0,0 +1,61 @@
+// Package handshake explains in plain words why a client failed to
+// complete a QUIC handshake.
+package handshake
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// TLS alerts with a dedicated diagnosis (RFC 8446, section 6).
+const (
+	alertBadCertificate        = 42
+	alertCertificateExpired    = 45
+	alertUnknownCA             = 48
+	alertNoApplicationProtocol = 120
+)
+
+// Diagnose explains a dial error caused by a failed handshake in
+// plain words, or returns "" if err is not one.
+func Diagnose(err error) string {
+	var vnErr *quic.VersionNegotiationError
+	var timeoutErr *quic.HandshakeTimeoutError
+	var idleErr *quic.IdleTimeoutError
+	var hostErr x509.HostnameError
+	var caErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var verifyErr *tls.CertificateVerificationError
+	var transportErr *quic.TransportError
+	switch {
+	case errors.As(err, &vnErr):
+		return fmt.Sprintf("version negotiation failed: we offer %v, the server supports %v", vnErr.Ours, vnErr.Theirs)
+	case errors.As(err, &timeoutErr), errors.As(err, &idleErr):
+		return "handshake timed out: is the server running and reachable over UDP?"
+	case errors.As(err, &hostErr):
+		return fmt.Sprintf("certificate verification failed: the certificate is not valid for %q", hostErr.Host)
+	case errors.As(err, &caErr):
+		return "certificate verification failed: the certificate is signed by an unknown authority; trust its CA or skip verification"
+	case errors.As(err, &invalidErr):
+		return fmt.Sprintf("certificate verification failed: %v", invalidErr)
+	case errors.As(err, &verifyErr):
+		return fmt.Sprintf("certificate verification failed: %v", verifyErr.Err)
+	case errors.As(err, &transportErr) && transportErr.ErrorCode.IsCryptoError():
+		alert := tls.AlertError(transportErr.ErrorCode - 0x100)
+		side := "we"
+		if transportErr.Remote {
+			side = "the server"
+		}
+		switch alert {
+		case alertNoApplicationProtocol:
+			return "ALPN mismatch: the server supports none of the offered protocols; check -alpn on both ends"
+		case alertBadCertificate, alertCertificateExpired, alertUnknownCA:
+			return fmt.Sprintf("certificate verification failed: %s rejected the certificate (%v)", side, alert)
+		}
+		return fmt.Sprintf("TLS handshake failed: %s sent the alert %q", side, alert.Error())
+	}
+	return ""
+}
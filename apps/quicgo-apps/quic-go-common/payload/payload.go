@@ -0,0 +1,39 @@
+// Package payload generates the synthetic payload both servers send, of a
+// chosen compressibility.
+package payload
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+)
+
+// payloadBlock is the granularity at which Fill mixes random and zero bytes.
+const payloadBlock = 256
+
+// Fill fills b with payload of the given entropy in [0, 1]: each 256-byte
+// block starts with ceil(entropy*256) random bytes and is zero after that. gzip compresses such a payload by a ratio of roughly
+// 1/entropy, e.g. about 2:1 at 0.5 and 4:1 at 0.25; 0 leaves b all zeros
+// (several hundred to one) and 1 makes it incompressible. The random bytes
+// are drawn from rng, or from the global generator if it is nil.
+func Fill(b []byte, entropy float64, rng *rand.Rand) {
+	if entropy <= 0 {
+		clear(b)
+		return
+	}
+	draw := rand.Uint64
+	if rng != nil {
+		draw = rng.Uint64
+	}
+	random := int(math.Ceil(min(entropy, 1) * payloadBlock))
+	var word [8]byte
+	for off := 0; off < len(b); off += payloadBlock {
+		blk := b[off:min(off+payloadBlock, len(b))]
+		n := min(random, len(blk))
+		for i := 0; i < n; i += len(word) {
+			binary.LittleEndian.PutUint64(word[:], draw())
+			copy(blk[i:n], word[:])
+		}
+		clear(blk[n:])
+	}
+}
@@ -0,0 +1,48 @@
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// gzipRatio returns how many times smaller gzip makes b.
+func gzipRatio(t *testing.T, b []byte) float64 {
+	t.Helper()
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return float64(len(b)) / float64(out.Len())
+}
+
+// TestFillEntropy checks Fill against the compression ratios
+// its doc comment promises: roughly 1/entropy, several hundred to one at 0
+// and none at all at 1.
+func TestFillEntropy(t *testing.T) {
+	tests := []struct {
+		entropy  float64
+		min, max float64
+	}{
+		{0, 300, 2000},
+		{0.25, 3.5, 4.2},
+		{0.5, 1.8, 2.1},
+		{0.75, 1.25, 1.4},
+		{1, 0.99, 1.01},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.entropy), func(t *testing.T) {
+			b := make([]byte, 1<<20)
+			Fill(b, tt.entropy, rand.New(rand.NewPCG(1, 2)))
+			if r := gzipRatio(t, b); r < tt.min || r > tt.max {
+				t.Errorf("gzip ratio %.2f, want %v to %v", r, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package preflight holds the startup checks the servers run before
+// serving, to turn a misconfiguration into an actionable message.
+package preflight
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ExplainBindError turns the common bind failures into an actionable
+// message.
+func ExplainBindError(addr string, err error) error {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Errorf("%s is already in use; is another server still running? (%w)", addr, err)
+	case errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("no permission to bind %s; ports below 1024 need root or CAP_NET_BIND_SERVICE (%w)", addr, err)
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Errorf("%s is not an address of this host (%w)", addr, err)
+	}
+	return fmt.Errorf("cannot bind %s: %w", addr, err)
+}
+
+// CheckCertificates verifies that conf carries a certificate that parses
+// and is currently valid.
+func CheckCertificates(conf *tls.Config) error {
+	if len(conf.Certificates) == 0 {
+		return errors.New("no TLS certificate configured")
+	}
+	now := time.Now()
+	for _, cert := range conf.Certificates {
+		if len(cert.Certificate) == 0 {
+			return errors.New("empty TLS certificate chain")
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("invalid TLS certificate: %w", err)
+		}
+		if now.Before(leaf.NotBefore) {
+			return fmt.Errorf("TLS certificate %q is not valid before %v", leaf.Subject, leaf.NotBefore)
+		}
+		if now.After(leaf.NotAfter) {
+			return fmt.Errorf("TLS certificate %q expired at %v", leaf.Subject, leaf.NotAfter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package qtrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// Capture tallies every packet the process sends and receives, over all of
+// its connections, from the qlog events: the headline numbers of a packet
+// capture, for testbeds where tcpdump cannot run. Its Tracer must be
+// installed as quic.Config.Tracer.
+type Capture struct {
+	mu             sync.Mutex
+	sent, received CaptureCounts
+	// Retransmissions counts the packets sent that carried stream data
+	// sent before, Lost those declared lost and Dropped the ones received
+	// that quic-go could not process.
+	retransmissions, lost, dropped int64
+}
+
+// CaptureCounts is what went one way.
+type CaptureCounts struct {
+	// Packets counts the QUIC packets by type, of which Datagrams were the
+	// first of their UDP datagram.
+	Packets   map[qlog.PacketType]int64
+	Datagrams int64
+	// Bytes is the UDP payload, the QUIC packets whole.
+	Bytes int64
+}
+
+func NewCapture() *Capture {
+	return &Capture{
+		sent:     CaptureCounts{Packets: make(map[qlog.PacketType]int64)},
+		received: CaptureCounts{Packets: make(map[qlog.PacketType]int64)},
+	}
+}
+
+// Tracer is the quic.Config.Tracer callback.
+func (c *Capture) Tracer(context.Context, bool, quic.ConnectionID) qlogwriter.Trace {
+	return &captureTrace{c: c, sentRanges: make(streamRanges)}
+}
+
+// Report logs what was sent and what was received.
+func (c *Capture) Report() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log.Printf("Capture summary, sent: %s; %d retransmissions, %d lost", c.sent.summary(), c.retransmissions, c.lost)
+	log.Printf("Capture summary, received: %s; %d dropped", c.received.summary(), c.dropped)
+}
+
+// captureTypes is the order packet types are reported in.
+var captureTypes = []qlog.PacketType{
+	qlog.PacketTypeInitial,
+	qlog.PacketTypeHandshake,
+	qlog.PacketType0RTT,
+	qlog.PacketType1RTT,
+	qlog.PacketTypeRetry,
+	qlog.PacketTypeVersionNegotiation,
+	qlog.PacketTypeStatelessReset,
+}
+
+func (n CaptureCounts) summary() string {
+	var total int64
+	var types []string
+	for _, t := range captureTypes {
+		if k := n.Packets[t]; k > 0 {
+			total += k
+			types = append(types, fmt.Sprintf("%s %d", t, k))
+		}
+	}
+	if total == 0 {
+		return "no packets"
+	}
+	return fmt.Sprintf("%d packets in %d datagrams (%s), %.2f KB UDP payload, %.2f KB with IPv4/UDP headers, avg packet %d B",
+		total, n.Datagrams, strings.Join(types, ", "), float64(n.Bytes)/1024.0,
+		float64(n.Bytes+n.Datagrams*UDPIPv4Overhead)/1024.0, n.Bytes/total)
+}
+
+func (n *CaptureCounts) add(typ qlog.PacketType, length int, coalesced bool) {
+	n.Packets[typ]++
+	n.Bytes += int64(length)
+	if !coalesced {
+		n.Datagrams++
+	}
+}
+
+// captureTrace counts one connection's packets into the Capture.
+type captureTrace struct {
+	c *Capture
+	// sentRanges is the data sent so far on each stream, to spot
+	// retransmitted stream data
+	sentRanges streamRanges
+}
+
+func (t *captureTrace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *captureTrace) AddProducer() qlogwriter.Recorder {
+	return captureRecorder{t}
+}
+
+type captureRecorder struct {
+	t *captureTrace
+}
+
+func (r captureRecorder) RecordEvent(ev qlogwriter.Event) {
+	c := r.t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch e := ev.(type) {
+	case qlog.PacketSent:
+		c.sent.add(e.Header.PacketType, e.Raw.Length, e.IsCoalesced)
+		// every frame is recorded, but a packet counts once however many
+		// of its frames carry data sent before
+		retransmission := false
+		for _, f := range e.Frames {
+			if sf, ok := f.Frame.(*qlog.StreamFrame); ok && r.t.sentRanges.add(sf) < sf.Length {
+				retransmission = true
+			}
+		}
+		if retransmission {
+			c.retransmissions++
+		}
+	case qlog.PacketReceived:
+		c.received.add(e.Header.PacketType, e.Raw.Length, e.IsCoalesced)
+	case qlog.VersionNegotiationSent:
+		c.sent.add(qlog.PacketTypeVersionNegotiation, 0, false)
+	case qlog.VersionNegotiationReceived:
+		c.received.add(qlog.PacketTypeVersionNegotiation, 0, false)
+	case qlog.PacketLost:
+		c.lost++
+	case qlog.PacketDropped:
+		c.dropped++
+	}
+}
+
+func (r captureRecorder) Close() error { return nil }
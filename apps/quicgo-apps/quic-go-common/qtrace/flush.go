@@ -0,0 +1,116 @@
+package qtrace
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// bufferedFile is a buffered writer, safe for concurrent use, that flushes
+// before closing its file. With a flush interval it also flushes that
+// often, so that a process killed outright leaves its output up to the
+// last flush rather than an empty or truncated file.
+type bufferedFile struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	f    *os.File
+	stop chan struct{}
+}
+
+// openFiles is every bufferedFile not yet closed, for Flush.
+var openFiles = struct {
+	sync.Mutex
+	set map[*bufferedFile]struct{}
+}{set: make(map[*bufferedFile]struct{})}
+
+func newBufferedFile(f *os.File, flushInterval time.Duration) *bufferedFile {
+	b := &bufferedFile{w: bufio.NewWriter(f), f: f, stop: make(chan struct{})}
+	openFiles.Lock()
+	openFiles.set[b] = struct{}{}
+	openFiles.Unlock()
+	if flushInterval > 0 {
+		go b.flushEvery(flushInterval)
+	}
+	return b
+}
+
+func (b *bufferedFile) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := b.Flush(); err != nil {
+				log.Printf("Failed to flush %s: %v", b.f.Name(), err)
+				return
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *bufferedFile) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Write(p)
+}
+
+func (b *bufferedFile) WriteByte(c byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.WriteByte(c)
+}
+
+func (b *bufferedFile) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Flush()
+}
+
+func (b *bufferedFile) Close() error {
+	openFiles.Lock()
+	delete(openFiles.set, b)
+	openFiles.Unlock()
+	close(b.stop)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.w.Flush(); err != nil {
+		b.f.Close()
+		return err
+	}
+	return b.f.Close()
+}
+
+// Flush writes out what the trace files still open have buffered, such as
+// before the process exits without closing its connections.
+func Flush() error {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+	var errs []error
+	for b := range openFiles.set {
+		errs = append(errs, b.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// FlushOnSignal makes a SIGINT or SIGTERM, such as from a timeout killing
+// the run, flush the open trace files before the process exits with status
+// 128 plus the signal number, as it would have without the handler.
+func FlushOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		if err := Flush(); err != nil {
+			log.Printf("Failed to flush the trace files: %v", err)
+		}
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
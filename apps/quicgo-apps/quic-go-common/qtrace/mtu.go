@@ -0,0 +1,215 @@
+package qtrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// DefaultInitialPacketSize is the packet size quic-go starts a connection
+// with when quic.Config.InitialPacketSize is 0.
+const DefaultInitialPacketSize = 1280
+
+// MinMTU is the smallest IP MTU a -mtu cap may set: QUIC needs a path that
+// carries 1200-byte UDP payloads. A socket that can reach IPv6 peers needs
+// 20 bytes more, for the larger IPv6 header.
+const MinMTU = minPacketSize + UDPIPv4Overhead
+
+// minPacketSize is the smallest UDP payload a QUIC path must carry.
+const minPacketSize = 1200
+
+// PathMTU is what path MTU discovery found on one connection. The sizes are
+// UDP payloads, the QUIC packets whole, as quic-go counts them.
+type PathMTU struct {
+	// Initial is the packet size the connection started with, and
+	// Discovered the largest one a probe confirmed, or 0 if none did.
+	Initial    int `json:"initial_packet_size"`
+	Discovered int `json:"discovered_packet_size,omitempty"`
+	// Done is whether the search finished.
+	Done bool `json:"discovery_done"`
+}
+
+// packetSize is the size the connection ended up sending with.
+func (m PathMTU) packetSize() int {
+	return max(m.Initial, m.Discovered)
+}
+
+// MTURecorder collects the outcome of path MTU discovery on each connection
+// from the qlog events. Its Tracer must be installed as quic.Config.Tracer.
+type MTURecorder struct {
+	initial int
+	// capMTU is the -mtu cap, an IP MTU, or 0
+	capMTU int
+
+	mu    sync.Mutex
+	conns []*PathMTU
+}
+
+// NewMTURecorder records the connections made with conf, whose packets are
+// capped at the IP MTU capMTU unless it is 0.
+func NewMTURecorder(conf *quic.Config, capMTU int) *MTURecorder {
+	initial := int(conf.InitialPacketSize)
+	if initial == 0 {
+		initial = DefaultInitialPacketSize
+	}
+	return &MTURecorder{initial: initial, capMTU: capMTU}
+}
+
+// Tracer is the quic.Config.Tracer callback.
+func (r *MTURecorder) Tracer(context.Context, bool, quic.ConnectionID) qlogwriter.Trace {
+	m := &PathMTU{Initial: r.initial}
+	r.mu.Lock()
+	r.conns = append(r.conns, m)
+	r.mu.Unlock()
+	return &mtuTrace{r: r, m: m}
+}
+
+// Report logs the packet size each connection started with and the one
+// discovery found; past one connection, it logs one line with the smallest
+// and largest size over all of them. With a cap, it adds the cap, so a
+// discovered size that stops at it can be told from one the path set. It
+// logs nothing if no connection was traced.
+func (r *MTURecorder) Report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var limit string
+	if r.capMTU > 0 {
+		limit = fmt.Sprintf("; capped by -mtu %d at %d B packets (%d B over IPv6)", r.capMTU, r.capMTU-UDPIPv4Overhead, r.capMTU-UDPIPv6Overhead)
+	}
+	switch len(r.conns) {
+	case 0:
+		return
+	case 1:
+		m := r.conns[0]
+		found := "none above it"
+		if m.Discovered > 0 {
+			found = fmt.Sprintf("%d B", m.Discovered)
+		}
+		search := "search unfinished"
+		if m.Done {
+			search = "search done"
+		}
+		log.Printf("Path MTU: started at %d B packets, discovered %s (%s)%s", m.Initial, found, search, limit)
+		return
+	}
+	lo, hi := r.conns[0].packetSize(), r.conns[0].packetSize()
+	var none, done int
+	for _, m := range r.conns {
+		lo, hi = min(lo, m.packetSize()), max(hi, m.packetSize())
+		if m.Discovered == 0 {
+			none++
+		}
+		if m.Done {
+			done++
+		}
+	}
+	log.Printf("Path MTU: min %d B / max %d B over %d connections, all started at %d B packets (%d found nothing larger, %d finished the search)%s",
+		lo, hi, len(r.conns), r.initial, none, done, limit)
+}
+
+type mtuTrace struct {
+	r *MTURecorder
+	m *PathMTU
+}
+
+func (t *mtuTrace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *mtuTrace) AddProducer() qlogwriter.Recorder {
+	return t
+}
+
+func (t *mtuTrace) RecordEvent(ev qlogwriter.Event) {
+	e, ok := ev.(qlog.MTUUpdated)
+	if !ok {
+		return
+	}
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	t.m.Discovered = max(t.m.Discovered, e.Value)
+	t.m.Done = t.m.Done || e.Done
+}
+
+func (t *mtuTrace) Close() error { return nil }
+
+// CapMTU lowers the packet size conf starts its connections with to fit
+// the IP MTU mtu, if it must, and wraps conn so that it drops every
+// datagram it sends that is larger, as a link with that MTU would: the
+// path MTU probes above it go unanswered, and discovery stops below the
+// cap. The datagrams received are not capped. The headers a datagram
+// needs depend on the family of its destination; the starting packet size
+// leaves room for IPv6 headers unless conn is an IPv4 socket. CapMTU
+// disables GSO for the process, as a GSO write hands the kernel several
+// datagrams in one buffer, and it must be called before conn is passed to
+// quic-go. It fails if conn is not a UDP socket, or if mtu leaves no room
+// for the smallest QUIC packets.
+func CapMTU(conf *quic.Config, conn net.PacketConn, mtu int) (net.PacketConn, error) {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil, fmt.Errorf("capping the MTU needs a UDP socket, not a %T", conn)
+	}
+	c := &mtuConn{UDPConn: udp, mtu: mtu}
+	ipv4 := false
+	if a, ok := udp.LocalAddr().(*net.UDPAddr); ok {
+		ipv4 = a.IP.To4() != nil
+	}
+	size := c.limit(ipv4)
+	if size < minPacketSize {
+		return nil, fmt.Errorf("an MTU of %d leaves %d B for QUIC packets over IPv6, which needs at least %d", mtu, size, minPacketSize+UDPIPv6Overhead)
+	}
+	if err := os.Setenv("QUIC_GO_DISABLE_GSO", "true"); err != nil {
+		return nil, fmt.Errorf("disable GSO: %w", err)
+	}
+	if conf.InitialPacketSize == 0 && size < DefaultInitialPacketSize || int(conf.InitialPacketSize) > size {
+		conf.InitialPacketSize = uint16(size)
+	}
+	return c, nil
+}
+
+// mtuConn keeps the *net.UDPConn methods quic-go needs for its batched
+// reads and for setting the DF bit, without which it would not run path
+// MTU discovery at all. CapMTU disables GSO, so each write is one
+// datagram.
+type mtuConn struct {
+	*net.UDPConn
+	mtu int
+}
+
+// limit is the largest UDP payload that fits the MTU over IPv4, or over
+// IPv6 if ipv4 is false.
+func (c *mtuConn) limit(ipv4 bool) int {
+	if ipv4 {
+		return c.mtu - UDPIPv4Overhead
+	}
+	return c.mtu - UDPIPv6Overhead
+}
+
+// fits reports whether a datagram with a payload of n bytes fits the MTU on
+// the way to addr. An IPv4-mapped IPv6 address, as a dual-stack socket
+// reports IPv4 peers, is sent over IPv4.
+func (c *mtuConn) fits(n int, addr net.Addr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	return n <= c.limit(ok && a.IP.To4() != nil)
+}
+
+func (c *mtuConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (int, int, error) {
+	if !c.fits(len(b), addr) {
+		return len(b), len(oob), nil
+	}
+	return c.UDPConn.WriteMsgUDP(b, oob, addr)
+}
+
+func (c *mtuConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if !c.fits(len(b), addr) {
+		return len(b), nil
+	}
+	return c.UDPConn.WriteTo(b, addr)
+}
@@ -0,0 +1,74 @@
+package qtrace
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+)
+
+func listenUDP(t *testing.T, network, addr string) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(addr)})
+	if err != nil {
+		t.Skipf("listen %s %s: %v", network, addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestCapMTU(t *testing.T) {
+	t.Setenv("QUIC_GO_DISABLE_GSO", "")
+	v4 := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4433}
+	tests := []struct {
+		name    string
+		conn    *net.UDPConn
+		mtu     int
+		initial uint16
+		ok      bool
+	}{
+		{"ipv4 socket", listenUDP(t, "udp4", "127.0.0.1"), 1250, 1250 - UDPIPv4Overhead, true},
+		{"ipv4 socket at the minimum", listenUDP(t, "udp4", "127.0.0.1"), MinMTU, 1200, true},
+		{"dual-stack socket", listenUDP(t, "udp", "::"), 1260, 1260 - UDPIPv6Overhead, true},
+		{"dual-stack socket below the IPv6 minimum", listenUDP(t, "udp", "::"), MinMTU, 0, false},
+		{"above the default packet size", listenUDP(t, "udp4", "127.0.0.1"), 1500, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &quic.Config{}
+			capped, err := CapMTU(conf, tt.conn, tt.mtu)
+			if (err == nil) != tt.ok {
+				t.Fatalf("CapMTU(%d) error %v, want ok %v", tt.mtu, err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+			if conf.InitialPacketSize != tt.initial {
+				t.Errorf("InitialPacketSize = %d, want %d", conf.InitialPacketSize, tt.initial)
+			}
+			if os.Getenv("QUIC_GO_DISABLE_GSO") != "true" {
+				t.Error("GSO left enabled")
+			}
+			c := capped.(*mtuConn)
+			if fit := tt.mtu - UDPIPv4Overhead; !c.fits(fit, v4) || c.fits(fit+1, v4) {
+				t.Errorf("the largest datagram to an IPv4 peer is not %d B", fit)
+			}
+			if fit := tt.mtu - UDPIPv6Overhead; !c.fits(fit, v6) || c.fits(fit+1, v6) {
+				t.Errorf("the largest datagram to an IPv6 peer is not %d B", fit)
+			}
+		})
+	}
+}
+
+func TestCapMTUNotUDP(t *testing.T) {
+	conn, err := net.ListenPacket("unixgram", "")
+	if err != nil {
+		t.Skipf("listen unixgram: %v", err)
+	}
+	defer conn.Close()
+	if _, err := CapMTU(&quic.Config{}, conn, 1500); err == nil {
+		t.Error("CapMTU accepted a unixgram socket")
+	}
+}
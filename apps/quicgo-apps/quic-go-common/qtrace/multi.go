@@ -0,0 +1,77 @@
+package qtrace
+
+import (
+	"context"
+	"errors"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// Tracer is the signature of quic.Config.Tracer.
+type Tracer = func(context.Context, bool, quic.ConnectionID) qlogwriter.Trace
+
+// Multi returns a tracer that feeds every event to each of tracers, which
+// may be nil. It returns nil if all of them are.
+func Multi(tracers ...Tracer) Tracer {
+	var set []Tracer
+	for _, t := range tracers {
+		if t != nil {
+			set = append(set, t)
+		}
+	}
+	switch len(set) {
+	case 0:
+		return nil
+	case 1:
+		return set[0]
+	}
+	return func(ctx context.Context, isClient bool, connID quic.ConnectionID) qlogwriter.Trace {
+		m := &multiTrace{}
+		for _, t := range set {
+			if tr := t(ctx, isClient, connID); tr != nil {
+				m.traces = append(m.traces, tr)
+			}
+		}
+		return m
+	}
+}
+
+type multiTrace struct {
+	traces []qlogwriter.Trace
+}
+
+func (m *multiTrace) SupportsSchemas(schema string) bool {
+	for _, t := range m.traces {
+		if t.SupportsSchemas(schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multiTrace) AddProducer() qlogwriter.Recorder {
+	r := &multiRecorder{}
+	for _, t := range m.traces {
+		r.recorders = append(r.recorders, t.AddProducer())
+	}
+	return r
+}
+
+type multiRecorder struct {
+	recorders []qlogwriter.Recorder
+}
+
+func (r *multiRecorder) RecordEvent(ev qlogwriter.Event) {
+	for _, rec := range r.recorders {
+		rec.RecordEvent(ev)
+	}
+}
+
+func (r *multiRecorder) Close() error {
+	var errs []error
+	for _, rec := range r.recorders {
+		errs = append(errs, rec.Close())
+	}
+	return errors.Join(errs...)
+}
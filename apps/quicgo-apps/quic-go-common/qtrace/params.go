@@ -0,0 +1,142 @@
+package qtrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// TransportParams are the key transport parameters one endpoint sent.
+type TransportParams struct {
+	MaxIdleTimeout                 time.Duration `json:"max_idle_timeout_ns"`
+	MaxUDPPayloadSize              int64         `json:"max_udp_payload_size"`
+	InitialMaxData                 int64         `json:"initial_max_data"`
+	InitialMaxStreamDataBidiLocal  int64         `json:"initial_max_stream_data_bidi_local"`
+	InitialMaxStreamDataBidiRemote int64         `json:"initial_max_stream_data_bidi_remote"`
+	InitialMaxStreamDataUni        int64         `json:"initial_max_stream_data_uni"`
+	InitialMaxStreamsBidi          int64         `json:"initial_max_streams_bidi"`
+	InitialMaxStreamsUni           int64         `json:"initial_max_streams_uni"`
+	MaxDatagramFrameSize           int64         `json:"max_datagram_frame_size,omitempty"`
+}
+
+func (p *TransportParams) String() string {
+	if p == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("max_idle_timeout=%v max_udp_payload_size=%d initial_max_data=%d initial_max_stream_data_bidi_local=%d initial_max_stream_data_bidi_remote=%d initial_max_stream_data_uni=%d initial_max_streams_bidi=%d initial_max_streams_uni=%d",
+		p.MaxIdleTimeout, p.MaxUDPPayloadSize, p.InitialMaxData,
+		p.InitialMaxStreamDataBidiLocal, p.InitialMaxStreamDataBidiRemote, p.InitialMaxStreamDataUni,
+		p.InitialMaxStreamsBidi, p.InitialMaxStreamsUni)
+}
+
+// Negotiated is what a connection settled on: its QUIC version and the
+// transport parameters each side sent. Either side's parameters are nil
+// if they were not seen.
+type Negotiated struct {
+	Version string           `json:"version"`
+	Local   *TransportParams `json:"local_params,omitempty"`
+	Peer    *TransportParams `json:"peer_params,omitempty"`
+}
+
+// Log logs n, the parameters we sent next to those the peer sent.
+func (n Negotiated) Log() {
+	log.Printf("Negotiated QUIC version %s", n.Version)
+	log.Printf("Transport parameters sent: %s", n.Local)
+	log.Printf("Transport parameters received: %s", n.Peer)
+}
+
+// ParamsRecorder collects the transport parameters of each connection from
+// the qlog events. Its Tracer must be installed as quic.Config.Tracer.
+type ParamsRecorder struct {
+	mu    sync.Mutex
+	conns map[quic.ConnectionTracingID]*Negotiated
+}
+
+func NewParamsRecorder() *ParamsRecorder {
+	return &ParamsRecorder{conns: make(map[quic.ConnectionTracingID]*Negotiated)}
+}
+
+// Tracer is the quic.Config.Tracer callback.
+func (r *ParamsRecorder) Tracer(ctx context.Context, _ bool, _ quic.ConnectionID) qlogwriter.Trace {
+	id, _ := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	n := &Negotiated{}
+	r.mu.Lock()
+	r.conns[id] = n
+	r.mu.Unlock()
+	return &paramsTrace{r: r, n: n}
+}
+
+// Negotiated returns what conn negotiated, once its handshake is complete,
+// and forgets it. A nil recorder, or one that did not trace conn, still
+// reports the version.
+func (r *ParamsRecorder) Negotiated(conn *quic.Conn) Negotiated {
+	n := Negotiated{Version: conn.ConnectionState().Version.String()}
+	if r == nil {
+		return n
+	}
+	id, _ := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seen, ok := r.conns[id]; ok {
+		n.Local, n.Peer = seen.Local, seen.Peer
+		delete(r.conns, id)
+	}
+	return n
+}
+
+type paramsTrace struct {
+	r *ParamsRecorder
+	n *Negotiated
+	// local is the perspective of our side, as qlog names it
+	local string
+}
+
+func (t *paramsTrace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *paramsTrace) AddProducer() qlogwriter.Recorder {
+	return t
+}
+
+func (t *paramsTrace) RecordEvent(ev qlogwriter.Event) {
+	e, ok := ev.(qlog.ParametersSet)
+	if !ok || e.Restore {
+		return
+	}
+	p := paramsOf(e)
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	if e.Initiator == qlog.InitiatorLocal {
+		t.n.Local = p
+	} else {
+		t.n.Peer = p
+	}
+}
+
+// paramsOf returns the transport parameters e sets.
+func paramsOf(e qlog.ParametersSet) *TransportParams {
+	p := &TransportParams{
+		MaxIdleTimeout:                 e.MaxIdleTimeout,
+		MaxUDPPayloadSize:              int64(e.MaxUDPPayloadSize),
+		InitialMaxData:                 int64(e.InitialMaxData),
+		InitialMaxStreamDataBidiLocal:  int64(e.InitialMaxStreamDataBidiLocal),
+		InitialMaxStreamDataBidiRemote: int64(e.InitialMaxStreamDataBidiRemote),
+		InitialMaxStreamDataUni:        int64(e.InitialMaxStreamDataUni),
+		InitialMaxStreamsBidi:          e.InitialMaxStreamsBidi,
+		InitialMaxStreamsUni:           e.InitialMaxStreamsUni,
+	}
+	// a negative size means datagrams were not offered
+	if e.MaxDatagramFrameSize > 0 {
+		p.MaxDatagramFrameSize = int64(e.MaxDatagramFrameSize)
+	}
+	return p
+}
+
+func (t *paramsTrace) Close() error { return nil }
@@ -0,0 +1,238 @@
+// Package qtrace provides quic-go connection tracers that write a qlog file
+// and CSV series derived from the qlog events.
+package qtrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// Files names the outputs of a tracer. Empty paths are skipped.
+type Files struct {
+	// Qlog receives the full qlog trace in JSON-SEQ format.
+	Qlog string
+	// CwndCSV receives one row per congestion window update.
+	CwndCSV string
+	// PacketCSV receives one row per packet sent or received. The volume is
+	// large, so rows are written by a separate goroutine.
+	PacketCSV string
+	// FlushInterval, if positive, flushes each file this often while its
+	// connection runs, so that a run killed outright keeps its trace up to
+	// the last flush; otherwise the files are flushed as they are closed.
+	// See also Flush and FlushOnSignal.
+	FlushInterval time.Duration
+}
+
+// packetQueueLen is how many packet rows may be pending before recording
+// blocks on the writer goroutine.
+const packetQueueLen = 4096
+
+// New returns a callback for quic.Config.Tracer that writes files. The
+// first connection traced writes to the paths files names; each later one,
+// as a client opens for a fan-out, a connection race or a resumed transfer,
+// writes to its own copies numbered in the order the connections start:
+// trace.sqlog, then trace.2.sqlog, trace.3.sqlog and so on.
+func New(files Files) func(context.Context, bool, quic.ConnectionID) qlogwriter.Trace {
+	var conns atomic.Int64
+	return func(_ context.Context, isClient bool, connID quic.ConnectionID) qlogwriter.Trace {
+		files := files
+		if n := conns.Add(1); n > 1 {
+			files.Qlog = numbered(files.Qlog, n)
+			files.CwndCSV = numbered(files.CwndCSV, n)
+			files.PacketCSV = numbered(files.PacketCSV, n)
+		}
+		t := &trace{start: time.Now()}
+		if files.Qlog != "" {
+			f, err := os.Create(files.Qlog)
+			if err != nil {
+				log.Printf("Failed to create qlog file: %v", err)
+			} else {
+				seq := qlogwriter.NewConnectionFileSeq(newBufferedFile(f, files.FlushInterval), isClient, connID, []string{qlog.EventSchema})
+				go seq.Run()
+				t.qlog = seq
+			}
+		}
+		if files.CwndCSV != "" {
+			f, err := os.Create(files.CwndCSV)
+			if err != nil {
+				log.Printf("Failed to create cwnd CSV: %v", err)
+			} else {
+				t.cwnd = newBufferedFile(f, files.FlushInterval)
+				fmt.Fprintln(t.cwnd, "time_s,cwnd_bytes,bytes_in_flight")
+			}
+		}
+		if files.PacketCSV != "" {
+			f, err := os.Create(files.PacketCSV)
+			if err != nil {
+				log.Printf("Failed to create packet CSV: %v", err)
+			} else {
+				t.packets = make(chan packetRow, packetQueueLen)
+				t.packetsDone = make(chan struct{})
+				go writePackets(newBufferedFile(f, files.FlushInterval), t.packets, t.packetsDone)
+			}
+		}
+		return t
+	}
+}
+
+// numbered inserts n before the extension of path, unless path is empty.
+func numbered(path string, n int64) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// trace forwards events to the qlog writer and derives the CSV series. Its
+// outputs are closed once every producer has been closed.
+type trace struct {
+	start time.Time
+	qlog  qlogwriter.Trace
+
+	mu          sync.Mutex
+	producers   int
+	cwnd        *bufferedFile
+	packets     chan packetRow
+	packetsDone chan struct{}
+}
+
+func (t *trace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *trace) AddProducer() qlogwriter.Recorder {
+	t.mu.Lock()
+	t.producers++
+	t.mu.Unlock()
+
+	r := &recorder{t: t}
+	if t.qlog != nil {
+		r.qlog = t.qlog.AddProducer()
+	}
+	return r
+}
+
+func (t *trace) record(ev qlogwriter.Event) {
+	switch e := ev.(type) {
+	case qlog.MetricsUpdated:
+		if e.CongestionWindow == 0 {
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.cwnd != nil {
+			fmt.Fprintf(t.cwnd, "%.6f,%d,%d\n", time.Since(t.start).Seconds(), e.CongestionWindow, e.BytesInFlight)
+		}
+	case qlog.PacketSent:
+		t.recordPacket("sent", e.Header, e.Raw, e.Frames)
+	case qlog.PacketReceived:
+		t.recordPacket("received", e.Header, e.Raw, e.Frames)
+	}
+}
+
+func (t *trace) recordPacket(dir string, hdr qlog.PacketHeader, raw qlog.RawInfo, frames []qlog.Frame) {
+	row := packetRow{
+		elapsed: time.Since(t.start),
+		dir:     dir,
+		typ:     hdr.PacketType,
+		pn:      hdr.PacketNumber,
+		length:  raw.Length,
+	}
+	// t.mu only guards the channel against being closed underneath us
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.packets == nil {
+		return
+	}
+	for _, f := range frames {
+		if ack, ok := f.Frame.(*qlog.AckFrame); ok {
+			row.acked = ack.AckRanges
+		}
+	}
+	t.packets <- row
+}
+
+func (t *trace) removeProducer() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.producers--
+	if t.producers > 0 {
+		return
+	}
+	if t.cwnd != nil {
+		if err := t.cwnd.Close(); err != nil {
+			log.Printf("Failed to write cwnd CSV: %v", err)
+		}
+		t.cwnd = nil
+	}
+	if t.packets != nil {
+		close(t.packets)
+		<-t.packetsDone
+		t.packets = nil
+	}
+}
+
+type packetRow struct {
+	elapsed time.Duration
+	dir     string
+	typ     qlog.PacketType
+	pn      qlog.PacketNumber
+	length  int
+	acked   []qlog.AckRange
+}
+
+// writePackets drains rows into w until rows is closed. The acked column
+// lists the ACK ranges carried by the packet as smallest-largest pairs.
+func writePackets(w *bufferedFile, rows <-chan packetRow, done chan<- struct{}) {
+	defer close(done)
+	fmt.Fprintln(w, "time_ns,direction,packet_type,packet_number,length,acked")
+	for r := range rows {
+		fmt.Fprintf(w, "%d,%s,%s,%d,%d,", r.elapsed.Nanoseconds(), r.dir, r.typ, r.pn, r.length)
+		for i, a := range r.acked {
+			if i > 0 {
+				w.WriteByte(';')
+			}
+			fmt.Fprintf(w, "%d-%d", a.Smallest, a.Largest)
+		}
+		w.WriteByte('\n')
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to write packet CSV: %v", err)
+	}
+}
+
+type recorder struct {
+	t    *trace
+	qlog qlogwriter.Recorder
+	once sync.Once
+}
+
+func (r *recorder) RecordEvent(ev qlogwriter.Event) {
+	r.t.record(ev)
+	if r.qlog != nil {
+		r.qlog.RecordEvent(ev)
+	}
+}
+
+func (r *recorder) Close() error {
+	var err error
+	r.once.Do(func() {
+		if r.qlog != nil {
+			err = r.qlog.Close()
+		}
+		r.t.removeProducer()
+	})
+	return err
+}
@@ -0,0 +1,337 @@
+package qtrace
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// UDPIPv4Overhead is the IPv4 and UDP header size added to every datagram,
+// and UDPIPv6Overhead the IPv6 and UDP header size.
+const (
+	UDPIPv4Overhead = 28
+	UDPIPv6Overhead = 48
+)
+
+// SenderCounter collects per-connection sender statistics from the qlog
+// events: what goes on the wire against the application data it carries,
+// how that data splits between 0-RTT and 1-RTT packets, and how long
+// sending was blocked on flow control. Its Tracer must be
+// installed as quic.Config.Tracer.
+type SenderCounter struct {
+	mu    sync.Mutex
+	conns map[quic.ConnectionTracingID]*SenderConn
+}
+
+// SenderConn counts what one connection sends and receives. Its events are
+// recorded under its own lock, so connections do not contend with each
+// other.
+type SenderConn struct {
+	mu        sync.Mutex
+	s         SenderStats
+	producers int
+}
+
+// SenderStats is what one connection has sent so far.
+type SenderStats struct {
+	// Packets and PacketBytes count the QUIC packets sent, of which
+	// Datagrams were the first packet of their UDP datagram.
+	Packets     int64
+	Datagrams   int64
+	PacketBytes int64
+	// PayloadBytes is the stream data sent for the first time, i.e. the
+	// application bytes, excluding retransmissions.
+	PayloadBytes int64
+	// PayloadReceived is the stream data received for the first time.
+	// EarlyPayloadSent and EarlyPayloadReceived are the parts of
+	// PayloadBytes and PayloadReceived carried in 0-RTT packets; the rest
+	// went in 1-RTT packets, as Initial and Handshake packets carry no
+	// stream data.
+	PayloadReceived      int64
+	EarlyPayloadSent     int64
+	EarlyPayloadReceived int64
+	// First and Last are when the first and last packet were sent.
+	First, Last time.Time
+
+	// FCBlockedConn is the time from each DATA_BLOCKED frame sent until the
+	// peer raised the connection limit, over FCBlockedConnCount episodes.
+	// FCBlockedStream is the same for STREAM_DATA_BLOCKED, summed over
+	// streams.
+	FCBlockedConn        time.Duration
+	FCBlockedConnCount   int
+	FCBlockedStream      time.Duration
+	FCBlockedStreamCount int
+
+	sentRanges    streamRanges
+	recvRanges    streamRanges
+	connBlocked   *blocked
+	streamBlocked map[quic.StreamID]*blocked
+}
+
+// blocked is an open flow-control blocking episode at limit.
+type blocked struct {
+	since time.Time
+	limit int64
+}
+
+func NewSenderCounter() *SenderCounter {
+	return &SenderCounter{conns: make(map[quic.ConnectionTracingID]*SenderConn)}
+}
+
+// Tracer is the quic.Config.Tracer callback.
+func (c *SenderCounter) Tracer(ctx context.Context, _ bool, _ quic.ConnectionID) qlogwriter.Trace {
+	id, _ := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	sc := &SenderConn{s: SenderStats{
+		sentRanges:    make(streamRanges),
+		recvRanges:    make(streamRanges),
+		streamBlocked: make(map[quic.StreamID]*blocked),
+	}}
+	c.mu.Lock()
+	c.conns[id] = sc
+	c.mu.Unlock()
+	return &senderTrace{c: c, id: id, sc: sc}
+}
+
+// Conn returns the connection whose context is ctx, e.g. from
+// quic.Conn.Context, or nil for an unknown connection. The counter forgets a
+// connection once its trace is closed, so Conn must be called while it is
+// open; the SenderConn it returns can be read after it has closed. A nil
+// counter knows no connections.
+func (c *SenderCounter) Conn(ctx context.Context) *SenderConn {
+	if c == nil {
+		return nil
+	}
+	id, _ := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conns[id]
+}
+
+// Stats returns a snapshot of what the connection has sent so far; blocking
+// episodes still open count up to now. A nil SenderConn reports false.
+func (sc *SenderConn) Stats() (SenderStats, bool) {
+	if sc == nil {
+		return SenderStats{}, false
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	snap := sc.s
+	now := time.Now()
+	if b := sc.s.connBlocked; b != nil {
+		snap.FCBlockedConn += now.Sub(b.since)
+	}
+	for _, b := range sc.s.streamBlocked {
+		snap.FCBlockedStream += now.Sub(b.since)
+	}
+	snap.sentRanges, snap.recvRanges, snap.connBlocked, snap.streamBlocked = nil, nil, nil, nil
+	return snap, true
+}
+
+// WireBytes estimates the bytes on the wire, including IPv4 and UDP
+// headers.
+func (s SenderStats) WireBytes() int64 {
+	return s.PacketBytes + s.Datagrams*UDPIPv4Overhead
+}
+
+// WireSummary describes s as payload goodput against wire throughput over
+// the time packets were being sent.
+func (s SenderStats) WireSummary() string {
+	elapsed := s.Last.Sub(s.First).Seconds()
+	wire := s.WireBytes()
+	overhead := 0.0
+	if wire > 0 {
+		overhead = float64(wire-s.PayloadBytes) / float64(wire) * 100
+	}
+	str := fmt.Sprintf("%.2f KB payload, %.2f KB on the wire in %d packets, overhead %.1f%%",
+		float64(s.PayloadBytes)/1024.0, float64(wire)/1024.0, s.Packets, overhead)
+	if elapsed > 0 {
+		str += fmt.Sprintf("; over %.3f s: application goodput %.2f Mbps, wire throughput %.2f Mbps",
+			elapsed, float64(s.PayloadBytes)*8.0/1e6/elapsed, float64(wire)*8.0/1e6/elapsed)
+	}
+	return str
+}
+
+// SpaceSummary describes how the payload s sent and received splits between
+// 0-RTT and 1-RTT packets.
+func (s SenderStats) SpaceSummary() string {
+	return fmt.Sprintf("payload sent %.2f KB in 0-RTT, %.2f KB in 1-RTT; received %.2f KB in 0-RTT, %.2f KB in 1-RTT",
+		float64(s.EarlyPayloadSent)/1024.0, float64(s.PayloadBytes-s.EarlyPayloadSent)/1024.0,
+		float64(s.EarlyPayloadReceived)/1024.0, float64(s.PayloadReceived-s.EarlyPayloadReceived)/1024.0)
+}
+
+// FlowControlSummary describes the time s spent blocked on flow control.
+func (s SenderStats) FlowControlSummary() string {
+	return fmt.Sprintf("fc_blocked_conn_ms=%.2f (%d times) fc_blocked_stream_ms=%.2f (%d times)",
+		float64(s.FCBlockedConn)/float64(time.Millisecond), s.FCBlockedConnCount,
+		float64(s.FCBlockedStream)/float64(time.Millisecond), s.FCBlockedStreamCount)
+}
+
+type senderTrace struct {
+	c  *SenderCounter
+	id quic.ConnectionTracingID
+	sc *SenderConn
+}
+
+func (t *senderTrace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *senderTrace) AddProducer() qlogwriter.Recorder {
+	t.sc.mu.Lock()
+	t.sc.producers++
+	t.sc.mu.Unlock()
+	return &senderRecorder{t: t}
+}
+
+type senderRecorder struct {
+	t    *senderTrace
+	once sync.Once
+}
+
+func (r *senderRecorder) RecordEvent(ev qlogwriter.Event) {
+	now := time.Now()
+	sc := r.t.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.producers == 0 {
+		// the connection has ended
+		return
+	}
+	s := &sc.s
+	switch e := ev.(type) {
+	case qlog.PacketSent:
+		s.recordSent(now, e)
+	case qlog.PacketReceived:
+		s.recordReceived(now, e)
+	}
+}
+
+func (s *SenderStats) recordSent(now time.Time, e qlog.PacketSent) {
+	if s.First.IsZero() {
+		s.First = now
+	}
+	s.Last = now
+	s.Packets++
+	if !e.IsCoalesced {
+		s.Datagrams++
+	}
+	s.PacketBytes += int64(e.Raw.Length)
+	for _, f := range e.Frames {
+		switch frame := f.Frame.(type) {
+		case *qlog.StreamFrame:
+			n := s.sentRanges.add(frame)
+			s.PayloadBytes += n
+			if e.Header.PacketType == qlog.PacketType0RTT {
+				s.EarlyPayloadSent += n
+			}
+		case *qlog.DataBlockedFrame:
+			if s.connBlocked == nil {
+				s.connBlocked = &blocked{since: now, limit: int64(frame.MaximumData)}
+				s.FCBlockedConnCount++
+			}
+		case *qlog.StreamDataBlockedFrame:
+			if s.streamBlocked[frame.StreamID] == nil {
+				s.streamBlocked[frame.StreamID] = &blocked{since: now, limit: int64(frame.MaximumStreamData)}
+				s.FCBlockedStreamCount++
+			}
+		}
+	}
+}
+
+// recordReceived counts the payload received and ends blocking episodes
+// once the peer raises the limit they were blocked at.
+func (s *SenderStats) recordReceived(now time.Time, e qlog.PacketReceived) {
+	for _, f := range e.Frames {
+		switch frame := f.Frame.(type) {
+		case *qlog.StreamFrame:
+			n := s.recvRanges.add(frame)
+			s.PayloadReceived += n
+			if e.Header.PacketType == qlog.PacketType0RTT {
+				s.EarlyPayloadReceived += n
+			}
+		case *qlog.MaxDataFrame:
+			if b := s.connBlocked; b != nil && int64(frame.MaximumData) > b.limit {
+				s.FCBlockedConn += now.Sub(b.since)
+				s.connBlocked = nil
+			}
+		case *qlog.MaxStreamDataFrame:
+			if b := s.streamBlocked[frame.StreamID]; b != nil && int64(frame.MaximumStreamData) > b.limit {
+				s.FCBlockedStream += now.Sub(b.since)
+				delete(s.streamBlocked, frame.StreamID)
+			}
+		}
+	}
+}
+
+// streamRanges is the stream data seen so far on each stream, as sorted,
+// disjoint and non-adjacent ranges of offsets. Data below the highest offset
+// seen is not necessarily a retransmission: received frames are reordered
+// by the network, and a lost frame repaired after the ones that followed it.
+type streamRanges map[quic.StreamID][]byteRange
+
+// byteRange is the offsets from start up to, but excluding, end.
+type byteRange struct {
+	start, end int64
+}
+
+// add returns how many bytes of frame were not seen before on its stream,
+// and records them as seen.
+func (r streamRanges) add(frame *qlog.StreamFrame) int64 {
+	start, end := frame.Offset, frame.Offset+frame.Length
+	if end <= start {
+		return 0
+	}
+	ranges := r[frame.StreamID]
+	// ranges before i end before start and cannot touch the frame; in-order
+	// data extends the last range
+	i, _ := slices.BinarySearchFunc(ranges, start, func(b byteRange, off int64) int {
+		return cmp.Compare(b.end, off)
+	})
+	n := end - start
+	merged := byteRange{start, end}
+	j := i
+	for ; j < len(ranges) && ranges[j].start <= end; j++ {
+		n -= min(ranges[j].end, end) - max(ranges[j].start, start)
+		merged.start = min(merged.start, ranges[j].start)
+		merged.end = max(merged.end, ranges[j].end)
+	}
+	r[frame.StreamID] = slices.Replace(ranges, i, j, merged)
+	return n
+}
+
+// Close ends the connection once its last producer is done: open blocking
+// episodes count up to now, and the counter forgets the connection.
+func (r *senderRecorder) Close() error {
+	r.once.Do(func() {
+		sc := r.t.sc
+		sc.mu.Lock()
+		if sc.producers--; sc.producers > 0 {
+			sc.mu.Unlock()
+			return
+		}
+		s := &sc.s
+		now := time.Now()
+		if s.connBlocked != nil {
+			s.FCBlockedConn += now.Sub(s.connBlocked.since)
+		}
+		for _, b := range s.streamBlocked {
+			s.FCBlockedStream += now.Sub(b.since)
+		}
+		s.sentRanges, s.recvRanges, s.connBlocked, s.streamBlocked = nil, nil, nil, nil
+		sc.mu.Unlock()
+
+		r.t.c.mu.Lock()
+		if r.t.c.conns[r.t.id] == sc {
+			delete(r.t.c.conns, r.t.id)
+		}
+		r.t.c.mu.Unlock()
+	})
+	return nil
+}
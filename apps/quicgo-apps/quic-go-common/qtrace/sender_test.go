@@ -0,0 +1,89 @@
+package qtrace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+)
+
+func streamFrame(id quic.StreamID, offset, length int64) qlog.Frame {
+	return qlog.Frame{Frame: &qlog.StreamFrame{StreamID: id, Offset: offset, Length: length}}
+}
+
+func TestStreamRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][2]int64
+		want   []int64
+	}{
+		{"in order", [][2]int64{{0, 100}, {100, 100}, {200, 100}}, []int64{100, 100, 100}},
+		{"reordered", [][2]int64{{100, 100}, {0, 100}, {200, 100}}, []int64{100, 100, 100}},
+		{"reversed", [][2]int64{{200, 100}, {100, 100}, {0, 100}}, []int64{100, 100, 100}},
+		{"retransmitted", [][2]int64{{0, 100}, {100, 100}, {0, 100}}, []int64{100, 100, 0}},
+		{"gap filled by a larger frame", [][2]int64{{0, 100}, {200, 100}, {50, 200}}, []int64{100, 100, 100}},
+		{"spanning several ranges", [][2]int64{{100, 50}, {200, 50}, {300, 50}, {0, 400}}, []int64{50, 50, 50, 250}},
+		{"inside a range", [][2]int64{{0, 300}, {100, 100}}, []int64{300, 0}},
+		{"empty", [][2]int64{{0, 0}, {0, 100}}, []int64{0, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := make(streamRanges)
+			for i, f := range tt.frames {
+				if got := r.add(&qlog.StreamFrame{StreamID: 4, Offset: f[0], Length: f[1]}); got != tt.want[i] {
+					t.Errorf("frame %d at %d+%d: %d new bytes, want %d", i, f[0], f[1], got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestSenderCounterReordered feeds reordered and retransmitted stream frames
+// through the tracer and checks that each payload byte, and so the wire
+// overhead, is counted once.
+func TestSenderCounterReordered(t *testing.T) {
+	c := NewSenderCounter()
+	ctx := context.WithValue(context.Background(), quic.ConnectionTracingKey, quic.ConnectionTracingID(1))
+	rec := c.Tracer(ctx, false, quic.ConnectionID{}).AddProducer()
+
+	received := [][]qlog.Frame{
+		{streamFrame(0, 1000, 1000)},
+		{streamFrame(0, 0, 1000), streamFrame(4, 500, 500)},
+		{streamFrame(4, 0, 500)},
+		{streamFrame(0, 1000, 1000)},
+	}
+	for _, frames := range received {
+		rec.RecordEvent(qlog.PacketReceived{Header: qlog.PacketHeader{PacketType: qlog.PacketType1RTT}, Frames: frames})
+	}
+	sent := [][]qlog.Frame{
+		{streamFrame(0, 0, 1000)},
+		{streamFrame(0, 2000, 1000)},
+		{streamFrame(0, 1000, 1000)},
+		{streamFrame(0, 0, 1000)},
+	}
+	for _, frames := range sent {
+		rec.RecordEvent(qlog.PacketSent{Header: qlog.PacketHeader{PacketType: qlog.PacketType1RTT}, Raw: qlog.RawInfo{Length: 1100}, Frames: frames})
+	}
+
+	sc := c.Conn(ctx)
+	if sc == nil {
+		t.Fatal("connection unknown to the counter")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Conn(ctx) != nil {
+		t.Error("connection still known to the counter after its trace closed")
+	}
+	s, _ := sc.Stats()
+	if s.PayloadReceived != 3000 {
+		t.Errorf("PayloadReceived = %d, want 3000", s.PayloadReceived)
+	}
+	if s.PayloadBytes != 3000 {
+		t.Errorf("PayloadBytes = %d, want 3000", s.PayloadBytes)
+	}
+	if want := int64(4 * (1100 + UDPIPv4Overhead)); s.WireBytes() != want {
+		t.Errorf("WireBytes = %d, want %d", s.WireBytes(), want)
+	}
+}
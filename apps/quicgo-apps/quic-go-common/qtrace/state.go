@@ -0,0 +1,277 @@
+package qtrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// SpaceCounts are the packets and bytes sent and received in one
+// packet-number space.
+type SpaceCounts struct {
+	PacketsSent     int64 `json:"packets_sent"`
+	BytesSent       int64 `json:"bytes_sent"`
+	PacketsReceived int64 `json:"packets_received"`
+	BytesReceived   int64 `json:"bytes_received"`
+}
+
+// StreamCounts are the streams each side opened, by type.
+type StreamCounts struct {
+	LocalBidi int `json:"local_bidi"`
+	LocalUni  int `json:"local_uni"`
+	PeerBidi  int `json:"peer_bidi"`
+	PeerUni   int `json:"peer_uni"`
+}
+
+// CloseInfo is how a connection ended, from its connection_closed event
+// and the cause its context was cancelled with.
+type CloseInfo struct {
+	Initiator string `json:"initiator,omitempty"`
+	Trigger   string `json:"trigger,omitempty"`
+	// Error is the transport or application error code the connection was
+	// closed with.
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Cause  string `json:"cause,omitempty"`
+}
+
+// ConnState is everything an endpoint knows about a connection once it
+// has closed. The RTTs are in milliseconds. The congestion fields are
+// the last the sender reported; quic-go does not expose its slow-start
+// threshold.
+type ConnState struct {
+	Time        time.Time `json:"time"`
+	Perspective string    `json:"perspective"`
+	LocalAddr   string    `json:"local_addr"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ALPN        string    `json:"alpn"`
+	Used0RTT    bool      `json:"used_0rtt"`
+	Negotiated
+
+	MinRTTMs      float64 `json:"min_rtt_ms"`
+	SmoothedRTTMs float64 `json:"smoothed_rtt_ms"`
+	LatestRTTMs   float64 `json:"latest_rtt_ms"`
+	RTTVarMs      float64 `json:"rtt_var_ms"`
+
+	CongestionWindow int    `json:"cwnd_bytes"`
+	BytesInFlight    int    `json:"bytes_in_flight"`
+	CongestionState  string `json:"congestion_state,omitempty"`
+	// DiscoveredMTU is the largest packet size path MTU discovery
+	// confirmed, or 0 if it found none above the initial one.
+	DiscoveredMTU int  `json:"discovered_mtu,omitempty"`
+	MTUSearchDone bool `json:"mtu_search_done"`
+
+	BytesSent       uint64 `json:"bytes_sent"`
+	PacketsSent     uint64 `json:"packets_sent"`
+	BytesReceived   uint64 `json:"bytes_received"`
+	PacketsReceived uint64 `json:"packets_received"`
+	BytesLost       uint64 `json:"bytes_lost"`
+	PacketsLost     uint64 `json:"packets_lost"`
+	// Spaces splits the packets by space: initial, handshake, 0RTT and
+	// 1RTT.
+	Spaces  map[string]*SpaceCounts `json:"spaces"`
+	Streams StreamCounts            `json:"streams"`
+	Close   CloseInfo               `json:"close"`
+}
+
+// StateDump collects the state of each connection from the qlog events,
+// and on Record appends it, together with what the quic.Conn itself
+// reports, to a file as one line of JSON per connection. Its Tracer must be
+// installed as quic.Config.Tracer.
+type StateDump struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	conns map[quic.ConnectionTracingID]*ConnState
+	seen  map[quic.ConnectionTracingID]map[quic.StreamID]bool
+}
+
+// NewStateDump creates path to dump connection states to.
+func NewStateDump(path string) (*StateDump, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &StateDump{
+		f:     f,
+		enc:   json.NewEncoder(f),
+		conns: make(map[quic.ConnectionTracingID]*ConnState),
+		seen:  make(map[quic.ConnectionTracingID]map[quic.StreamID]bool),
+	}, nil
+}
+
+// Tracer is the quic.Config.Tracer callback.
+func (d *StateDump) Tracer(ctx context.Context, isClient bool, _ quic.ConnectionID) qlogwriter.Trace {
+	id, _ := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	s := &ConnState{Perspective: "server", Spaces: make(map[string]*SpaceCounts)}
+	if isClient {
+		s.Perspective = "client"
+	}
+	d.mu.Lock()
+	d.conns[id] = s
+	d.seen[id] = make(map[quic.StreamID]bool)
+	d.mu.Unlock()
+	return &stateTrace{d: d, s: s, seen: d.seen[id], client: isClient}
+}
+
+// Record writes the state of conn, which must have closed, and forgets
+// it. A nil dump records nothing.
+func (d *StateDump) Record(conn *quic.Conn) error {
+	if d == nil {
+		return nil
+	}
+	id, _ := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.conns[id]
+	if !ok {
+		return errors.New("connection was not traced")
+	}
+	delete(d.conns, id)
+	delete(d.seen, id)
+
+	cs := conn.ConnectionState()
+	stats := conn.ConnectionStats()
+	s.Time = time.Now()
+	s.LocalAddr, s.RemoteAddr = conn.LocalAddr().String(), conn.RemoteAddr().String()
+	s.ALPN = cs.TLS.NegotiatedProtocol
+	s.Used0RTT = cs.Used0RTT
+	s.Version = cs.Version.String()
+	s.MinRTTMs, s.SmoothedRTTMs = toMs(stats.MinRTT), toMs(stats.SmoothedRTT)
+	s.LatestRTTMs, s.RTTVarMs = toMs(stats.LatestRTT), toMs(stats.MeanDeviation)
+	s.BytesSent, s.PacketsSent = stats.BytesSent, stats.PacketsSent
+	s.BytesReceived, s.PacketsReceived = stats.BytesReceived, stats.PacketsReceived
+	s.BytesLost, s.PacketsLost = stats.BytesLost, stats.PacketsLost
+	if cause := context.Cause(conn.Context()); cause != nil {
+		s.Close.Cause = cause.Error()
+	}
+	return d.enc.Encode(s)
+}
+
+// Close closes the dump file.
+func (d *StateDump) Close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.f.Close()
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type stateTrace struct {
+	d      *StateDump
+	s      *ConnState
+	seen   map[quic.StreamID]bool
+	client bool
+}
+
+func (t *stateTrace) SupportsSchemas(schema string) bool {
+	return schema == qlog.EventSchema
+}
+
+func (t *stateTrace) AddProducer() qlogwriter.Recorder {
+	return t
+}
+
+func (t *stateTrace) RecordEvent(ev qlogwriter.Event) {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	s := t.s
+	switch e := ev.(type) {
+	case qlog.ParametersSet:
+		if e.Restore {
+			return
+		}
+		if e.Initiator == qlog.InitiatorLocal {
+			s.Local = paramsOf(e)
+		} else {
+			s.Peer = paramsOf(e)
+		}
+	case qlog.PacketSent:
+		c := t.space(e.Header.PacketType)
+		c.PacketsSent++
+		c.BytesSent += int64(e.Raw.Length)
+		t.streams(e.Frames)
+	case qlog.PacketReceived:
+		c := t.space(e.Header.PacketType)
+		c.PacketsReceived++
+		c.BytesReceived += int64(e.Raw.Length)
+		t.streams(e.Frames)
+	case qlog.MetricsUpdated:
+		// zero fields are the ones that did not change
+		if e.CongestionWindow != 0 {
+			s.CongestionWindow = e.CongestionWindow
+		}
+		if e.BytesInFlight != 0 || e.PacketsInFlight != 0 {
+			s.BytesInFlight = e.BytesInFlight
+		}
+	case qlog.CongestionStateUpdated:
+		s.CongestionState = e.State.String()
+	case qlog.MTUUpdated:
+		s.DiscoveredMTU = max(s.DiscoveredMTU, e.Value)
+		s.MTUSearchDone = s.MTUSearchDone || e.Done
+	case qlog.ConnectionClosed:
+		s.Close.Initiator = string(e.Initiator)
+		s.Close.Trigger = string(e.Trigger)
+		s.Close.Reason = e.Reason
+		switch {
+		case e.ApplicationError != nil:
+			s.Close.Error = "application_error_" + formatCode(uint64(*e.ApplicationError))
+		case e.ConnectionError != nil:
+			s.Close.Error = "transport_error_" + formatCode(uint64(*e.ConnectionError))
+		}
+	}
+}
+
+// space returns the counts of the space packets of type pt are sent in.
+func (t *stateTrace) space(pt qlog.PacketType) *SpaceCounts {
+	c, ok := t.s.Spaces[string(pt)]
+	if !ok {
+		c = &SpaceCounts{}
+		t.s.Spaces[string(pt)] = c
+	}
+	return c
+}
+
+// streams counts the streams that frames carry data on for the first
+// time. The low bit of a stream ID tells the side that opened it, the next
+// one whether it is unidirectional.
+func (t *stateTrace) streams(frames []qlog.Frame) {
+	for _, f := range frames {
+		sf, ok := f.Frame.(*qlog.StreamFrame)
+		if !ok || t.seen[sf.StreamID] {
+			continue
+		}
+		t.seen[sf.StreamID] = true
+		local := (sf.StreamID&1 == 0) == t.client
+		uni := sf.StreamID&2 != 0
+		switch {
+		case local && uni:
+			t.s.Streams.LocalUni++
+		case local:
+			t.s.Streams.LocalBidi++
+		case uni:
+			t.s.Streams.PeerUni++
+		default:
+			t.s.Streams.PeerBidi++
+		}
+	}
+}
+
+func (t *stateTrace) Close() error { return nil }
+
+func formatCode(code uint64) string {
+	return "0x" + strconv.FormatUint(code, 16)
+}
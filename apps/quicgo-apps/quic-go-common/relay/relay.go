@@ -0,0 +1,203 @@
+// Package relay routes a client's UDP traffic through a SOCKS5 proxy
+// (RFC 1928 UDP ASSOCIATE), for testbed topologies where the client cannot
+// reach the server directly.
+package relay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	socksVersion   = 5
+	cmdUDPAssoc    = 3
+	atypIPv4       = 1
+	atypDomain     = 3
+	atypIPv6       = 4
+	maxHeaderLen   = 4 + 1 + 255 + 2
+	maxDatagramLen = 65535
+)
+
+var bufPool = sync.Pool{New: func() any { return make([]byte, maxHeaderLen+maxDatagramLen) }}
+
+// Conn is a net.PacketConn whose datagrams travel through a SOCKS5 UDP
+// association. Addresses passed to WriteTo and returned by ReadFrom are those
+// of the far peers, not of the proxy.
+type Conn struct {
+	ctrl  net.Conn
+	udp   *net.UDPConn
+	relay *net.UDPAddr
+}
+
+// DialSOCKS5 sets up a UDP association with the SOCKS5 proxy at proxyAddr
+// (no authentication). The association lasts until the returned Conn is
+// closed.
+func DialSOCKS5(proxyAddr string, timeout time.Duration) (*Conn, error) {
+	ctrl, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("socks5 dial: %w", err)
+	}
+	if timeout > 0 {
+		ctrl.SetDeadline(time.Now().Add(timeout))
+	}
+
+	relayAddr, err := associate(ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5 udp associate: %w", err)
+	}
+	ctrl.SetDeadline(time.Time{})
+	// a proxy answering with the unspecified address relays on the address
+	// the control connection reached
+	if relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	return &Conn{ctrl: ctrl, udp: udp, relay: relayAddr}, nil
+}
+
+// associate runs the method negotiation and UDP ASSOCIATE request on ctrl and
+// returns the proxy's relay address.
+func associate(ctrl net.Conn) (*net.UDPAddr, error) {
+	if _, err := ctrl.Write([]byte{socksVersion, 1, 0}); err != nil {
+		return nil, err
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(ctrl, method[:]); err != nil {
+		return nil, err
+	}
+	if method[0] != socksVersion || method[1] != 0 {
+		return nil, errors.New("proxy requires authentication")
+	}
+
+	// the client address is left unspecified; we send from an ephemeral port
+	if _, err := ctrl.Write([]byte{socksVersion, cmdUDPAssoc, 0, atypIPv4, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	var hdr [3]byte
+	if _, err := io.ReadFull(ctrl, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected reply version %d", hdr[0])
+	}
+	if hdr[1] != 0 {
+		return nil, fmt.Errorf("proxy refused with reply code %d", hdr[1])
+	}
+	addr, err := readAddr(ctrl)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+// readAddr reads an ATYP-prefixed address and port from r.
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unknown address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port[:]))), nil
+}
+
+// ReadFrom reads the next datagram relayed by the proxy. Fragmented
+// datagrams, which the proxy may send but we never request, are dropped.
+func (c *Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := bufPool.Get().([]byte)
+	defer bufPool.Put(buf)
+	for {
+		n, _, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		addr, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		from, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			continue
+		}
+		return copy(p, buf[n-r.Len():n]), from, nil
+	}
+}
+
+// WriteTo sends p to addr through the proxy.
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("relay: unsupported address type %T", addr)
+	}
+	buf := bufPool.Get().([]byte)
+	defer bufPool.Put(buf)
+
+	hdr := append(buf[:0], 0, 0, 0)
+	if ip4 := to.IP.To4(); ip4 != nil {
+		hdr = append(append(hdr, atypIPv4), ip4...)
+	} else {
+		hdr = append(append(hdr, atypIPv6), to.IP.To16()...)
+	}
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(to.Port))
+	if _, err := c.udp.WriteToUDP(append(hdr, p...), c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the UDP association.
+func (c *Conn) Close() error {
+	return errors.Join(c.udp.Close(), c.ctrl.Close())
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.udp.LocalAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.udp.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.udp.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.udp.SetWriteDeadline(t) }
+
+// SetReadBuffer and SetWriteBuffer let quic-go size the socket buffers of
+// the underlying UDP socket.
+func (c *Conn) SetReadBuffer(bytes int) error  { return c.udp.SetReadBuffer(bytes) }
+func (c *Conn) SetWriteBuffer(bytes int) error { return c.udp.SetWriteBuffer(bytes) }
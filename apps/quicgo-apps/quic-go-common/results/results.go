@@ -0,0 +1,159 @@
+// Package results bundles the artifacts of one client run into a timestamped
+// directory with a manifest tying them together.
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// Bundle is a results directory under construction. Files are written into a
+// temporary directory that Finish renames into place, so an interrupted run
+// never leaves a bundle that looks complete.
+type Bundle struct {
+	dir     string
+	tmp     string
+	created time.Time
+	files   []string
+	seeds   map[string]uint64
+	config  map[string]string
+}
+
+// NewBundle creates the temporary directory for a bundle under root.
+func NewBundle(root string) (*Bundle, error) {
+	now := time.Now()
+	dir := filepath.Join(root, now.Format("20060102-150405.000"))
+	tmp := dir + ".tmp"
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return nil, err
+	}
+	return &Bundle{dir: dir, tmp: tmp, created: now}, nil
+}
+
+// Path returns the path for a bundle file and lists it in the manifest.
+func (b *Bundle) Path(name string) string {
+	if !slices.Contains(b.files, name) {
+		b.files = append(b.files, name)
+	}
+	return filepath.Join(b.tmp, name)
+}
+
+// SetSeed records in the manifest the seed the run used for name.
+func (b *Bundle) SetSeed(name string, seed uint64) {
+	if b.seeds == nil {
+		b.seeds = make(map[string]uint64)
+	}
+	b.seeds[name] = seed
+}
+
+// SetConfig records in the manifest the effective value of every option of
+// the run, by flag name.
+func (b *Bundle) SetConfig(config map[string]string) {
+	b.config = config
+}
+
+// WriteJSON writes v as indented JSON to the bundle file name.
+func (b *Bundle) WriteJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.Path(name), append(data, '\n'), 0o644)
+}
+
+// Manifest describes a bundle.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	Args    []string  `json:"args"`
+	Version Version   `json:"version"`
+	Files   []string  `json:"files"`
+	// Seeds are the resolved seeds of a seeded run, by name.
+	Seeds map[string]uint64 `json:"seeds,omitempty"`
+	// Config is the value of every option once the command line and any
+	// -scenario file are applied, by flag name. Args alone does not
+	// reproduce a run that used a scenario file.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// Finish writes the manifest and moves the bundle to its final location,
+// which it returns. The manifest lists the files named with Path, then any
+// other file written into the bundle, such as the numbered traces of later
+// connections.
+func (b *Bundle) Finish() (string, error) {
+	files := slices.Clone(b.files)
+	entries, err := os.ReadDir(b.tmp)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if name := e.Name(); name != "manifest.json" && !slices.Contains(files, name) {
+			files = append(files, name)
+		}
+	}
+	m := Manifest{
+		Created: b.created,
+		Args:    os.Args,
+		Version: BuildVersion(),
+		Files:   files,
+		Seeds:   b.seeds,
+		Config:  b.config,
+	}
+	if err := b.WriteJSON("manifest.json", m); err != nil {
+		return "", err
+	}
+	if err := os.Rename(b.tmp, b.dir); err != nil {
+		return "", err
+	}
+	return b.dir, nil
+}
+
+// Version identifies the binary that produced a run.
+type Version struct {
+	Module   string `json:"module"`
+	Revision string `json:"revision,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+	Go       string `json:"go"`
+	QuicGo   string `json:"quic_go"`
+}
+
+// BuildVersion reads the version metadata embedded at build time.
+func BuildVersion() Version {
+	v := Version{Go: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Module = info.Main.Path + "@" + info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/quic-go/quic-go" {
+			v.QuicGo = dep.Version
+		}
+	}
+	return v
+}
+
+// String formats the version for -version output.
+func (v Version) String() string {
+	s := fmt.Sprintf("%s (quic-go %s, %s)", v.Module, v.QuicGo, v.Go)
+	if v.Revision != "" {
+		s += " revision " + v.Revision
+		if v.Modified {
+			s += "+dirty"
+		}
+	}
+	return s
+}
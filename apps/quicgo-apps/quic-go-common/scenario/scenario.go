@@ -0,0 +1,125 @@
+// Package scenario loads a run's options from a JSON file, so that an
+// experiment is one file rather than a long command line. The keys are the
+// names of the program's flags, and the flag set is the schema: each value
+// is parsed by its flag, as if it had been given on the command line.
+//
+//	{"n": 100000, "cc": "cubic", "initial-rtt": "50ms", "insecure": true}
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// FlagName is the flag that names the scenario file; a file cannot set it.
+const FlagName = "scenario"
+
+// Load sets each flag of fs named in the scenario file at path to the value
+// the file gives it, unless the flag was set on the command line, which
+// takes precedence. It must be called after fs is parsed, and does nothing
+// if path is empty. Keys that name no flag, and values that are not a
+// string, number or boolean, are errors. It logs which flags the file set
+// and which it left to the command line.
+func Load(fs *flag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%s: data after the JSON object", path)
+	}
+
+	var unknown []string
+	for name := range values {
+		if name == FlagName || fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("%s: unknown keys %s: they must be flag names, without the dash", path, strings.Join(unknown, ", "))
+	}
+
+	var applied, overridden []string
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, name := range sortedKeys(values) {
+		if explicit[name] {
+			overridden = append(overridden, "-"+name)
+			continue
+		}
+		s, err := format(values[name])
+		if err != nil {
+			return fmt.Errorf("%s: %q %w", path, name, err)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("%s: %q: %w", path, name, err)
+		}
+		applied = append(applied, "-"+name)
+	}
+	log.Printf("Scenario %s sets %d options: %s", path, len(applied), strings.Join(applied, " "))
+	if len(overridden) > 0 {
+		log.Printf("Scenario options overridden on the command line: %s", strings.Join(overridden, " "))
+	}
+	return nil
+}
+
+// Effective returns the value of every flag of fs, once the command line
+// and the scenario file are applied: the full configuration of a run.
+func Effective(fs *flag.FlagSet) map[string]string {
+	config := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { config[f.Name] = f.Value.String() })
+	return config
+}
+
+// format returns v, a JSON value, as a flag would be given it.
+func format(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", fmt.Errorf("must be a string, number or boolean, not %s", typeName(v))
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Profiles names the files a run writes pprof profiles to. Empty names are
+// off, and with all of them empty profiling costs nothing.
+type Profiles struct {
+	CPU   string
+	Mem   string
+	Block string
+}
+
+// Start begins the requested profiles and returns a function that stops
+// them and writes them out, for `go tool pprof` or a flame graph. The block
+// profile records every blocking event, which slows the run down somewhat.
+func (p Profiles) Start() (func() error, error) {
+	var cpu *os.File
+	if p.CPU != "" {
+		var err error
+		if cpu, err = os.Create(p.CPU); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("start CPU profile: %w", err)
+		}
+	}
+	if p.Block != "" {
+		runtime.SetBlockProfileRate(1)
+	}
+
+	return func() error {
+		var errs []error
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			errs = append(errs, cpu.Close())
+		}
+		if p.Mem != "" {
+			// collect first so the heap profile shows live memory
+			runtime.GC()
+			errs = append(errs, writeProfile("heap", p.Mem))
+		}
+		if p.Block != "" {
+			errs = append(errs, writeProfile("block", p.Block))
+			runtime.SetBlockProfileRate(0)
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+func writeProfile(name, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s profile: %w", name, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// syslogQueue is how many log lines may wait for the syslog server before
+// further ones are dropped.
+const syslogQueue = 1024
+
+// syslogRetry is how long the mirror waits before dialling the syslog
+// server again after failing to reach it.
+const syslogRetry = 5 * time.Second
+
+// MirrorLogs sends everything written through package log to the syslog
+// server at addr, tagged with tag, in addition to stderr. addr is host:port
+// for UDP or tcp://host:port. The mirror is best-effort: lines are queued
+// and forwarded in the background, and are dropped while the queue is full
+// or the server is unreachable, so a slow or missing syslog server never
+// holds up the caller. The returned function waits up to a second for the
+// queued lines to go out and reports how many were dropped.
+func MirrorLogs(addr, tag string) (func(), error) {
+	network, host := "udp", addr
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		network, host = scheme, rest
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog network %q: must be udp or tcp", network)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("missing syslog address in %q", addr)
+	}
+	m := &syslogMirror{lines: make(chan string, syslogQueue), done: make(chan struct{})}
+	go m.forward(network, host, tag)
+	log.SetOutput(io.MultiWriter(os.Stderr, m))
+	return m.close, nil
+}
+
+type syslogMirror struct {
+	lines   chan string
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// Write queues one log line, or drops it if the queue is full.
+func (m *syslogMirror) Write(p []byte) (int, error) {
+	select {
+	case m.lines <- strings.TrimSuffix(string(p), "\n"):
+	default:
+		m.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// forward dials the syslog server lazily and writes out the queued lines,
+// redialling after a write fails. Lines that arrive while there is no
+// connection are dropped. Errors go straight to stderr, since logging them
+// would queue them right back here.
+func (m *syslogMirror) forward(network, host, tag string) {
+	defer close(m.done)
+	var w syslogWriter
+	var retryAt time.Time
+	for line := range m.lines {
+		if w == nil && time.Now().After(retryAt) {
+			var err error
+			if w, err = dialSyslog(network, host, tag); err != nil {
+				fmt.Fprintf(os.Stderr, "Syslog error: %v; retrying in %v\n", err, syslogRetry)
+				w, retryAt = nil, time.Now().Add(syslogRetry)
+			}
+		}
+		if w == nil {
+			m.dropped.Add(1)
+			continue
+		}
+		if err := w.Info(line); err != nil {
+			fmt.Fprintf(os.Stderr, "Syslog error: %v\n", err)
+			m.dropped.Add(1)
+			w.Close()
+			w = nil
+		}
+	}
+	if w != nil {
+		w.Close()
+	}
+}
+
+func (m *syslogMirror) close() {
+	log.SetOutput(os.Stderr)
+	close(m.lines)
+	select {
+	case <-m.done:
+	case <-time.After(time.Second):
+	}
+	if n := m.dropped.Load(); n > 0 {
+		log.Printf("Dropped %d log lines that could not be sent to syslog", n)
+	}
+}
+
+// syslogWriter is the part of *syslog.Writer the mirror uses.
+type syslogWriter interface {
+	Info(string) error
+	Close() error
+}
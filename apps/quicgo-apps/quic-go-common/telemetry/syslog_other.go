@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package telemetry
+
+import "errors"
+
+func dialSyslog(network, host, tag string) (syslogWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !plan9
+
+package telemetry
+
+import "log/syslog"
+
+func dialSyslog(network, host, tag string) (syslogWriter, error) {
+	return syslog.Dial(network, host, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
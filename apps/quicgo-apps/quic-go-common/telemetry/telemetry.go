@@ -0,0 +1,66 @@
+// Package telemetry wires optional OpenTelemetry tracing into the apps.
+// Without an endpoint the global no-op tracer stays installed, so spans cost
+// next to nothing.
+package telemetry
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// flushTimeout bounds how long shutdown waits on an unreachable collector.
+const flushTimeout = 5 * time.Second
+
+// instrumentation names the tracer after the app's module, such as
+// quic-go-goodput or quic-go-rtc.
+var instrumentation = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Path != "" {
+		return info.Main.Path
+	}
+	return "quic-go-common"
+})
+
+// Setup exports spans over OTLP/HTTP to endpoint ("host:port" or a full URL)
+// and returns a function that flushes pending spans. An empty endpoint leaves
+// tracing disabled.
+func Setup(ctx context.Context, service, endpoint string) (func() error, error) {
+	if endpoint == "" {
+		return func() error { return nil }, nil
+	}
+
+	// a bare host:port is assumed to be a local plaintext collector
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure()}
+	if strings.Contains(endpoint, "://") {
+		opts = []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(tp)
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		return tp.Shutdown(ctx)
+	}, nil
+}
+
+// Tracer returns the tracer used for all spans in the app.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation())
+}
@@ -0,0 +1,63 @@
+package tuning
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Built-in congestion controller defaults of the linked quic-go version
+// (v0.56), which keeps them in internal packages.
+const (
+	defaultInitialRTT     = 100 * time.Millisecond
+	defaultMinCwndPackets = 2
+	defaultMaxCwndPackets = 10000
+)
+
+// CongestionTuning holds the sender's startup tuning knobs. Zero values keep
+// the quic-go defaults.
+type CongestionTuning struct {
+	InitialRTT     time.Duration
+	MinCwndPackets int
+	MaxCwndPackets int
+}
+
+// Apply sets the knobs the linked quic-go exposes on conf, warns about the
+// ones it does not, and logs the effective values. quic-go v0.56 exposes none
+// of them through quic.Config, so every requested value is currently a no-op.
+func (t CongestionTuning) Apply(conf *quic.Config) {
+	if t.InitialRTT != 0 {
+		log.Printf("Warning: -initial-rtt is not supported by the linked quic-go version; ignoring %v", t.InitialRTT)
+	}
+	if t.MinCwndPackets != 0 {
+		log.Printf("Warning: -min-cwnd is not supported by the linked quic-go version; ignoring %d packets", t.MinCwndPackets)
+	}
+	if t.MaxCwndPackets != 0 {
+		log.Printf("Warning: -max-cwnd is not supported by the linked quic-go version; ignoring %d packets", t.MaxCwndPackets)
+	}
+	log.Printf("Congestion startup: initial RTT %v, cwnd %d-%d packets",
+		defaultInitialRTT, defaultMinCwndPackets, defaultMaxCwndPackets)
+}
+
+// DefaultCongestionControl is the congestion controller of the linked quic-go version.
+const DefaultCongestionControl = "cubic"
+
+// CheckCongestionControl checks that name selects a congestion controller the linked
+// quic-go can run. quic-go v0.56 keeps its SendAlgorithm interface in an
+// internal package and quic.Config has no field to install one, so only its
+// built-in Cubic sender is available; custom:<name> controllers are rejected
+// rather than silently replaced by it.
+func CheckCongestionControl(name string) error {
+	switch {
+	case name == DefaultCongestionControl:
+		return nil
+	case strings.HasPrefix(name, "custom:"):
+		return errors.New("the linked quic-go version has no hook for custom congestion controllers")
+	default:
+		return fmt.Errorf("unknown congestion controller; only %q is available", DefaultCongestionControl)
+	}
+}
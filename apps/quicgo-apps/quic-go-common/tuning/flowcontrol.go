@@ -0,0 +1,61 @@
+// Package tuning holds the transport tuning options the apps share, as
+// given on their command lines, and applies them to a quic.Config.
+package tuning
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Flow-control receive window defaults of the linked quic-go version (v0.56),
+// which keeps them in internal packages.
+const (
+	defaultInitialStreamWindow = 512 << 10
+	defaultMaxStreamWindow     = 6 << 20
+	defaultInitialConnWindow   = 768 << 10
+	defaultMaxConnWindow       = 15 << 20
+)
+
+// FlowControlWindows sets the receive windows an endpoint advertises, in
+// bytes, per stream and for the whole connection. quic-go starts each window
+// at its initial size and auto-tunes it up to the maximum. Zero values keep
+// the quic-go defaults.
+type FlowControlWindows struct {
+	InitialStream uint64
+	MaxStream     uint64
+	InitialConn   uint64
+	MaxConn       uint64
+}
+
+// Apply sets the windows on conf and logs the effective values. It fails if
+// a maximum, given or default, is below its initial window.
+func (w FlowControlWindows) Apply(conf *quic.Config) error {
+	eff := FlowControlWindows{
+		InitialStream: orDefault(w.InitialStream, defaultInitialStreamWindow),
+		MaxStream:     orDefault(w.MaxStream, defaultMaxStreamWindow),
+		InitialConn:   orDefault(w.InitialConn, defaultInitialConnWindow),
+		MaxConn:       orDefault(w.MaxConn, defaultMaxConnWindow),
+	}
+	if eff.MaxStream < eff.InitialStream {
+		return fmt.Errorf("maximum stream window %d is below the initial stream window %d", eff.MaxStream, eff.InitialStream)
+	}
+	if eff.MaxConn < eff.InitialConn {
+		return fmt.Errorf("maximum connection window %d is below the initial connection window %d", eff.MaxConn, eff.InitialConn)
+	}
+	conf.InitialStreamReceiveWindow = eff.InitialStream
+	conf.MaxStreamReceiveWindow = eff.MaxStream
+	conf.InitialConnectionReceiveWindow = eff.InitialConn
+	conf.MaxConnectionReceiveWindow = eff.MaxConn
+	log.Printf("Receive windows: stream %.0f-%.0f KB, connection %.0f-%.0f KB",
+		float64(eff.InitialStream)/1024, float64(eff.MaxStream)/1024, float64(eff.InitialConn)/1024, float64(eff.MaxConn)/1024)
+	return nil
+}
+
+func orDefault(v, def uint64) uint64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
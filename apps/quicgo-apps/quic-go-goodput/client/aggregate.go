@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"quic-go-goodput/goodput"
+)
+
+// runAggregate summarises the client results of a sweep, found under a
+// directory, by group: the mean, standard deviation and percentiles of
+// their goodput, RTT and TTFB. It returns the process exit status.
+func runAggregate(args []string) int {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory to read the client result JSON files from, recursively, such as the -results-dir of a sweep")
+	by := fs.String("by", goodput.GroupAll, "group the results by this key: dir for the directory directly below -dir (such as one per server -cc), a client flag such as -n read from each bundle's manifest, or a dotted result field such as termination.kind (empty for one group)")
+	out := fs.String("out", "", "write the groups as JSON to this file")
+	fs.Parse(args)
+
+	groups, err := goodput.Aggregate(*dir, *by)
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	goodput.PrintAggregate(groups, *by)
+	if *out != "" {
+		data, err := json.MarshalIndent(groups, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*out, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			fmt.Println("FAIL: write result:", err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"time"
+
+	"quic-go-goodput/goodput"
+)
+
+// runBufferbloat pings the server on an idle connection, then keeps pinging
+// while a -n KB download loads the same connection, and reports the idle
+// and loaded ping RTT and how much the RTT inflated. It returns the process
+// exit status.
+func runBufferbloat(args []string) int {
+	fs := flag.NewFlagSet("bufferbloat", flag.ExitOnError)
+	serverAddr := fs.String("p", "127.0.0.1:8080", "server IP and port")
+	requestKB := fs.Int("n", 10240, "KB to download while measuring the loaded RTT")
+	idlePings := fs.Int("idle-pings", 10, "number of pings on the idle connection before the download")
+	interval := fs.Duration("interval", 100*time.Millisecond, "time between pings")
+	readBuffer := fs.Int("read-buffer", 65536, "application read buffer size in bytes")
+	token := fs.String("token", "", "shared token to send with each request, for a server that requires one")
+	alpn := fs.String("alpn", goodput.DefaultALPN, "comma-separated ALPN protocols to propose, in order of preference")
+	caFile := fs.String("ca", "", "PEM file with the CA certificates to verify the server against")
+	insecure := fs.Bool("insecure", false, "skip server certificate verification (for the server's default self-signed certificate)")
+	fs.Parse(args)
+
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = goodput.LoadCertPool(*caFile); err != nil {
+			fmt.Println("FAIL: CA error:", err)
+			return 1
+		}
+	}
+
+	_, err := goodput.RunBufferbloat(context.Background(), goodput.ClientConfig{
+		Addr:         *serverAddr,
+		RequestBytes: 1024 * (*requestKB),
+		ReadBuffer:   *readBuffer,
+		Token:        *token,
+		ALPN:         goodput.ParseALPN(*alpn),
+		RootCAs:      rootCAs,
+		Insecure:     *insecure,
+	}, *idlePings, *interval)
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	return 0
+}
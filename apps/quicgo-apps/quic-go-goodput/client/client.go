@@ -2,123 +2,393 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/quic-go/quic-go"
+
+	"quic-go-common/qtrace"
+	"quic-go-common/relay"
+	"quic-go-common/results"
+	"quic-go-common/scenario"
+	"quic-go-common/telemetry"
+	"quic-go-common/tuning"
+	"quic-go-goodput/goodput"
 )
 
 const MAX_DATAGRAM_SIZE = 1350
 
-type ClientStats struct {
-	bytesRecv     int
-	intervalRecv  int
-	startTime     time.Time
-	lastPrintTime time.Time
-}
-
-func NewClientStats() *ClientStats {
-	now := time.Now()
-	return &ClientStats{
-		bytesRecv:     0,
-		intervalRecv:  0,
-		lastPrintTime: now,
-		startTime:     now,
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		disableGSO()
+		os.Exit(runSelftest(os.Args[2:]))
 	}
-}
-
-func (s *ClientStats) Add(n int) {
-	s.bytesRecv += n
-	s.intervalRecv += n
-
-	elapsedSec := time.Since(s.startTime).Seconds()
-	if elapsedSec-s.lastPrintTime.Sub(s.startTime).Seconds() >= 1.0 {
-		start := int(elapsedSec) - 1
-		end := int(elapsedSec)
-		fmt.Printf("%d-%d sec   %.2f MB   %.2f Mbits/sec\n",
-			start,
-			end,
-			float64(s.intervalRecv)/1_000_000.0,
-			float64(s.intervalRecv)/1_000_000.0*8.0)
-		s.intervalRecv = 0
-		s.lastPrintTime = time.Now()
+	if len(os.Args) > 1 && os.Args[1] == "fairness" {
+		disableGSO()
+		os.Exit(runFairness(os.Args[2:]))
 	}
-}
-
-func (s *ClientStats) PrintFinal() {
-	elapsed := time.Since(s.startTime).Seconds()
-
-	if s.intervalRecv > 0 {
-		startSec := elapsed - (elapsed - s.lastPrintTime.Sub(s.startTime).Seconds())
-		fmt.Printf("%d-%.3f sec   %.2f MB   %.2f Mbits/sec\n",
-			int(startSec),
-			elapsed,
-			float64(s.intervalRecv)/1_000_000.0,
-			float64(s.intervalRecv)/1_000_000.0*8.0/(elapsed-startSec))
+	if len(os.Args) > 1 && os.Args[1] == "handshake-bench" {
+		disableGSO()
+		os.Exit(runHandshakeBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bufferbloat" {
+		disableGSO()
+		os.Exit(runBufferbloat(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		disableGSO()
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "control" {
+		os.Exit(runControl(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "aggregate" {
+		os.Exit(runAggregate(os.Args[2:]))
 	}
 
-	fmt.Printf("Recv %.2f KB bytes in %.3f s, goodput: %.2f Mbps\n",
-		float64(s.bytesRecv)/1024.0,
-		elapsed,
-		float64(s.bytesRecv)/1_000_000.0*8.0/elapsed)
-}
-
-func main() {
 	serverAddr := flag.String("p", "127.0.0.1:8080", "server IP and port")
+	serverList := flag.String("servers", "", "comma-separated server addresses to run the transfer against concurrently, reporting per-server and total goodput (overrides -p)")
+	raceConnect := flag.Bool("race-connect", false, "treat -servers as alternative addresses of one server: dial them all at once, run the transfer on whichever handshake completes first, and report which won and by how much")
 	requestKB := flag.Int("n", 1, "request_kb")
+	readBuffer := flag.Int("read-buffer", 65536, "application read buffer size in bytes (larger reduces per-read overhead on high-BDP links, at the cost of memory and coarser stats updates)")
+	discardFirstRTT := flag.Bool("discard-first-rtt", false, "also report goodput excluding the first RTT (estimated from TTFB) of the transfer")
+	format := flag.String("format", formatText, "output format: text for the progress lines and summary, or flent for a flent data file of the per-second goodput and RTT samples on stdout")
+	transportParams := flag.Bool("transport-params", false, "log the negotiated QUIC version and the transport parameters sent and received, and record them in the result")
+	stateDump := flag.String("state-dump", "", "write each connection's final state (negotiated parameters, RTT, congestion window, bytes per packet-number space, stream counts and close reason) as a line of JSON to this file when it closes")
+	summaryInterval := flag.Int("summary-interval", 0, "also print a summary of the cumulative bytes and goodput, current RTT and loss so far every this many seconds (0 disables)")
+	controlAddr := flag.String("control-addr", "", "server control listener (host:port) to send the -control-script commands to during the run")
+	controlScript := flag.String("control-script", "", "comma-separated \"<offset> <command>\" steps to send to -control-addr, offsets from the start of the run, e.g. \"2s RATE 5, 4s STOP, 5s START\"")
+	reportGaps := flag.Bool("report-gaps", false, "list each stretch of at least -gap-threshold in which no payload arrived, such as a loss recovery or RTO, with its start time and duration")
+	gapThreshold := flag.Duration("gap-threshold", 200*time.Millisecond, "shortest gap -report-gaps lists")
+	window := flag.Int("window", 0, "also print the goodput averaged over this many seconds on each per-second line (0 disables)")
+	prefillKB := flag.Int("prefill", 0, "KB of cover traffic to fetch alongside the measured transfer, to fill network queues (0 disables)")
+	prefillLeadKB := flag.Int("prefill-lead", 0, "KB of the -prefill that must arrive before the measured request is sent; the rest stays in flight during the transfer (0 sends it right after the prefill request, -prefill or more waits for the whole prefill)")
+	alpn := flag.String("alpn", goodput.DefaultALPN, "comma-separated ALPN protocols to propose, in order of preference")
+	parallel := flag.Int("parallel", 1, "split the request across this many concurrent streams and report per-stream goodput")
+	minGoodput := flag.Float64("min-goodput", 0, "exit non-zero unless goodput reaches this many Mbps, printing PASS/FAIL (0 disables)")
+	pipeline := flag.Int("pipeline", 1, "split the request into this many requests pipelined on one stream and report per-request timing")
+	seed := flag.Uint64("seed", 0, "seed the random choices of the run, such as the transfer ID of -resumes, and record the derived seeds in the -results-dir manifest (0 for unseeded)")
+	resumes := flag.Int("resumes", 0, "fetch the request as a resumable transfer and reconnect up to this many times to continue it after an interruption (0 disables)")
+	transport := flag.String("transport", goodput.TransportQUIC, "transport to run GETN over: quic, or tcp for a TCP baseline")
+	tcpTLS := flag.Bool("tcp-tls", false, "wrap the tcp transport in TLS")
+	token := flag.String("token", "", "token to send with each request, for a server run with -token")
+	hintsFlag := flag.String("hints", "", "comma-separated key=value hints to configure this connection on a server run with -accept-hints, e.g. think=50ms,entropy=0.5: think, entropy, seed, pattern and max vary per connection; cc, initial-rtt, min-cwnd, max-cwnd, stream-window and conn-window are fixed per listener and ignored")
+	caFile := flag.String("ca", "", "PEM file with the CA certificates to verify the server against")
+	insecure := flag.Bool("insecure", false, "skip server certificate verification (for the servers' default self-signed certificates)")
+	connectTimeout := flag.Duration("connect-timeout", 0, "give up on connecting to the server after this long, handshake included, apart from the time the transfer takes (0 leaves it to the QUIC handshake idle timeout)")
+	relayAddr := flag.String("relay", "", "SOCKS5 proxy (host:port) to send the QUIC traffic through via UDP ASSOCIATE")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector (host:port or URL) to export trace spans to; tracing is off when empty")
+	liveSocket := flag.String("live-socket", "", "serve each per-second sample as a JSON line to readers of this Unix socket, e.g. a live plotter; slow readers miss samples rather than stall the transfer")
+	resultsDir := flag.String("results-dir", "", "write the result, qlog, cwnd CSV and a manifest into a timestamped subdirectory of this directory")
+	packetLog := flag.String("packet-log", "", "write a CSV of every packet sent and received, with timestamps, packet numbers and ACK ranges, to this file (large); with -servers, -race-connect or -resumes, each connection after the first writes a copy numbered in the order they start, e.g. packets.2.csv")
+	flushInterval := flag.Duration("flush-interval", time.Second, "flush the qlog, cwnd CSV and -packet-log files this often during the run, so a run killed outright keeps its traces up to the last flush; SIGINT and SIGTERM flush them before exiting (0 flushes only at the end)")
+	captureSummary := flag.Bool("capture-summary", false, "log a packet-capture style summary at the end: QUIC packets sent and received by type, retransmissions, UDP bytes and the average packet size")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	initialStreamWindow := flag.Uint64("initial-stream-window", 0, "initial per-stream receive window in bytes (0 keeps the quic-go default)")
+	maxStreamWindow := flag.Uint64("max-stream-window", 0, "maximum per-stream receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	initialConnWindow := flag.Uint64("initial-conn-window", 0, "initial connection receive window in bytes (0 keeps the quic-go default)")
+	maxConnWindow := flag.Uint64("max-conn-window", 0, "maximum connection receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	scenarioFile := flag.String(scenario.FlagName, "", "JSON file of flag values to run with, keyed by flag name, e.g. {\"cc\": \"cubic\", \"initial-rtt\": \"50ms\"}; the flags given on the command line take precedence, and -results-dir records the effective value of every flag in the manifest")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile at the end of the run to this file")
+	blockProfile := flag.String("blockprofile", "", "write a profile of every goroutine blocking event to this file (slows the run)")
 	flag.Parse()
+	if err := scenario.Load(flag.CommandLine, *scenarioFile); err != nil {
+		log.Fatalf("invalid -scenario: %v", err)
+	}
 	disableGSO()
 
-	tlsConf := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"http/0.9"},
+	if *showVersion {
+		fmt.Println(results.BuildVersion())
+		return
+	}
+
+	switch *format {
+	case formatText:
+	case formatFlent:
+		if *serverList != "" && !*raceConnect {
+			log.Fatal("-format flent cannot be combined with -servers")
+		}
+	default:
+		log.Fatalf("invalid -format %q: must be %s or %s", *format, formatText, formatFlent)
+	}
+
+	if *raceConnect && len(splitList(*serverList)) < 2 {
+		log.Fatal("-race-connect needs at least two -servers addresses to race")
 	}
 
-	session, err := quic.DialAddr(context.Background(), *serverAddr, tlsConf, nil)
+	if *connectTimeout < 0 {
+		log.Fatalf("invalid -connect-timeout %v: must not be negative", *connectTimeout)
+	}
+	if *flushInterval < 0 {
+		log.Fatalf("invalid -flush-interval %v: must not be negative", *flushInterval)
+	}
+	if *prefillLeadKB < 0 {
+		log.Fatalf("invalid -prefill-lead %v: must not be negative", *prefillLeadKB)
+	}
+	if *captureSummary && *transport != goodput.TransportQUIC {
+		log.Fatal("-capture-summary only applies to the quic transport")
+	}
+	var controlSteps []goodput.ControlStep
+	if (*controlAddr == "") != (*controlScript == "") {
+		log.Fatal("-control-addr and -control-script must be given together")
+	}
+	if *controlScript != "" {
+		var err error
+		if controlSteps, err = goodput.ParseControlScript(*controlScript); err != nil {
+			log.Fatalf("invalid -control-script: %v", err)
+		}
+	}
+	hints, perListener, err := goodput.ParseHints(*hintsFlag)
 	if err != nil {
-		log.Fatal("Dial error:", err)
+		log.Fatalf("invalid -hints %q: %v", *hintsFlag, err)
+	}
+	if len(hints) > 0 && (*parallel > 1 || *pipeline > 1 || *resumes > 0) {
+		log.Fatal("-hints are only sent with GETN requests, so cannot be combined with -parallel, -pipeline or -resumes")
+	}
+	if len(perListener) > 0 {
+		log.Printf("Warning: the server only sets %s per listener and will ignore the hint", strings.Join(perListener, ", "))
+	}
+	if *reportGaps && *gapThreshold <= 0 {
+		log.Fatalf("invalid -gap-threshold %v: must be positive", *gapThreshold)
+	}
+
+	if *caFile == "" && !*insecure {
+		log.Fatal("No CA given: pass -ca to verify the server certificate, or -insecure to skip verification")
+	}
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = goodput.LoadCertPool(*caFile); err != nil {
+			log.Fatal("CA error:", err)
+		}
+	}
+
+	var bundle *results.Bundle
+	quicConf := &quic.Config{}
+	windows := tuning.FlowControlWindows{
+		InitialStream: *initialStreamWindow,
+		MaxStream:     *maxStreamWindow,
+		InitialConn:   *initialConnWindow,
+		MaxConn:       *maxConnWindow,
+	}
+	if err := windows.Apply(quicConf); err != nil {
+		log.Fatalf("invalid receive windows: %v", err)
+	}
+	traceFiles := qtrace.Files{PacketCSV: *packetLog}
+	if *resultsDir != "" {
+		var err error
+		if bundle, err = results.NewBundle(*resultsDir); err != nil {
+			log.Fatal("Results dir error:", err)
+		}
+		bundle.SetConfig(scenario.Effective(flag.CommandLine))
+		traceFiles.Qlog = bundle.Path("client.sqlog")
+		traceFiles.CwndCSV = bundle.Path("cwnd.csv")
+	}
+	var fileTracer, paramsTracer, dumpTracer, captureTracer qtrace.Tracer
+	if traceFiles != (qtrace.Files{}) {
+		traceFiles.FlushInterval = *flushInterval
+		fileTracer = qtrace.New(traceFiles)
+		qtrace.FlushOnSignal()
+	}
+	var params *qtrace.ParamsRecorder
+	if *transportParams {
+		params = qtrace.NewParamsRecorder()
+		paramsTracer = params.Tracer
+	}
+	var dump *qtrace.StateDump
+	if *stateDump != "" {
+		var err error
+		if dump, err = qtrace.NewStateDump(*stateDump); err != nil {
+			log.Fatal("State dump error:", err)
+		}
+		defer dump.Close()
+		dumpTracer = dump.Tracer
+	}
+	var capture *qtrace.Capture
+	if *captureSummary {
+		capture = qtrace.NewCapture()
+		captureTracer = capture.Tracer
+	}
+	mtus := qtrace.NewMTURecorder(quicConf, 0)
+	quicConf.Tracer = qtrace.Multi(fileTracer, paramsTracer, dumpTracer, captureTracer, mtus.Tracer)
+
+	var packetConn net.PacketConn
+	if *relayAddr != "" {
+		conn, err := relay.DialSOCKS5(*relayAddr, 5*time.Second)
+		if err != nil {
+			log.Fatal("Relay error:", err)
+		}
+		defer conn.Close()
+		packetConn = conn
+		log.Printf("Relaying through %s", *relayAddr)
+	}
+
+	var live *goodput.LivePublisher
+	if *liveSocket != "" {
+		var err error
+		if live, err = goodput.NewLivePublisher(*liveSocket); err != nil {
+			log.Fatal("Live socket error:", err)
+		}
+		defer live.Close()
 	}
-	defer session.CloseWithError(0, "")
 
-	stream, err := session.OpenStreamSync(context.Background())
+	shutdownTracing, err := telemetry.Setup(context.Background(), "quic-go-goodput-client", *otlpEndpoint)
 	if err != nil {
-		log.Fatal("Open stream error:", err)
+		log.Fatal("Tracing setup error:", err)
 	}
+	defer shutdownTracing()
 
-	// send a GETN request
-	payload_bytes := 1024 * (*requestKB)
-	cmd := "GETN " + fmt.Sprintf("%d", payload_bytes) + "\r\n"
-	_, err = stream.Write([]byte(cmd))
+	stopProfiles, err := telemetry.Profiles{CPU: *cpuProfile, Mem: *memProfile, Block: *blockProfile}.Start()
 	if err != nil {
-		log.Fatal("Write GETN error:", err)
+		log.Fatalf("Profiling error: %v", err)
+	}
+	defer func() {
+		if err := stopProfiles(); err != nil {
+			log.Printf("Profiling error: %v", err)
+		}
+	}()
+
+	cfg := goodput.ClientConfig{
+		Addr:            *serverAddr,
+		RequestBytes:    1024 * (*requestKB),
+		ReadBuffer:      *readBuffer,
+		DiscardFirstRTT: *discardFirstRTT,
+		Window:          *window,
+		SummaryInterval: *summaryInterval,
+		PrefillBytes:    1024 * (*prefillKB),
+		PrefillLead:     1024 * (*prefillLeadKB),
+		Parallel:        *parallel,
+		Pipeline:        *pipeline,
+		Resumes:         *resumes,
+		ALPN:            goodput.ParseALPN(*alpn),
+		RootCAs:         rootCAs,
+		Insecure:        *insecure,
+		Transport:       *transport,
+		TCPTLS:          *tcpTLS,
+		QUICConfig:      quicConf,
+		ConnectTimeout:  *connectTimeout,
+		Token:           *token,
+		Hints:           hints,
+		Params:          params,
+		StateDump:       dump,
+		Live:            live,
+		PacketConn:      packetConn,
+	}
+	if *reportGaps {
+		cfg.GapThreshold = *gapThreshold
+	}
+	if *format == formatFlent {
+		cfg.Quiet = true
+		cfg.KeepSamples = true
+	}
+	if *seed != 0 {
+		transfer := goodput.DeriveSeed(*seed, "transfer")
+		cfg.TransferID = fmt.Sprintf("%016x", transfer)
+		if bundle != nil {
+			bundle.SetSeed("seed", *seed)
+			bundle.SetSeed("transfer", transfer)
+		}
+	}
+
+	// a panic mid-transfer still leaves what was collected so far
+	cfg.OnPanic = func(partial *goodput.Result) {
+		log.Printf("Transfer panicked after %.2f KB in %.3f s, goodput %.2f Mbps so far", float64(partial.Bytes)/1024.0, partial.Elapsed.Seconds(), partial.Goodput)
+		if bundle != nil {
+			writeBundle(bundle, partial)
+		}
 	}
 
-	stats := NewClientStats()
-	buf := make([]byte, 65536)
+	if controlSteps != nil {
+		controlCtx, stopControl := context.WithCancel(context.Background())
+		defer stopControl()
+		go goodput.RunControlScript(controlCtx, *controlAddr, controlSteps)
+	}
 
-	for {
-		n, err := stream.Read(buf)
-		if n > 0 {
-			stats.Add(n)
+	// result is what the results bundle records; goodput is what -min-goodput
+	// checks, the aggregate when fanning out
+	var result any
+	var goodputMbps float64
+	if *raceConnect {
+		cfg.RaceAddrs = splitList(*serverList)
+	}
+	if addrs := splitList(*serverList); len(addrs) > 0 && !*raceConnect {
+		fr, err := goodput.RunFanOut(context.Background(), cfg, addrs)
+		if err != nil {
+			if fr == nil {
+				log.Fatal(err)
+			}
+			log.Println(err)
 		}
+		result, goodputMbps = fr, fr.Goodput
+	} else {
+		start := time.Now()
+		res, err := goodput.RunClient(context.Background(), cfg)
 		if err != nil {
-			if err != io.EOF {
-				// ignore ApplicationError 0x0 (normal close signal)
-				if qe, ok := err.(*quic.ApplicationError); ok && qe.ErrorCode == 0 {
-					break
-				}
-				log.Println("Read error:", err)
+			// a transfer error still leaves partial stats, which were printed
+			if res == nil {
+				log.Fatal(err)
+			}
+			log.Println(err)
+		}
+		result, goodputMbps = res, res.Goodput
+		if *format == formatFlent {
+			host := *serverAddr
+			if res.Race != nil {
+				host = res.Race.Winner
+			}
+			if err := writeFlent(os.Stdout, res, start, host); err != nil {
+				log.Fatal("Write flent data error:", err)
 			}
-			break
 		}
 	}
 
-	stats.PrintFinal()
+	mtus.Report()
+	if capture != nil {
+		capture.Report()
+	}
+	if bundle != nil {
+		writeBundle(bundle, result)
+	}
+
+	if *minGoodput > 0 {
+		if goodputMbps < *minGoodput {
+			fmt.Printf("FAIL: goodput %.2f Mbps below minimum %.2f Mbps\n", goodputMbps, *minGoodput)
+			stopProfiles()
+			shutdownTracing()
+			os.Exit(1)
+		}
+		fmt.Printf("PASS: goodput %.2f Mbps\n", goodputMbps)
+	}
+}
+
+// writeBundle writes result into bundle and finishes it.
+func writeBundle(bundle *results.Bundle, result any) {
+	if err := bundle.WriteJSON("result.json", result); err != nil {
+		log.Fatal("Write result error:", err)
+	}
+	dir, err := bundle.Finish()
+	if err != nil {
+		log.Fatal("Results dir error:", err)
+	}
+	log.Printf("Results written to %s", dir)
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
 }
 
 // disable GSO; in Mininet’s virtual links, GSO behaves unexpectedly and
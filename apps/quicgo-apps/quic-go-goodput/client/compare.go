@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"quic-go-goodput/goodput"
+)
+
+// runCompare runs the same transfer against two servers back-to-back,
+// typically one instance per congestion controller or tuning under test,
+// and prints their goodput, RTT inflation and goodput variability side by
+// side. It returns the process exit status.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	addrA := fs.String("a", "", "server IP and port of the first side")
+	addrB := fs.String("b", "", "server IP and port of the second side")
+	labelA := fs.String("label-a", "A", "name of the first side in the table, such as the -cc its server runs")
+	labelB := fs.String("label-b", "B", "name of the second side in the table")
+	requestKB := fs.Int("n", 10240, "request_kb per run")
+	runs := fs.Int("runs", 1, "number of runs against each side, alternating between them")
+	readBuffer := fs.Int("read-buffer", 65536, "application read buffer size in bytes")
+	out := fs.String("out", "", "write the combined result of both sides as JSON to this file")
+	token := fs.String("token", "", "token to send with each request, for servers run with -token")
+	alpn := fs.String("alpn", goodput.DefaultALPN, "comma-separated ALPN protocols to propose, in order of preference")
+	caFile := fs.String("ca", "", "PEM file with the CA certificates to verify the servers against")
+	insecure := fs.Bool("insecure", false, "skip server certificate verification (for the servers' default self-signed certificates)")
+	fs.Parse(args)
+
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = goodput.LoadCertPool(*caFile); err != nil {
+			fmt.Println("FAIL: CA error:", err)
+			return 1
+		}
+	}
+
+	res, err := goodput.RunCompare(context.Background(), goodput.ClientConfig{
+		RequestBytes: 1024 * (*requestKB),
+		ReadBuffer:   *readBuffer,
+		Token:        *token,
+		ALPN:         goodput.ParseALPN(*alpn),
+		RootCAs:      rootCAs,
+		Insecure:     *insecure,
+	}, goodput.CompareTarget{Label: *labelA, Addr: *addrA}, goodput.CompareTarget{Label: *labelB, Addr: *addrB}, *runs)
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	if *out != "" {
+		data, err := json.MarshalIndent(res, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*out, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			fmt.Println("FAIL: write result:", err)
+			return 1
+		}
+	}
+	return 0
+}
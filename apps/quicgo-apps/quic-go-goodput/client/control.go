@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"quic-go-goodput/goodput"
+)
+
+// runControl sends the commands given as arguments to a server's control
+// listener (see -control-addr on the server) and prints the state each
+// leaves it in. It returns the process exit status.
+func runControl(args []string) int {
+	fs := flag.NewFlagSet("control", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8081", "control listener (host:port) of the server")
+	timeout := fs.Duration("timeout", 5*time.Second, "give up on the server after this long")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: client control [-addr host:port] <command>...")
+		fmt.Fprintln(fs.Output(), "commands: \"RATE <mbps>\", \"RATE OFF\", STOP, START, STATUS")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	replies, err := goodput.SendControl(*addr, fs.Args(), *timeout)
+	for i, reply := range replies {
+		fmt.Printf("%s: %s\n", fs.Arg(i), reply)
+	}
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+
+	"quic-go-goodput/goodput"
+)
+
+// runFairness launches -k transfers at once against the server (or spread
+// over -servers) and reports each flow's goodput and Jain's fairness index
+// over them. The server must run with -concurrent for the flows to share
+// the bottleneck. It returns the process exit status.
+func runFairness(args []string) int {
+	fs := flag.NewFlagSet("fairness", flag.ExitOnError)
+	serverAddr := fs.String("p", "127.0.0.1:8080", "server IP and port")
+	serverList := fs.String("servers", "", "comma-separated server addresses to spread the flows over round-robin (overrides -p)")
+	flows := fs.Int("k", 2, "number of competing flows")
+	requestKB := fs.Int("n", 1, "request_kb per flow")
+	readBuffer := fs.Int("read-buffer", 65536, "application read buffer size in bytes")
+	transport := fs.String("transport", goodput.TransportQUIC, "transport to run the flows over: quic, or tcp for a TCP baseline")
+	token := fs.String("token", "", "token to send with each request, for a server run with -token")
+	caFile := fs.String("ca", "", "PEM file with the CA certificates to verify the server against")
+	insecure := fs.Bool("insecure", false, "skip server certificate verification (for the servers' default self-signed certificates)")
+	fs.Parse(args)
+
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = goodput.LoadCertPool(*caFile); err != nil {
+			fmt.Println("FAIL: CA error:", err)
+			return 1
+		}
+	}
+
+	addrs := splitList(*serverList)
+	if len(addrs) == 0 {
+		addrs = []string{*serverAddr}
+	}
+	_, err := goodput.RunFairness(context.Background(), goodput.ClientConfig{
+		RequestBytes: 1024 * (*requestKB),
+		ReadBuffer:   *readBuffer,
+		RootCAs:      rootCAs,
+		Insecure:     *insecure,
+		Transport:    *transport,
+		Token:        *token,
+	}, addrs, *flows)
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	return 0
+}
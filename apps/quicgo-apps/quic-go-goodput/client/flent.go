@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"quic-go-goodput/goodput"
+)
+
+// Output formats for -format.
+const (
+	formatText  = "text"
+	formatFlent = "flent"
+)
+
+// flentVersion is the flent data file version the output follows.
+const flentVersion = 4
+
+// flentPoint is one timestamped raw value of a flent series.
+type flentPoint struct {
+	T   float64 `json:"t"`
+	Val float64 `json:"val"`
+}
+
+// flentData is the layout of a flent data file: series of per-step values
+// over the common x_values, in seconds from T0, with the same samples
+// timestamped in raw_values.
+type flentData struct {
+	Metadata  map[string]any          `json:"metadata"`
+	Version   int                     `json:"version"`
+	XValues   []float64               `json:"x_values"`
+	Results   map[string][]*float64   `json:"results"`
+	RawValues map[string][]flentPoint `json:"raw_values"`
+}
+
+// Series names in the flent output.
+const (
+	flentGoodput = "QUIC download"
+	flentRTT     = "RTT (ms)"
+)
+
+// writeFlent writes the per-second samples of res as flent data, with t0 the
+// start of the transfer and host the server it ran against. Intervals
+// without an RTT sample, as on TCP, leave the RTT series null there.
+func writeFlent(w io.Writer, res *goodput.Result, t0 time.Time, host string) error {
+	d := flentData{
+		Metadata: map[string]any{
+			"NAME":         "quic-go-goodput",
+			"TITLE":        "",
+			"HOST":         host,
+			"HOSTS":        []string{host},
+			"T0":           t0.UTC().Format("2006-01-02T15:04:05.000000Z"),
+			"TIME":         t0.UTC().Format("2006-01-02T15:04:05.000000Z"),
+			"STEP_SIZE":    1.0,
+			"LENGTH":       res.Elapsed.Seconds(),
+			"TOTAL_LENGTH": res.Elapsed.Seconds(),
+			"BYTES":        res.Bytes,
+			"GOODPUT_MBPS": res.Goodput,
+		},
+		Version: flentVersion,
+		XValues: []float64{},
+		Results: map[string][]*float64{flentGoodput: {}, flentRTT: {}},
+		RawValues: map[string][]flentPoint{
+			flentGoodput: {},
+			flentRTT:     {},
+		},
+	}
+	start := float64(t0.UnixNano()) / 1e9
+	for _, s := range res.Samples {
+		d.XValues = append(d.XValues, s.End)
+		goodput := s.Goodput
+		d.Results[flentGoodput] = append(d.Results[flentGoodput], &goodput)
+		d.RawValues[flentGoodput] = append(d.RawValues[flentGoodput], flentPoint{T: start + s.End, Val: s.Goodput})
+		if s.RTT > 0 {
+			rtt := s.RTT
+			d.Results[flentRTT] = append(d.Results[flentRTT], &rtt)
+			d.RawValues[flentRTT] = append(d.RawValues[flentRTT], flentPoint{T: start + s.End, Val: s.RTT})
+		} else {
+			d.Results[flentRTT] = append(d.Results[flentRTT], nil)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+
+	"quic-go-goodput/goodput"
+)
+
+// runHandshakeBench dials and immediately closes -k connections to the
+// server, without any GETN, and reports handshakes per second and the
+// per-handshake latency percentiles. With -0rtt every timed dial resumes a
+// session and attempts 0-RTT, which the server must run with -allow-0rtt to
+// accept. With -n each connection fetches that many bytes before closing,
+// and the payload is reported split between 0-RTT and 1-RTT packets. It
+// returns the process exit status.
+func runHandshakeBench(args []string) int {
+	fs := flag.NewFlagSet("handshake-bench", flag.ExitOnError)
+	serverAddr := fs.String("p", "127.0.0.1:8080", "server IP and port")
+	count := fs.Int("k", 100, "number of handshakes")
+	requestBytes := fs.Int("n", 0, "bytes to fetch with a GETN on each connection before closing it, 0 for none")
+	token := fs.String("token", "", "shared token to send with each request, for a server that requires one")
+	zeroRTT := fs.Bool("0rtt", false, "resume a session on every timed dial and attempt 0-RTT")
+	alpn := fs.String("alpn", goodput.DefaultALPN, "comma-separated ALPN protocols to propose, in order of preference")
+	caFile := fs.String("ca", "", "PEM file with the CA certificates to verify the server against")
+	insecure := fs.Bool("insecure", false, "skip server certificate verification (for the server's default self-signed certificate)")
+	connectTimeout := fs.Duration("connect-timeout", 0, "give up on each handshake after this long (0 leaves it to the QUIC handshake idle timeout)")
+	fs.Parse(args)
+
+	if *requestBytes < 0 {
+		fmt.Printf("FAIL: invalid -n %d: must not be negative\n", *requestBytes)
+		return 1
+	}
+	if *connectTimeout < 0 {
+		fmt.Printf("FAIL: invalid -connect-timeout %v: must not be negative\n", *connectTimeout)
+		return 1
+	}
+
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = goodput.LoadCertPool(*caFile); err != nil {
+			fmt.Println("FAIL: CA error:", err)
+			return 1
+		}
+	}
+
+	_, err := goodput.RunHandshakeBench(context.Background(), goodput.ClientConfig{
+		Addr:           *serverAddr,
+		RequestBytes:   *requestBytes,
+		Token:          *token,
+		ALPN:           goodput.ParseALPN(*alpn),
+		RootCAs:        rootCAs,
+		Insecure:       *insecure,
+		ConnectTimeout: *connectTimeout,
+	}, *count, *zeroRTT)
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"quic-go-goodput/goodput"
+)
+
+// runSelftest runs a server and a client in this process over loopback and
+// checks that the full payload arrived at no less than a goodput floor. With
+// no emulated network in the way, the goodput reflects the software overhead
+// of the stack. It returns the process exit status.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	requestKB := fs.Int("n", 10240, "request_kb")
+	floor := fs.Float64("floor", 100, "minimum goodput in Mbps for the test to pass")
+	fs.Parse(args)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		fmt.Println("FAIL: listen:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- goodput.RunServer(ctx, conn, goodput.ServerConfig{FinTimeout: 2 * time.Second}) }()
+
+	want := 1024 * (*requestKB)
+	res, err := goodput.RunClient(ctx, goodput.ClientConfig{
+		Addr:         conn.LocalAddr().String(),
+		RequestBytes: want,
+		ReadBuffer:   65536,
+		// the in-process server uses a throwaway self-signed certificate
+		Insecure: true,
+	})
+	cancel()
+	if sErr := <-serverErr; sErr != nil {
+		fmt.Println("FAIL: server:", sErr)
+		return 1
+	}
+
+	switch {
+	case err != nil:
+		fmt.Println("FAIL: client:", err)
+	case res.Bytes != want:
+		fmt.Printf("FAIL: received %d of %d bytes\n", res.Bytes, want)
+	case res.Goodput < *floor:
+		fmt.Printf("FAIL: goodput %.2f Mbps below floor %.2f Mbps\n", res.Goodput, *floor)
+	default:
+		fmt.Printf("PASS: %d bytes at %.2f Mbps\n", res.Bytes, res.Goodput)
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,60 @@
+package goodput
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// acceptedConn is a connection taken off the listener by an accept worker.
+type acceptedConn struct {
+	conn *quic.Conn
+	at   time.Time
+}
+
+// serveAccepted runs cfg.AcceptWorkers goroutines that accept connections
+// from listener into a queue of the same depth, so quic-go's own accept
+// queue keeps draining while a connection is being handled, and dispatches
+// the queued connections, logging how long each waited between accept and
+// handling.
+func serveAccepted(ctx context.Context, listener acceptor, cfg ServerConfig, wg *sync.WaitGroup) error {
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan acceptedConn, cfg.AcceptWorkers)
+	errc := make(chan error, cfg.AcceptWorkers)
+	for range cfg.AcceptWorkers {
+		go func() {
+			for {
+				conn, err := listener.Accept(workerCtx)
+				if err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case queue <- acceptedConn{conn, time.Now()}:
+				case <-workerCtx.Done():
+					conn.CloseWithError(0, "")
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case a := <-queue:
+			log.Printf("Accept-to-handle latency: %.3f ms (%d more queued)",
+				float64(time.Since(a.at).Microseconds())/1000.0, len(queue))
+			dispatch(a.conn, cfg, wg)
+		case err := <-errc:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package goodput
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"quic-go-common/results"
+)
+
+// The special group keys of Aggregate.
+const (
+	// GroupAll puts every result in one group.
+	GroupAll = ""
+	// GroupDir groups the results by the directory directly below the
+	// root they were found under, such as one per server -cc of a sweep.
+	GroupDir = "dir"
+)
+
+// Distribution summarises one metric over the runs of a group.
+type Distribution struct {
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	P50    float64 `json:"p50"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// AggregateGroup is the summary of the results sharing a key. RTT is over
+// each run's average smoothed RTT and TTFB over each run's time to first
+// byte, both in milliseconds; runs without one are left out of it.
+type AggregateGroup struct {
+	Key     string       `json:"key"`
+	Runs    int          `json:"runs"`
+	Goodput Distribution `json:"goodput_mbps"`
+	RTT     Distribution `json:"rtt_avg_ms"`
+	TTFB    Distribution `json:"ttfb_ms"`
+	// Incomplete counts the runs whose result covers only part of the
+	// transfer; they are included in the distributions.
+	Incomplete int      `json:"incomplete,omitempty"`
+	Files      []string `json:"files"`
+}
+
+// Aggregate reads every client result JSON under root, such as the
+// result.json of each -results-dir bundle, and summarises them by group.
+// The key by is GroupAll, GroupDir, a flag such as "-n" whose value is
+// read from the bundle's manifest, or a dotted path to a result field such
+// as "termination.kind". JSON files that are not client results are
+// skipped.
+func Aggregate(root, by string) ([]AggregateGroup, error) {
+	groups := make(map[string]*AggregateGroup)
+	samples := make(map[string]*[3][]float64)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" || d.Name() == "manifest.json" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var fields map[string]any
+		if json.Unmarshal(data, &fields) != nil {
+			return nil
+		}
+		if _, ok := fields["goodput_mbps"]; !ok {
+			return nil
+		}
+		var r Result
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key, err := groupKey(by, root, path, fields)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &AggregateGroup{Key: key}
+			groups[key] = g
+			samples[key] = &[3][]float64{}
+		}
+		g.Runs++
+		if r.Incomplete != "" {
+			g.Incomplete++
+		}
+		g.Files = append(g.Files, path)
+		s := samples[key]
+		s[0] = append(s[0], r.Goodput)
+		if r.RTT != nil && r.RTT.Samples > 0 {
+			s[1] = append(s[1], r.RTT.AvgMs)
+		}
+		if r.TTFB > 0 {
+			s[2] = append(s[2], toMs(r.TTFB))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no client results under %s", root)
+	}
+	var out []AggregateGroup
+	for key, g := range groups {
+		s := samples[key]
+		g.Goodput, g.RTT, g.TTFB = distributionOf(s[0]), distributionOf(s[1]), distributionOf(s[2])
+		out = append(out, *g)
+	}
+	slices.SortFunc(out, func(a, b AggregateGroup) int { return strings.Compare(a.Key, b.Key) })
+	return out, nil
+}
+
+// groupKey returns the key by of the result at path, whose fields are
+// given.
+func groupKey(by, root, path string, fields map[string]any) (string, error) {
+	switch {
+	case by == GroupAll:
+		return "all", nil
+	case by == GroupDir:
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return "", err
+		}
+		dir, _, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok {
+			return "(top level)", nil
+		}
+		return dir, nil
+	case strings.HasPrefix(by, "-"):
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "manifest.json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "(no manifest)", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		var m results.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return "", fmt.Errorf("manifest: %w", err)
+		}
+		// the effective config also has the values a -scenario file set
+		if v, ok := m.Config[strings.TrimLeft(by, "-")]; ok {
+			return v, nil
+		}
+		if v, ok := flagValue(m.Args, strings.TrimLeft(by, "-")); ok {
+			return v, nil
+		}
+		return "(default)", nil
+	}
+	var v any = fields
+	for _, name := range strings.Split(by, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "(none)", nil
+		}
+		if v, ok = obj[name]; !ok {
+			return "(none)", nil
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// flagValue returns the value of the flag name in the command line args,
+// the last one if it is repeated. A flag followed by another, or by
+// nothing, is a boolean set to true.
+func flagValue(args []string, name string) (string, bool) {
+	var val string
+	var found bool
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flagName, v, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if flagName != name {
+			continue
+		}
+		found = true
+		switch {
+		case hasValue:
+			val = v
+		case i+1 < len(args) && !strings.HasPrefix(args[i+1], "-"):
+			val = args[i+1]
+			i++
+		default:
+			val = "true"
+		}
+	}
+	return val, found
+}
+
+// distributionOf summarises xs.
+func distributionOf(xs []float64) Distribution {
+	if len(xs) == 0 {
+		return Distribution{}
+	}
+	sorted := slices.Clone(xs)
+	slices.Sort(sorted)
+	var sum float64
+	for _, x := range sorted {
+		sum += x
+	}
+	mean := sum / float64(len(sorted))
+	var sq float64
+	for _, x := range sorted {
+		sq += (x - mean) * (x - mean)
+	}
+	at := func(p float64) float64 { return sorted[int(p*float64(len(sorted)-1)+0.5)] }
+	return Distribution{
+		N:      len(sorted),
+		Mean:   mean,
+		Stddev: math.Sqrt(sq / float64(len(sorted))),
+		Min:    sorted[0],
+		P50:    at(0.50),
+		P95:    at(0.95),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// PrintAggregate prints a table of the groups, one row per metric.
+func PrintAggregate(groups []AggregateGroup, by string) {
+	if by == GroupAll {
+		by = "all runs"
+	}
+	fmt.Printf("Results grouped by %s\n", by)
+	fmt.Printf("%-20s %-14s %5s %10s %10s %10s %10s %10s %10s\n", "group", "metric", "n", "mean", "stddev", "min", "p50", "p95", "max")
+	for _, g := range groups {
+		key := g.Key
+		if g.Incomplete > 0 {
+			key = fmt.Sprintf("%s (%d incomplete)", key, g.Incomplete)
+		}
+		for _, m := range []struct {
+			name string
+			d    Distribution
+		}{{"goodput Mbps", g.Goodput}, {"rtt avg ms", g.RTT}, {"ttfb ms", g.TTFB}} {
+			if m.d.N == 0 {
+				fmt.Printf("%-20s %-14s %5d %10s\n", key, m.name, 0, "n/a")
+			} else {
+				fmt.Printf("%-20s %-14s %5d %10.2f %10.2f %10.2f %10.2f %10.2f %10.2f\n",
+					key, m.name, m.d.N, m.d.Mean, m.d.Stddev, m.d.Min, m.d.P50, m.d.P95, m.d.Max)
+			}
+			key = ""
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package goodput
+
+import "strings"
+
+// DefaultALPN is the ALPN protocol list used by both endpoints unless
+// overridden.
+const DefaultALPN = "http/0.9"
+
+// ParseALPN splits a comma-separated ALPN protocol list, dropping empty
+// entries.
+func ParseALPN(list string) []string {
+	var protos []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protos = append(protos, p)
+		}
+	}
+	return protos
+}
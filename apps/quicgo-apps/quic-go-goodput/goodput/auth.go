@@ -0,0 +1,40 @@
+package goodput
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+)
+
+// AuthErrorCode is the application error code the server closes a QUIC
+// connection with when the request does not carry its token; 0x191 is 401.
+const AuthErrorCode quic.ApplicationErrorCode = 0x191
+
+// authPrefix marks the token field a client appends to its request lines.
+const authPrefix = "auth="
+
+// authField returns the field carrying token, with its leading space, for
+// appending to a request line; it is empty when there is no token.
+func authField(token string) string {
+	if token == "" {
+		return ""
+	}
+	return " " + authPrefix + token
+}
+
+// authenticate strips the trailing auth=<token> field from request and
+// reports whether it matches token. Every request passes when token is
+// empty. The token only keeps stray clients off a shared server; it is sent
+// in the clear over TCP without -tcp-tls.
+func authenticate(request, token string) (string, bool) {
+	got := ""
+	if i := strings.LastIndex(request, " "+authPrefix); i >= 0 && !strings.Contains(request[i+1:], " ") {
+		got = request[i+1+len(authPrefix):]
+		request = strings.TrimSpace(request[:i])
+	}
+	if token == "" {
+		return request, true
+	}
+	return request, subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
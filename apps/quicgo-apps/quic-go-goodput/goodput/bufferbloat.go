@@ -0,0 +1,198 @@
+package goodput
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// servePing echoes request and every line following it on the stream until
+// the client closes its side. It is the server side of RunBufferbloat.
+func servePing(stream *quic.Stream, rd *bufio.Reader, request string) {
+	defer stream.Close()
+	for {
+		if _, err := stream.Write([]byte(request + "\r\n")); err != nil {
+			log.Println("Write error:", err)
+			return
+		}
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			var qe *quic.ApplicationError
+			if err != io.EOF && !(errors.As(err, &qe) && qe.ErrorCode == 0) {
+				log.Println("Read error:", err)
+			}
+			return
+		}
+		request = strings.TrimSpace(line)
+	}
+}
+
+// BufferbloatResult compares the ping RTT on an idle connection with the
+// ping RTT while a bulk download saturates it. The RTTs are in
+// milliseconds.
+type BufferbloatResult struct {
+	IdlePings   int     `json:"idle_pings"`
+	LoadedPings int     `json:"loaded_pings"`
+	IdleP50Ms   float64 `json:"idle_rtt_p50_ms"`
+	LoadedP50Ms float64 `json:"loaded_rtt_p50_ms"`
+	LoadedP90Ms float64 `json:"loaded_rtt_p90_ms"`
+	LoadedMaxMs float64 `json:"loaded_rtt_max_ms"`
+	// Inflation is the loaded median RTT over the idle one.
+	Inflation float64 `json:"inflation"`
+	// Download is the bulk transfer that loaded the connection.
+	Download *Result `json:"download"`
+}
+
+// RunBufferbloat measures latency under load on one connection: it first
+// sends idle PINGs, one every interval, on a stream of their own, and then
+// keeps pinging while a GETP download of cfg.RequestBytes runs on a second
+// stream, reporting how much the ping RTT inflates while the download fills
+// the queues along the path.
+func RunBufferbloat(ctx context.Context, cfg ClientConfig, idlePings int, interval time.Duration) (*BufferbloatResult, error) {
+	if idlePings <= 0 {
+		return nil, fmt.Errorf("invalid idle ping count %d: must be positive", idlePings)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid ping interval %v: must be positive", interval)
+	}
+	if cfg.RequestBytes <= 0 {
+		return nil, fmt.Errorf("invalid download size %d: must be positive", cfg.RequestBytes)
+	}
+	if cfg.Transport != "" && cfg.Transport != TransportQUIC {
+		return nil, errors.New("the bufferbloat test needs the QUIC transport")
+	}
+	if cfg.RootCAs == nil && !cfg.Insecure {
+		return nil, errNoTrust
+	}
+
+	session, err := dial(ctx, cfg, clientTLSConfig(cfg))
+	if err != nil {
+		return nil, dialError(err)
+	}
+	defer session.CloseWithError(0, "")
+
+	pings, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	defer pings.Close()
+	rd := bufio.NewReader(pings)
+	seq := 0
+	ping := func() (time.Duration, error) {
+		line := fmt.Sprintf("PING %d", seq)
+		if seq == 0 {
+			line += authField(cfg.Token)
+		}
+		seq++
+		start := time.Now()
+		if _, err := pings.Write([]byte(line + "\r\n")); err != nil {
+			return 0, fmt.Errorf("write PING: %w", err)
+		}
+		if _, err := rd.ReadString('\n'); err != nil {
+			return 0, fmt.Errorf("read PING: %w", err)
+		}
+		return time.Since(start), nil
+	}
+
+	var idle []time.Duration
+	for range idlePings {
+		rtt, err := ping()
+		if err != nil {
+			return nil, err
+		}
+		idle = append(idle, rtt)
+		time.Sleep(max(0, interval-rtt))
+	}
+
+	download := make(chan error, 1)
+	stats := NewClientStats()
+	stats.quiet = true
+	go func() {
+		download <- fetchParallel(ctx, session, cfg, stats)
+	}()
+
+	var loaded []time.Duration
+	var downloadErr error
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+loop:
+	for {
+		rtt, err := ping()
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, rtt)
+		select {
+		case downloadErr = <-download:
+			break loop
+		case <-tick.C:
+		}
+	}
+	if downloadErr != nil {
+		return nil, fmt.Errorf("download: %w", downloadErr)
+	}
+
+	slices.Sort(idle)
+	slices.Sort(loaded)
+	res := &BufferbloatResult{
+		IdlePings:   len(idle),
+		LoadedPings: len(loaded),
+		IdleP50Ms:   toMs(percentile(idle, 0.50)),
+		LoadedP50Ms: toMs(percentile(loaded, 0.50)),
+		LoadedP90Ms: toMs(percentile(loaded, 0.90)),
+		LoadedMaxMs: toMs(loaded[len(loaded)-1]),
+		Download: &Result{
+			Bytes:   stats.bytesRecv,
+			Elapsed: time.Since(stats.startTime),
+			Goodput: stats.Goodput(),
+			TTFB:    stats.TTFB(),
+		},
+	}
+	if res.IdleP50Ms > 0 {
+		res.Inflation = res.LoadedP50Ms / res.IdleP50Ms
+	}
+
+	fmt.Printf("Idle RTT over %d pings: p50 %.2f ms\n", res.IdlePings, res.IdleP50Ms)
+	fmt.Printf("Loaded RTT over %d pings: p50 %.2f ms, p90 %.2f ms, max %.2f ms\n",
+		res.LoadedPings, res.LoadedP50Ms, res.LoadedP90Ms, res.LoadedMaxMs)
+	fmt.Printf("Download %.2f KB in %.3f s, goodput: %.2f Mbps\n",
+		float64(res.Download.Bytes)/1024.0, res.Download.Elapsed.Seconds(), res.Download.Goodput)
+	fmt.Printf("RTT inflation under load: %.2fx\n", res.Inflation)
+	return res, nil
+}
+
+// fetchParallel reads a GETP response for cfg.RequestBytes into stats.
+func fetchParallel(ctx context.Context, session *quic.Conn, cfg ClientConfig, stats *ClientStats) error {
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	if _, err := stream.Write([]byte(fmt.Sprintf("GETP %d%s\r\n", cfg.RequestBytes, authField(cfg.Token)))); err != nil {
+		return fmt.Errorf("write GETP: %w", err)
+	}
+	buf := make([]byte, cfg.ReadBuffer)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			stats.Add(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if stats.bytesRecv != cfg.RequestBytes {
+		return fmt.Errorf("received %d of %d bytes", stats.bytesRecv, cfg.RequestBytes)
+	}
+	return nil
+}
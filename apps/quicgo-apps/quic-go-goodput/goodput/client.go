@@ -0,0 +1,510 @@
+// Package goodput implements the GETN goodput benchmark: a server that
+// answers "GETN <bytes>" with that many payload bytes on the request stream,
+// and a client that requests them and measures the achieved goodput.
+package goodput
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/qtrace"
+	"quic-go-common/telemetry"
+)
+
+// ClientConfig describes a single GETN transfer.
+type ClientConfig struct {
+	// Addr is the server's host:port.
+	Addr string
+	// RequestBytes is the number of payload bytes to request.
+	RequestBytes int
+	// ReadBuffer is the size of the application read buffer. A larger buffer
+	// drains more stream data per Read call, which cuts per-call overhead on
+	// high-BDP links, at the cost of memory and coarser ClientStats updates.
+	ReadBuffer int
+	// DiscardFirstRTT additionally reports goodput excluding the first RTT
+	// (estimated from TTFB) of the transfer.
+	DiscardFirstRTT bool
+	// GapThreshold, if positive, looks for stretches of at least this long
+	// in which no payload arrived, from the first byte on, and returns them
+	// in Result.Gaps.
+	GapThreshold time.Duration
+	// Window adds a sliding average over this many seconds to the
+	// per-second progress lines; zero disables it.
+	Window int
+	// SummaryInterval prints a line with the cumulative bytes and goodput,
+	// the current RTT and the loss so far every this many seconds; zero
+	// disables it.
+	SummaryInterval int
+	// PrefillBytes of cover traffic are fetched on the same connection right
+	// before the measured transfer, to bring network queues to steady state.
+	// Unlike a congestion-control warmup, this targets buffer occupancy.
+	PrefillBytes int
+	// PrefillLead is how many bytes of the prefill must arrive before the
+	// measured request is sent on its own stream. The rest of the FILL
+	// response stays in flight alongside the transfer, so the queues are
+	// still full when it starts; zero sends the request right after FILL,
+	// and PrefillBytes or more waits for the whole prefill.
+	PrefillLead int
+	// Parallel splits the request evenly across this many concurrent streams
+	// on the connection; values below 2 use a single GETN stream.
+	Parallel int
+	// Pipeline splits the request evenly across this many GETL requests
+	// sent back-to-back on a single stream; values below 2 use a single
+	// GETN. It cannot be combined with Parallel.
+	Pipeline int
+	// ALPN is the list of protocols proposed to the server, in order of
+	// preference; DefaultALPN is used when it is empty.
+	ALPN []string
+	// RootCAs verifies the server certificate. Leaving it nil requires
+	// Insecure, which skips verification altogether, e.g. for the
+	// self-signed certificate the server generates by default.
+	RootCAs  *x509.CertPool
+	Insecure bool
+	// Transport is TransportQUIC (the default when empty) or TransportTCP.
+	Transport string
+	// TCPTLS wraps the TCP transport in TLS.
+	TCPTLS bool
+	// QUICConfig is passed to quic.Dial; nil uses the quic-go defaults.
+	QUICConfig *quic.Config
+	// ConnectTimeout bounds establishing each connection, up to the end of
+	// the QUIC or TCP and TLS handshake, apart from the transfer that
+	// follows. Zero leaves it to the QUIC handshake idle timeout, or to the
+	// OS for TCP.
+	ConnectTimeout time.Duration
+	// Resumes, if positive, fetches the request as a resumable transfer
+	// (GETRESUME) and reconnects up to this many times to continue an
+	// interrupted transfer from the last byte received. TransferID names
+	// the transfer on the server; empty picks a random one.
+	Resumes    int
+	TransferID string
+	// Token is sent with each request for a server that requires one.
+	Token string
+	// Hints, from ParseHints, are sent with the GETN and FILL requests to
+	// configure the connection on a server running with -accept-hints.
+	Hints []string
+	// KeepSamples records each progress interval in Result.Samples, even
+	// when Quiet.
+	KeepSamples bool
+	// Quiet suppresses the progress lines and the final summary; the Result
+	// is filled in all the same.
+	Quiet bool
+	// Params, if set, must also be installed as QUICConfig.Tracer. The
+	// negotiated QUIC version and transport parameters are then logged once
+	// the handshake completes and returned in Result.Negotiated.
+	Params *qtrace.ParamsRecorder
+	// StateDump, if set, must also be installed as QUICConfig.Tracer; each
+	// connection's final state is then written to it once it closes.
+	StateDump *qtrace.StateDump
+	// Live, if set, receives each progress interval of the transfer.
+	Live *LivePublisher
+	// RaceAddrs, if set, are alternative addresses of the server to dial
+	// all at once instead of Addr, running the transfer on whichever
+	// completes its handshake first.
+	RaceAddrs []string
+	// PacketConn, if set, carries the QUIC connection instead of a fresh UDP
+	// socket, e.g. a relay.Conn through a SOCKS5 proxy. The caller keeps
+	// ownership and closes it after RunClient returns.
+	PacketConn net.PacketConn
+	// OnPanic, if set, is called with what the transfer got to when it
+	// panics, as a Result marked Incomplete, before the panic goes on. Only
+	// panics on the goroutine running RunClient are caught, not those in
+	// the parallel stream readers or on quic-go's own goroutines, such as
+	// in a tracer.
+	OnPanic func(partial *Result)
+
+	// salvage is set up by RunClient for OnPanic.
+	salvage *salvage
+}
+
+// Result summarises a completed transfer.
+type Result struct {
+	Bytes   int           `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Goodput is in Mbps.
+	Goodput float64       `json:"goodput_mbps"`
+	TTFB    time.Duration `json:"ttfb_ns"`
+	// AdjustedGoodput is set when DiscardFirstRTT was requested and the
+	// transfer outlasted the first RTT.
+	AdjustedGoodput float64 `json:"adjusted_goodput_mbps,omitempty"`
+	// PrefillDuration is how long the prefill transfer took, if any.
+	PrefillDuration time.Duration `json:"prefill_ns,omitempty"`
+	// Streams holds the per-stream breakdown of a parallel transfer.
+	Streams []StreamResult `json:"streams,omitempty"`
+	// Requests holds the per-response timing of a pipelined transfer.
+	Requests []RequestResult `json:"requests,omitempty"`
+	// Resumes is how many times a resumable transfer reconnected.
+	Resumes int `json:"resumes,omitempty"`
+	// Termination is how the transfer ended.
+	Termination Termination `json:"termination"`
+	// RTT is the range of the smoothed RTT sampled during a QUIC transfer.
+	RTT *RTTSummary `json:"rtt,omitempty"`
+	// Gaps are the stretches without payload, if ClientConfig.GapThreshold
+	// was set.
+	Gaps []Gap `json:"gaps,omitempty"`
+	// Samples are the progress intervals, if ClientConfig.KeepSamples was
+	// set.
+	Samples []Sample `json:"samples,omitempty"`
+	// Negotiated is what the (first) connection negotiated, if
+	// ClientConfig.Params was set.
+	Negotiated *qtrace.Negotiated `json:"negotiated,omitempty"`
+	// Race is how the connection race went, if ClientConfig.RaceAddrs
+	// was set.
+	Race *RaceResult `json:"race,omitempty"`
+	// Incomplete, if set, is why the result only covers part of the
+	// transfer.
+	Incomplete string `json:"incomplete,omitempty"`
+}
+
+// RunClient dials the server, requests cfg.RequestBytes and reads the
+// response to completion, printing per-second progress to stdout.
+func RunClient(ctx context.Context, cfg ClientConfig) (*Result, error) {
+	if cfg.OnPanic != nil {
+		cfg.salvage = &salvage{}
+		defer func() {
+			if r := recover(); r != nil {
+				cfg.OnPanic(cfg.salvage.result(r))
+				panic(r)
+			}
+		}()
+	}
+	res, err := runClient(ctx, cfg)
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == AuthErrorCode {
+		err = fmt.Errorf("server rejected the request token: %w", err)
+	}
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == HandshakeOnlyErrorCode {
+		err = fmt.Errorf("server only completes handshakes (-handshake-only): %w", err)
+	}
+	if res != nil && !cfg.Quiet {
+		log.Printf("Transfer ended: %s", res.Termination)
+	}
+	return res, err
+}
+
+func runClient(ctx context.Context, cfg ClientConfig) (*Result, error) {
+	if cfg.ReadBuffer <= 0 {
+		return nil, fmt.Errorf("invalid read buffer size %d: must be positive", cfg.ReadBuffer)
+	}
+	if cfg.Parallel > 1 && cfg.Pipeline > 1 {
+		return nil, errors.New("parallel streams and pipelining cannot be combined")
+	}
+	if cfg.Resumes > 0 && (cfg.Parallel > 1 || cfg.Pipeline > 1 || cfg.PrefillBytes > 0) {
+		return nil, errors.New("resumable transfers cannot be combined with parallel streams, pipelining or prefill")
+	}
+	if len(cfg.RaceAddrs) > 0 && (cfg.Resumes > 0 || cfg.PacketConn != nil || cfg.Transport == TransportTCP) {
+		return nil, errors.New("connection races cannot be combined with resumable transfers, relays or the tcp transport")
+	}
+	if cfg.RootCAs == nil && !cfg.Insecure {
+		return nil, errNoTrust
+	}
+
+	tlsConf := clientTLSConfig(cfg)
+
+	switch cfg.Transport {
+	case "", TransportQUIC:
+	case TransportTCP:
+		if cfg.PacketConn != nil {
+			return nil, errors.New("a custom PacketConn needs the QUIC transport")
+		}
+		if cfg.Resumes > 0 {
+			return nil, errors.New("resumable transfers need the QUIC transport")
+		}
+		return runTCPClient(ctx, cfg, tlsConf)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+
+	ctx, connSpan := telemetry.Tracer().Start(ctx, "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", cfg.Addr))
+	defer connSpan.End()
+
+	if cfg.Resumes > 0 {
+		return runResumable(ctx, cfg, tlsConf)
+	}
+
+	_, hsSpan := telemetry.Tracer().Start(ctx, "handshake")
+	var session *quic.Conn
+	var race func() *RaceResult
+	var err error
+	if len(cfg.RaceAddrs) > 0 {
+		session, race, err = raceDial(ctx, cfg, tlsConf)
+	} else {
+		session, err = dial(ctx, cfg, tlsConf)
+	}
+	hsSpan.End()
+	if err != nil {
+		return nil, dialError(err)
+	}
+	defer func() {
+		session.CloseWithError(0, "")
+		recordState(cfg.StateDump, session)
+	}()
+	log.Printf("Negotiated ALPN: %s", session.ConnectionState().TLS.NegotiatedProtocol)
+
+	res, err := runSession(ctx, session, cfg)
+	if race != nil {
+		r := race()
+		if !cfg.Quiet {
+			logRace(r)
+		}
+		if res != nil {
+			res.Race = r
+		}
+	}
+	return res, err
+}
+
+// runSession runs the transfer cfg describes on an established session.
+func runSession(ctx context.Context, session *quic.Conn, cfg ClientConfig) (*Result, error) {
+	negotiated := logNegotiated(session, cfg.Params)
+
+	if cfg.PrefillBytes <= 0 {
+		return runTransfer(ctx, session, cfg, negotiated)
+	}
+	wait, err := startPrefill(ctx, session, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("prefill: %w", err)
+	}
+	res, err := runTransfer(ctx, session, cfg, negotiated)
+	prefill, perr := wait()
+	if err != nil {
+		return res, err
+	}
+	if perr != nil {
+		return nil, fmt.Errorf("prefill: %w", perr)
+	}
+	if !cfg.Quiet {
+		fmt.Printf("Prefill %.2f KB in %.3f s\n", float64(cfg.PrefillBytes)/1024.0, prefill.Seconds())
+	}
+	res.PrefillDuration = prefill
+	return res, nil
+}
+
+// runTransfer runs the measured request of cfg on session.
+func runTransfer(ctx context.Context, session *quic.Conn, cfg ClientConfig, negotiated *qtrace.Negotiated) (*Result, error) {
+	if cfg.Parallel > 1 || cfg.Pipeline > 1 {
+		run := runParallel
+		if cfg.Pipeline > 1 {
+			run = runPipelined
+		}
+		res, err := run(ctx, session, cfg)
+		if res != nil {
+			res.Negotiated = negotiated
+		}
+		return res, err
+	}
+
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		reqSpan.End()
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+
+	// send a GETN request
+	cmd := fmt.Sprintf("GETN %d%s%s\r\n", cfg.RequestBytes, hintFields(cfg.Hints), authField(cfg.Token))
+	if _, err := stream.Write([]byte(cmd)); err != nil {
+		reqSpan.End()
+		return nil, fmt.Errorf("write GETN: %w", err)
+	}
+	reqSpan.SetAttributes(attribute.Int("request_bytes", cfg.RequestBytes))
+	reqSpan.End()
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	stats := NewClientStats()
+	cfg.salvage.track(stats)
+	stats.discardFirstRTT = cfg.DiscardFirstRTT
+	stats.window = newSlidingWindow(cfg.Window)
+	stats.summaryEvery = cfg.SummaryInterval
+	stats.quiet = cfg.Quiet
+	stats.keepSamples = cfg.KeepSamples
+	stats.gapThreshold = cfg.GapThreshold
+	stats.live = cfg.Live
+	stats.watch(session)
+	buf := make([]byte, cfg.ReadBuffer)
+
+	var readErr, end error
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			stats.Add(n)
+		}
+		if err != nil {
+			end = err
+			// io.EOF and ApplicationError 0x0 are the normal close signals
+			var qe *quic.ApplicationError
+			if err != io.EOF && !(errors.As(err, &qe) && qe.ErrorCode == 0) {
+				readErr = fmt.Errorf("read: %w", err)
+			}
+			break
+		}
+	}
+
+	stats.PrintFinal()
+	res := &Result{
+		Bytes:   stats.bytesRecv,
+		Elapsed: time.Since(stats.startTime),
+		Goodput: stats.Goodput(),
+		TTFB:    stats.TTFB(),
+
+		Termination: terminationOf(end),
+		RTT:         stats.RTT(),
+		Samples:     stats.Samples(),
+		Gaps:        stats.Gaps(),
+		Negotiated:  negotiated,
+	}
+	if cfg.DiscardFirstRTT {
+		res.AdjustedGoodput, _ = stats.AdjustedGoodput()
+	}
+	xferSpan.SetAttributes(attribute.Int("bytes", res.Bytes), attribute.Float64("goodput_mbps", res.Goodput))
+	return res, readErr
+}
+
+// clientTLSConfig returns the TLS configuration the client dials with.
+func clientTLSConfig(cfg ClientConfig) *tls.Config {
+	alpn := cfg.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{DefaultALPN}
+	}
+	return &tls.Config{
+		RootCAs:            cfg.RootCAs,
+		InsecureSkipVerify: cfg.RootCAs == nil && cfg.Insecure,
+		NextProtos:         alpn,
+	}
+}
+
+// logNegotiated logs and returns what conn negotiated, if params traced it.
+func logNegotiated(conn *quic.Conn, params *qtrace.ParamsRecorder) *qtrace.Negotiated {
+	if params == nil {
+		return nil
+	}
+	n := params.Negotiated(conn)
+	n.Log()
+	return &n
+}
+
+// recordState writes the final state of conn, which must have closed, to
+// dump, if set.
+func recordState(dump *qtrace.StateDump, conn *quic.Conn) {
+	if err := dump.Record(conn); err != nil {
+		log.Printf("State dump error: %v", err)
+	}
+}
+
+// dial opens the QUIC connection, on cfg.PacketConn if one was given.
+func dial(ctx context.Context, cfg ClientConfig, tlsConf *tls.Config) (*quic.Conn, error) {
+	ctx, cancel := connectContext(ctx, cfg.ConnectTimeout)
+	defer cancel()
+	quicConf := connectQUICConfig(cfg.QUICConfig, cfg.ConnectTimeout)
+	var conn *quic.Conn
+	var err error
+	if cfg.PacketConn == nil {
+		conn, err = quic.DialAddr(ctx, cfg.Addr, tlsConf, quicConf)
+	} else {
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr("udp", cfg.Addr); err == nil {
+			conn, err = quic.Dial(ctx, cfg.PacketConn, addr, tlsConf, quicConf)
+		}
+	}
+	return conn, connectError(ctx, err, cfg.Addr, cfg.ConnectTimeout)
+}
+
+// connectContext bounds ctx by the connect timeout, if there is one.
+func connectContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// connectQUICConfig raises the handshake idle timeout of conf to the
+// connect timeout if that is longer, so that the connect timeout is what
+// gives up on an unresponsive server.
+func connectQUICConfig(conf *quic.Config, timeout time.Duration) *quic.Config {
+	idle := 5 * time.Second // the quic-go default
+	if conf != nil && conf.HandshakeIdleTimeout > 0 {
+		idle = conf.HandshakeIdleTimeout
+	}
+	if timeout <= idle {
+		return conf
+	}
+	if conf == nil {
+		conf = &quic.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	conf.HandshakeIdleTimeout = timeout
+	return conf
+}
+
+// connectError tells a dial that ran out of its connect timeout apart from
+// one that failed otherwise.
+func connectError(ctx context.Context, err error, addr string, timeout time.Duration) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0 {
+		return fmt.Errorf("connect timeout: no connection to %s within %v: %w", addr, timeout, err)
+	}
+	return err
+}
+
+// startPrefill requests cfg.PrefillBytes of cover traffic with a FILL
+// request, which the server answers like GETN but without closing the
+// connection afterwards, and returns once cfg.PrefillLead bytes of it have
+// arrived. The rest is read in the background; wait returns once the whole
+// prefill has arrived, with how long it took.
+func startPrefill(ctx context.Context, session *quic.Conn, cfg ClientConfig) (wait func() (time.Duration, error), err error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "prefill")
+	n := cfg.PrefillBytes
+	lead := min(max(cfg.PrefillLead, 0), n)
+
+	start := time.Now()
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		span.End()
+		return nil, err
+	}
+	if _, err := stream.Write([]byte(fmt.Sprintf("FILL %d%s%s\r\n", n, hintFields(cfg.Hints), authField(cfg.Token)))); err != nil {
+		span.End()
+		return nil, err
+	}
+	buf := make([]byte, cfg.ReadBuffer)
+	if got, err := io.CopyBuffer(io.Discard, io.LimitReader(stream, int64(lead)), buf); err != nil || int(got) != lead {
+		span.End()
+		if err == nil {
+			err = fmt.Errorf("received %d of %d bytes", got, n)
+		}
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("lead_bytes", lead))
+
+	type outcome struct {
+		elapsed time.Duration
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer span.End()
+		rest, err := io.CopyBuffer(io.Discard, stream, buf)
+		if err == nil && lead+int(rest) != n {
+			err = fmt.Errorf("received %d of %d bytes", lead+int(rest), n)
+		}
+		if err == nil {
+			span.SetAttributes(attribute.Int("bytes", n))
+		}
+		done <- outcome{time.Since(start), err}
+	}()
+	return func() (time.Duration, error) {
+		o := <-done
+		return o.elapsed, o.err
+	}, nil
+}
@@ -0,0 +1,154 @@
+package goodput
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CompareTarget is one side of a comparison: a server, labelled with what
+// sets it apart, such as the -cc or tuning it was started with.
+type CompareTarget struct {
+	Label string `json:"label"`
+	Addr  string `json:"addr"`
+}
+
+// CompareSide aggregates the runs against one target. Goodput is the mean
+// over the runs, in Mbps; GoodputCV is the coefficient of variation of the
+// per-second goodput over all of them, a measure of how steady the
+// transfer was. The RTTs are in milliseconds, and RTTInflation is the
+// average RTT over the minimum.
+type CompareSide struct {
+	CompareTarget
+	Goodput      float64   `json:"goodput_mbps"`
+	GoodputCV    float64   `json:"goodput_cv"`
+	RTTMinMs     float64   `json:"rtt_min_ms,omitempty"`
+	RTTAvgMs     float64   `json:"rtt_avg_ms,omitempty"`
+	RTTMaxMs     float64   `json:"rtt_max_ms,omitempty"`
+	RTTInflation float64   `json:"rtt_inflation,omitempty"`
+	Runs         []*Result `json:"runs"`
+}
+
+// CompareResult is a head-to-head comparison of two targets.
+type CompareResult struct {
+	RequestBytes int         `json:"request_bytes"`
+	A            CompareSide `json:"a"`
+	B            CompareSide `json:"b"`
+	// Ratio is B's goodput over A's.
+	Ratio float64 `json:"goodput_ratio"`
+}
+
+// RunCompare runs the transfer described by cfg against a and then b, runs
+// times each, alternating so that both see the same drift in the link
+// conditions, and prints a side-by-side table. cfg.Addr is ignored. The
+// congestion controller is the server's, so comparing two of them means
+// starting one server with each.
+func RunCompare(ctx context.Context, cfg ClientConfig, a, b CompareTarget, runs int) (*CompareResult, error) {
+	if runs < 1 {
+		return nil, fmt.Errorf("invalid run count %d: must be positive", runs)
+	}
+	if a.Addr == "" || b.Addr == "" {
+		return nil, errors.New("both servers must be given")
+	}
+	cfg.Quiet = true
+	cfg.KeepSamples = true
+
+	res := &CompareResult{RequestBytes: cfg.RequestBytes, A: CompareSide{CompareTarget: a}, B: CompareSide{CompareTarget: b}}
+	for i := range runs {
+		for _, side := range []*CompareSide{&res.A, &res.B} {
+			c := cfg
+			c.Addr = side.Addr
+			r, err := RunClient(ctx, c)
+			if err != nil {
+				return nil, fmt.Errorf("run %d against %s (%s): %w", i+1, side.Label, side.Addr, err)
+			}
+			fmt.Printf("Run %d, %s: %.2f KB in %.3f s, goodput %.2f Mbps\n",
+				i+1, side.Label, float64(r.Bytes)/1024.0, r.Elapsed.Seconds(), r.Goodput)
+			side.Runs = append(side.Runs, r)
+			// let the bottleneck queue drain before the next run
+			time.Sleep(time.Second)
+		}
+	}
+	res.A.summarize()
+	res.B.summarize()
+	if res.A.Goodput > 0 {
+		res.Ratio = res.B.Goodput / res.A.Goodput
+	}
+	printCompareTable(res)
+	return res, nil
+}
+
+// summarize fills in the aggregates of s from its runs.
+func (s *CompareSide) summarize() {
+	var goodputs []float64
+	var rttSamples int
+	var rttSum float64
+	for _, r := range s.Runs {
+		s.Goodput += r.Goodput / float64(len(s.Runs))
+		for _, smp := range r.Samples {
+			goodputs = append(goodputs, smp.Goodput)
+		}
+		if r.RTT == nil || r.RTT.Samples == 0 {
+			continue
+		}
+		if rttSamples == 0 || r.RTT.MinMs < s.RTTMinMs {
+			s.RTTMinMs = r.RTT.MinMs
+		}
+		s.RTTMaxMs = max(s.RTTMaxMs, r.RTT.MaxMs)
+		rttSum += r.RTT.AvgMs * float64(r.RTT.Samples)
+		rttSamples += r.RTT.Samples
+	}
+	if rttSamples > 0 {
+		s.RTTAvgMs = rttSum / float64(rttSamples)
+		if s.RTTMinMs > 0 {
+			s.RTTInflation = s.RTTAvgMs / s.RTTMinMs
+		}
+	}
+	s.GoodputCV = coefficientOfVariation(goodputs)
+}
+
+// coefficientOfVariation returns the standard deviation of xs over their
+// mean, or 0 if the mean is.
+func coefficientOfVariation(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	if len(xs) == 0 || sum == 0 {
+		return 0
+	}
+	mean := sum / float64(len(xs))
+	var sq float64
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return math.Sqrt(sq/float64(len(xs))) / mean
+}
+
+// printCompareTable prints the two sides of a comparison next to each other.
+func printCompareTable(res *CompareResult) {
+	width := max(len(res.A.Label), len(res.A.Addr), len(res.B.Label), len(res.B.Addr), 10)
+	row := func(name, a, b string) {
+		fmt.Printf("%-20s %*s %*s\n", name, width, a, width, b)
+	}
+	ms := func(v float64) string {
+		if v == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.2f", v)
+	}
+	row("", res.A.Label, res.B.Label)
+	row("server", res.A.Addr, res.B.Addr)
+	row("runs", fmt.Sprint(len(res.A.Runs)), fmt.Sprint(len(res.B.Runs)))
+	row("goodput (Mbps)", fmt.Sprintf("%.2f", res.A.Goodput), fmt.Sprintf("%.2f", res.B.Goodput))
+	row("goodput CV", fmt.Sprintf("%.3f", res.A.GoodputCV), fmt.Sprintf("%.3f", res.B.GoodputCV))
+	row("RTT min (ms)", ms(res.A.RTTMinMs), ms(res.B.RTTMinMs))
+	row("RTT avg (ms)", ms(res.A.RTTAvgMs), ms(res.B.RTTAvgMs))
+	row("RTT max (ms)", ms(res.A.RTTMaxMs), ms(res.B.RTTMaxMs))
+	row("RTT inflation (x)", ms(res.A.RTTInflation), ms(res.B.RTTInflation))
+	if res.Ratio > 0 {
+		fmt.Printf("Goodput of %s over %s: %.2fx\n", res.B.Label, res.A.Label, res.Ratio)
+	}
+}
@@ -0,0 +1,244 @@
+package goodput
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Control is the state a control connection steers, apart from the data
+// connections it affects. The control protocol runs over TCP, one command
+// per line, each answered with one line, "OK <state>" or "ERR <reason>":
+//
+//	RATE <mbps>  pace response payloads at this rate from now on
+//	RATE OFF     stop pacing
+//	STOP         hold every response payload write
+//	START        release them
+//	STATUS       report the state
+//
+// The commands apply at once to every response being sent, in the
+// paceChunk steps they are written in.
+type Control struct {
+	mu      sync.Mutex
+	mbps    float64
+	stopped bool
+	// started is closed, and replaced, on START, to release the held writes
+	started chan struct{}
+}
+
+// NewControl returns a Control in its initial state: unpaced and sending.
+func NewControl() *Control {
+	return &Control{started: make(chan struct{})}
+}
+
+// Serve answers the control connections accepted on ln until ctx ends or
+// ln fails.
+func (c *Control) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go c.serveConn(conn)
+	}
+}
+
+func (c *Control) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewScanner(conn)
+	for r.Scan() {
+		cmd := strings.TrimSpace(r.Text())
+		if cmd == "" {
+			continue
+		}
+		reply, err := c.apply(cmd)
+		if err != nil {
+			reply = "ERR " + err.Error()
+		} else {
+			log.Printf("Control %s from %s: %s", cmd, conn.RemoteAddr(), reply)
+			reply = "OK " + reply
+		}
+		if _, err := io.WriteString(conn, reply+"\n"); err != nil {
+			return
+		}
+	}
+}
+
+// apply runs one command and returns the resulting state.
+func (c *Control) apply(cmd string) (string, error) {
+	fields := strings.Fields(cmd)
+	for i, f := range fields {
+		fields[i] = strings.ToUpper(f)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case len(fields) == 2 && fields[0] == "RATE" && fields[1] == "OFF":
+		c.mbps = 0
+	case len(fields) == 2 && fields[0] == "RATE":
+		mbps, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || mbps <= 0 {
+			return "", fmt.Errorf("invalid rate %q: must be a positive Mbps or OFF", strings.Fields(cmd)[1])
+		}
+		c.mbps = mbps
+	case len(fields) == 1 && fields[0] == "STOP":
+		c.stopped = true
+	case len(fields) == 1 && fields[0] == "START":
+		if c.stopped {
+			c.stopped = false
+			close(c.started)
+			c.started = make(chan struct{})
+		}
+	case len(fields) == 1 && fields[0] == "STATUS":
+	default:
+		return "", fmt.Errorf("unknown command %q", cmd)
+	}
+	return c.state(), nil
+}
+
+// state describes the current state. c.mu must be held.
+func (c *Control) state() string {
+	pacing := "unpaced"
+	if c.mbps > 0 {
+		pacing = fmt.Sprintf("rate %.2f Mbps", c.mbps)
+	}
+	if c.stopped {
+		return pacing + ", stopped"
+	}
+	return pacing + ", sending"
+}
+
+// wait blocks while sending is stopped, or until ctx ends, and returns the
+// rate to pace at, zero for none.
+func (c *Control) wait(ctx context.Context) (float64, error) {
+	for {
+		c.mu.Lock()
+		stopped, started, mbps := c.stopped, c.started, c.mbps
+		c.mu.Unlock()
+		if !stopped {
+			return mbps, nil
+		}
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// write writes data to w in paceChunk steps, as the control state allows
+// at each step.
+func (c *Control) write(ctx context.Context, w io.Writer, data []byte) error {
+	var next time.Time
+	for len(data) > 0 {
+		mbps, err := c.wait(ctx)
+		if err != nil {
+			return err
+		}
+		n := min(paceChunk, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if mbps == 0 {
+			continue
+		}
+		// a write that fell behind, or follows a stop, starts afresh
+		// rather than bursting to catch up
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+		next = next.Add(time.Duration(float64(n) * 8 / (mbps * 1e6) * float64(time.Second)))
+		time.Sleep(time.Until(next))
+	}
+	return nil
+}
+
+// SendControl sends each of cmds over one connection to the control
+// listener at addr and returns the replies. An ERR reply ends the
+// exchange with an error.
+func SendControl(addr string, cmds []string, timeout time.Duration) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var replies []string
+	for _, cmd := range cmds {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := io.WriteString(conn, cmd+"\n"); err != nil {
+			return replies, err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return replies, fmt.Errorf("%s: %w", cmd, err)
+		}
+		reply := strings.TrimSpace(line)
+		if msg, ok := strings.CutPrefix(reply, "ERR "); ok {
+			return replies, fmt.Errorf("%s: %s", cmd, msg)
+		}
+		replies = append(replies, strings.TrimPrefix(reply, "OK "))
+	}
+	return replies, nil
+}
+
+// ControlStep is a control command to send at an offset into a run.
+type ControlStep struct {
+	At      time.Duration
+	Command string
+}
+
+// ParseControlScript parses comma-separated "<offset> <command>" steps in
+// order of their offsets, such as "2s RATE 5, 4s STOP, 5s START".
+func ParseControlScript(script string) ([]ControlStep, error) {
+	var steps []ControlStep
+	for _, entry := range strings.Split(script, ",") {
+		at, cmd, ok := strings.Cut(strings.TrimSpace(entry), " ")
+		if !ok || strings.TrimSpace(cmd) == "" {
+			return nil, fmt.Errorf("invalid step %q: must be an offset and a command", strings.TrimSpace(entry))
+		}
+		d, err := time.ParseDuration(at)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid offset %q in step %q", at, strings.TrimSpace(entry))
+		}
+		if len(steps) > 0 && d < steps[len(steps)-1].At {
+			return nil, fmt.Errorf("step %q comes before the one ahead of it", strings.TrimSpace(entry))
+		}
+		steps = append(steps, ControlStep{At: d, Command: strings.TrimSpace(cmd)})
+	}
+	return steps, nil
+}
+
+// RunControlScript sends each step's command to the control listener at
+// addr at its offset from now, until ctx ends, and logs the replies.
+func RunControlScript(ctx context.Context, addr string, steps []ControlStep) {
+	start := time.Now()
+	for _, step := range steps {
+		select {
+		case <-time.After(time.Until(start.Add(step.At))):
+		case <-ctx.Done():
+			return
+		}
+		replies, err := SendControl(addr, []string{step.Command}, 5*time.Second)
+		if err != nil {
+			log.Printf("Control %s at %v: %v", step.Command, step.At, err)
+			continue
+		}
+		log.Printf("Control %s at %v: %s", step.Command, step.At, replies[0])
+	}
+}
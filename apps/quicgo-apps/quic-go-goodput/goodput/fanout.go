@@ -0,0 +1,155 @@
+package goodput
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ServerResult is the outcome of one server's transfer in a fan-out run.
+type ServerResult struct {
+	Addr string `json:"addr"`
+	*Result
+	Error string `json:"error,omitempty"`
+}
+
+// FanOutResult summarizes a fan-out run. The totals span the wall clock from
+// before the first dial until the last transfer ends, so Goodput is the
+// aggregate rate the client sustained across all servers.
+type FanOutResult struct {
+	Bytes   int           `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Goodput is in Mbps.
+	Goodput float64        `json:"goodput_mbps"`
+	Servers []ServerResult `json:"servers"`
+	// Fairness is Jain's index over the goodputs of the completed
+	// transfers of a RunFairness run.
+	Fairness float64 `json:"jain_fairness,omitempty"`
+}
+
+// RunFanOut runs the transfer described by cfg against each of addrs
+// concurrently, one RunClient per server, and prints a per-server table and
+// the aggregate goodput. cfg.Addr is ignored. Servers that fail are reported
+// with their error and the joined errors are returned alongside the result.
+func RunFanOut(ctx context.Context, cfg ClientConfig, addrs []string) (*FanOutResult, error) {
+	fr, err := runConcurrent(ctx, cfg, addrs)
+	if fr != nil {
+		printServerTable(fr)
+	}
+	return fr, err
+}
+
+// RunFairness runs flows copies of the transfer described by cfg at once,
+// flow i against addrs[i%len(addrs)], and prints each flow's goodput and
+// Jain's fairness index over them. The flows compete for the bottleneck
+// only if the server handles connections concurrently (-concurrent).
+func RunFairness(ctx context.Context, cfg ClientConfig, addrs []string, flows int) (*FanOutResult, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no servers given")
+	}
+	if flows < 1 {
+		return nil, fmt.Errorf("invalid flow count %d: must be positive", flows)
+	}
+	flowAddrs := make([]string, flows)
+	for i := range flowAddrs {
+		flowAddrs[i] = addrs[i%len(addrs)]
+	}
+	fr, err := runConcurrent(ctx, cfg, flowAddrs)
+	if fr == nil {
+		return nil, err
+	}
+	var goodputs []float64
+	for _, s := range fr.Servers {
+		if s.Result != nil {
+			goodputs = append(goodputs, s.Goodput)
+		}
+	}
+	fr.Fairness = jainIndex(goodputs)
+	printFlowTable(fr)
+	return fr, err
+}
+
+// runConcurrent runs one RunClient per entry of addrs, all at once.
+func runConcurrent(ctx context.Context, cfg ClientConfig, addrs []string) (*FanOutResult, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no servers given")
+	}
+	if cfg.Parallel > 1 || cfg.Pipeline > 1 {
+		return nil, errors.New("parallel streams and pipelining cannot be combined with concurrent transfers")
+	}
+	if cfg.PacketConn != nil {
+		return nil, errors.New("a custom PacketConn cannot be shared by concurrent transfers")
+	}
+	cfg.Quiet = true
+
+	servers := make([]ServerResult, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := cfg
+			c.Addr = addr
+			res, err := RunClient(ctx, c)
+			servers[i] = ServerResult{Addr: addr, Result: res}
+			if err != nil {
+				servers[i].Error = err.Error()
+				errs[i] = fmt.Errorf("%s: %w", addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fr := &FanOutResult{Elapsed: elapsed, Servers: servers}
+	for _, s := range servers {
+		if s.Result != nil {
+			fr.Bytes += s.Bytes
+		}
+	}
+	if elapsed > 0 {
+		fr.Goodput = float64(fr.Bytes) * 8 / elapsed.Seconds() / 1e6
+	}
+	return fr, errors.Join(errs...)
+}
+
+// printServerTable prints the per-server breakdown of a fan-out run and the
+// wall-clock aggregate.
+func printServerTable(fr *FanOutResult) {
+	width := len("server")
+	for _, s := range fr.Servers {
+		width = max(width, len(s.Addr))
+	}
+	fmt.Printf("%-*s %12s %10s %14s\n", width, "server", "KB", "time (s)", "goodput (Mbps)")
+	for _, s := range fr.Servers {
+		if s.Result == nil {
+			fmt.Printf("%-*s %s\n", width, s.Addr, s.Error)
+			continue
+		}
+		fmt.Printf("%-*s %12.2f %10.3f %14.2f\n", width, s.Addr, float64(s.Bytes)/1024.0, s.Elapsed.Seconds(), s.Goodput)
+	}
+	fmt.Printf("Total: %.2f KB from %d servers in %.3f s, goodput %.2f Mbps\n",
+		float64(fr.Bytes)/1024.0, len(fr.Servers), fr.Elapsed.Seconds(), fr.Goodput)
+}
+
+// printFlowTable prints the per-flow breakdown of a fairness run, the
+// wall-clock aggregate and Jain's fairness index.
+func printFlowTable(fr *FanOutResult) {
+	fmt.Printf("%-6s %-21s %12s %10s %14s\n", "flow", "server", "KB", "time (s)", "goodput (Mbps)")
+	var done int
+	for i, s := range fr.Servers {
+		if s.Result == nil {
+			fmt.Printf("%-6d %-21s %s\n", i+1, s.Addr, s.Error)
+			continue
+		}
+		done++
+		fmt.Printf("%-6d %-21s %12.2f %10.3f %14.2f\n", i+1, s.Addr, float64(s.Bytes)/1024.0, s.Elapsed.Seconds(), s.Goodput)
+	}
+	fmt.Printf("Total: %.2f KB over %d flows in %.3f s, goodput %.2f Mbps\n",
+		float64(fr.Bytes)/1024.0, len(fr.Servers), fr.Elapsed.Seconds(), fr.Goodput)
+	fmt.Printf("Jain's fairness index over %d flows: %.3f\n", done, fr.Fairness)
+}
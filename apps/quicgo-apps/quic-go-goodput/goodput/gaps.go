@@ -0,0 +1,55 @@
+package goodput
+
+import (
+	"fmt"
+	"time"
+)
+
+// Gap is a stretch of a transfer in which no payload arrived, such as a
+// loss recovery or an RTO. Start is relative to the request.
+type Gap struct {
+	Start    time.Duration `json:"start_ns"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// noteRead records the gap since the previous read at now, if it is at
+// least the threshold. Gaps are counted from the first byte on; the wait
+// for it is the TTFB.
+func (s *ClientStats) noteRead(now time.Time) {
+	if s.gapThreshold <= 0 {
+		return
+	}
+	if !s.lastRead.IsZero() && now.Sub(s.lastRead) >= s.gapThreshold {
+		s.gaps = append(s.gaps, Gap{Start: s.lastRead.Sub(s.startTime), Duration: now.Sub(s.lastRead)})
+	}
+	s.lastRead = now
+}
+
+// closeGaps records a gap between the last read and the end of the
+// transfer, as when it stalled until a timeout.
+func (s *ClientStats) closeGaps() {
+	if s.gapThreshold > 0 && !s.lastRead.IsZero() {
+		s.noteRead(time.Now())
+	}
+}
+
+// Gaps returns the gaps found, if gap detection was on.
+func (s *ClientStats) Gaps() []Gap {
+	return s.gaps
+}
+
+// printGaps lists gaps of at least threshold.
+func printGaps(gaps []Gap, threshold time.Duration) {
+	if len(gaps) == 0 {
+		fmt.Printf("No throughput gaps of %v or longer\n", threshold)
+		return
+	}
+	var total time.Duration
+	for _, g := range gaps {
+		total += g.Duration
+	}
+	fmt.Printf("Throughput gaps of %v or longer: %d, %.3f s in total\n", threshold, len(gaps), total.Seconds())
+	for _, g := range gaps {
+		fmt.Printf("  at %.3f s for %.3f s\n", g.Start.Seconds(), g.Duration.Seconds())
+	}
+}
@@ -0,0 +1,228 @@
+package goodput
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-go-common/qtrace"
+)
+
+// HandshakeResult summarizes a RunHandshakeBench run. The latencies are in
+// milliseconds, from starting to dial until the connection is usable: until
+// the handshake completes, or for a 0-RTT dial until early data may be sent.
+type HandshakeResult struct {
+	Handshakes int           `json:"handshakes"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	PerSecond  float64       `json:"handshakes_per_sec"`
+	// ZeroRTT counts the handshakes whose 0-RTT attempt the server accepted.
+	ZeroRTT int     `json:"zero_rtt_accepted,omitempty"`
+	MinMs   float64 `json:"min_ms"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	// With a request, TTLB is the time from starting to dial until the
+	// last response byte, and the payload counts split the stream data
+	// sent and received over all timed connections by the packets that
+	// carried it.
+	TTLBP50Ms            float64 `json:"ttlb_p50_ms,omitempty"`
+	TTLBP90Ms            float64 `json:"ttlb_p90_ms,omitempty"`
+	TTLBP99Ms            float64 `json:"ttlb_p99_ms,omitempty"`
+	PayloadSent          int64   `json:"payload_sent_bytes,omitempty"`
+	EarlyPayloadSent     int64   `json:"early_payload_sent_bytes,omitempty"`
+	PayloadReceived      int64   `json:"payload_received_bytes,omitempty"`
+	EarlyPayloadReceived int64   `json:"early_payload_received_bytes,omitempty"`
+}
+
+// RunHandshakeBench dials the server n times in a row and closes each
+// connection as soon as it is usable, without sending a request, to isolate
+// the cost of connection setup. With zeroRTT, one untimed connection first
+// obtains a session ticket and every timed dial then attempts 0-RTT; the
+// server only accepts it when it runs with 0-RTT allowed. A positive
+// cfg.RequestBytes instead sends a GETN on each connection as soon as it is
+// usable, in 0-RTT if possible, and reads the response before closing, to
+// measure how much of the exchange early data actually carries.
+func RunHandshakeBench(ctx context.Context, cfg ClientConfig, n int, zeroRTT bool) (*HandshakeResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid handshake count %d: must be positive", n)
+	}
+	if cfg.Transport != "" && cfg.Transport != TransportQUIC {
+		return nil, errors.New("the handshake benchmark needs the QUIC transport")
+	}
+	if cfg.PacketConn != nil {
+		return nil, errors.New("the handshake benchmark dials a fresh socket per connection")
+	}
+	if cfg.RootCAs == nil && !cfg.Insecure {
+		return nil, errNoTrust
+	}
+
+	tlsConf := clientTLSConfig(cfg)
+	quicConf := connectQUICConfig(cfg.QUICConfig, cfg.ConnectTimeout)
+	var spaces *qtrace.SenderCounter
+	if cfg.RequestBytes > 0 {
+		if quicConf == nil {
+			quicConf = &quic.Config{}
+		} else {
+			quicConf = quicConf.Clone()
+		}
+		spaces = qtrace.NewSenderCounter()
+		quicConf.Tracer = spaces.Tracer
+	}
+	var tickets *ticketCache
+	if zeroRTT {
+		tickets = &ticketCache{ClientSessionCache: tls.NewLRUClientSessionCache(1), put: make(chan struct{}, 1)}
+		tlsConf.ClientSessionCache = tickets
+	}
+	res := &HandshakeResult{}
+	handshake := func(timed bool) (time.Duration, time.Duration, bool, error) {
+		start := time.Now()
+		dialCtx, cancel := connectContext(ctx, cfg.ConnectTimeout)
+		var conn *quic.Conn
+		var err error
+		if zeroRTT {
+			conn, err = quic.DialAddrEarly(dialCtx, cfg.Addr, tlsConf, quicConf)
+		} else {
+			conn, err = quic.DialAddr(dialCtx, cfg.Addr, tlsConf, quicConf)
+		}
+		err = connectError(dialCtx, err, cfg.Addr, cfg.ConnectTimeout)
+		cancel()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		latency := time.Since(start)
+		defer conn.CloseWithError(0, "")
+		var ttlb time.Duration
+		if cfg.RequestBytes > 0 {
+			if err := fetch(ctx, conn, cfg.RequestBytes, cfg.Token); err != nil {
+				return 0, 0, false, err
+			}
+			ttlb = time.Since(start)
+			if s, ok := spaces.Conn(conn.Context()).Stats(); ok && timed {
+				res.PayloadSent += s.PayloadBytes
+				res.EarlyPayloadSent += s.EarlyPayloadSent
+				res.PayloadReceived += s.PayloadReceived
+				res.EarlyPayloadReceived += s.EarlyPayloadReceived
+			}
+		}
+		// whether the server took the 0-RTT attempt is only known once the
+		// handshake completes
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			return 0, 0, false, context.Cause(conn.Context())
+		}
+		if tickets != nil {
+			// the server sends a fresh session ticket after the handshake;
+			// closing before it arrives would leave the next dial without
+			// one
+			select {
+			case <-tickets.put:
+			case <-time.After(ticketWait):
+			}
+		}
+		return latency, ttlb, conn.ConnectionState().Used0RTT, nil
+	}
+
+	if zeroRTT {
+		if _, _, _, err := handshake(false); err != nil {
+			return nil, fmt.Errorf("priming handshake: %w", err)
+		}
+	}
+
+	latencies := make([]time.Duration, 0, n)
+	ttlbs := make([]time.Duration, 0, n)
+	start := time.Now()
+	for i := range n {
+		latency, ttlb, used0RTT, err := handshake(true)
+		if err != nil {
+			return nil, fmt.Errorf("handshake %d: %w", i+1, err)
+		}
+		latencies = append(latencies, latency)
+		ttlbs = append(ttlbs, ttlb)
+		if used0RTT {
+			res.ZeroRTT++
+		}
+	}
+	res.Elapsed = time.Since(start)
+	res.Handshakes = n
+	res.PerSecond = float64(n) / res.Elapsed.Seconds()
+
+	slices.Sort(latencies)
+	res.MinMs = toMs(latencies[0])
+	res.P50Ms = toMs(percentile(latencies, 0.50))
+	res.P90Ms = toMs(percentile(latencies, 0.90))
+	res.P99Ms = toMs(percentile(latencies, 0.99))
+	res.MaxMs = toMs(latencies[n-1])
+
+	fmt.Printf("%d handshakes in %.3f s, %.1f handshakes/s\n", n, res.Elapsed.Seconds(), res.PerSecond)
+	fmt.Printf("Handshake latency: min %.3f ms, p50 %.3f ms, p90 %.3f ms, p99 %.3f ms, max %.3f ms\n",
+		res.MinMs, res.P50Ms, res.P90Ms, res.P99Ms, res.MaxMs)
+	if zeroRTT {
+		fmt.Printf("0-RTT accepted on %d of %d handshakes\n", res.ZeroRTT, n)
+	}
+	if cfg.RequestBytes > 0 {
+		slices.Sort(ttlbs)
+		res.TTLBP50Ms = toMs(percentile(ttlbs, 0.50))
+		res.TTLBP90Ms = toMs(percentile(ttlbs, 0.90))
+		res.TTLBP99Ms = toMs(percentile(ttlbs, 0.99))
+		fmt.Printf("Time to last byte: p50 %.3f ms, p90 %.3f ms, p99 %.3f ms\n", res.TTLBP50Ms, res.TTLBP90Ms, res.TTLBP99Ms)
+		fmt.Printf("Payload sent: %.2f KB in 0-RTT, %.2f KB in 1-RTT; received: %.2f KB in 0-RTT, %.2f KB in 1-RTT\n",
+			float64(res.EarlyPayloadSent)/1024.0, float64(res.PayloadSent-res.EarlyPayloadSent)/1024.0,
+			float64(res.EarlyPayloadReceived)/1024.0, float64(res.PayloadReceived-res.EarlyPayloadReceived)/1024.0)
+	}
+	return res, nil
+}
+
+// fetch sends a GETN for n bytes on conn and reads the response to the end.
+func fetch(ctx context.Context, conn *quic.Conn, n int, token string) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	if _, err := stream.Write([]byte(fmt.Sprintf("GETN %d%s\r\n", n, authField(token)))); err != nil {
+		return fmt.Errorf("write GETN: %w", err)
+	}
+	got, err := io.Copy(io.Discard, stream)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if int(got) != n {
+		return fmt.Errorf("received %d of %d bytes", got, n)
+	}
+	return nil
+}
+
+// ticketWait bounds how long a 0-RTT benchmark connection waits for the
+// server's session ticket before closing.
+const ticketWait = time.Second
+
+// ticketCache signals each session ticket stored in it.
+type ticketCache struct {
+	tls.ClientSessionCache
+	put chan struct{}
+}
+
+func (c *ticketCache) Put(key string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(key, cs)
+	if cs != nil {
+		select {
+		case c.put <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
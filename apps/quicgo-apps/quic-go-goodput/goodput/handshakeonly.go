@@ -0,0 +1,72 @@
+package goodput
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlogwriter"
+)
+
+// HandshakeOnlyErrorCode is the application error code a server running
+// with ServerConfig.HandshakeOnly closes every connection with once its
+// handshake completes.
+const HandshakeOnlyErrorCode quic.ApplicationErrorCode = 0xcc
+
+// handshakeClock records when the server created each connection, on its
+// first Initial packet, to time the handshakes from there. Its tracer must
+// be installed as quic.Config.Tracer.
+type handshakeClock struct {
+	mu      sync.Mutex
+	started map[quic.ConnectionTracingID]time.Time
+}
+
+func newHandshakeClock() *handshakeClock {
+	return &handshakeClock{started: make(map[quic.ConnectionTracingID]time.Time)}
+}
+
+// tracer is the quic.Config.Tracer callback. It traces no events.
+func (c *handshakeClock) tracer(ctx context.Context, _ bool, _ quic.ConnectionID) qlogwriter.Trace {
+	id, _ := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	c.mu.Lock()
+	c.started[id] = time.Now()
+	c.mu.Unlock()
+	// connections that never complete their handshake are forgotten too
+	context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		delete(c.started, id)
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+// since returns how long ago conn was created.
+func (c *handshakeClock) since(conn *quic.Conn) (time.Duration, bool) {
+	id, _ := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start, ok := c.started[id]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(start), true
+}
+
+// closeAfterHandshake waits for the handshake of conn to complete, logs how
+// long it took and closes conn with HandshakeOnlyErrorCode.
+func closeAfterHandshake(conn *quic.Conn, clock *handshakeClock) {
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		log.Printf("Handshake with %s failed: %v", conn.RemoteAddr(), context.Cause(conn.Context()))
+		return
+	}
+	if d, ok := clock.since(conn); ok {
+		log.Printf("Handshake with %s completed in %.3f ms from its first packet", conn.RemoteAddr(), toMs(d))
+	} else {
+		log.Printf("Handshake with %s completed", conn.RemoteAddr())
+	}
+	conn.CloseWithError(HandshakeOnlyErrorCode, "handshake only")
+}
@@ -0,0 +1,168 @@
+package goodput
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A hint is a key=value field a client carries in its request lines, ahead
+// of any auth= field, to configure its own connection on a server running
+// with ServerConfig.AcceptHints. That lets one long-lived listener serve a
+// sequence of independent experiments without restarting the server. A
+// hint holds for the rest of the connection once a request carries it.
+//
+// Only what is read per response can vary per connection:
+//
+//	think=<duration>   ServerConfig.ThinkTime
+//	entropy=<0..1>     ServerConfig.Entropy
+//	seed=<uint>        ServerConfig.PayloadSeed
+//	pattern=<name>     ServerConfig.PayloadPattern
+//	max=<bytes>        ServerConfig.MaxBytes, which can only be lowered
+//
+// Everything quic-go takes from the quic.Config at Listen, before any
+// request arrives, is fixed per listener: the congestion controller
+// (cc=), the initial RTT (initial-rtt=), the congestion window bounds
+// (min-cwnd=, max-cwnd=) and the receive windows (stream-window=,
+// conn-window=), which besides only matter in the client's direction on a
+// download. The server logs these hints as ignored. With a Dataset, every
+// response is taken from it, so entropy, seed and pattern are ignored too.
+var hintKeys = map[string]bool{
+	"think":         true,
+	"entropy":       true,
+	"seed":          true,
+	"pattern":       true,
+	"max":           true,
+	"cc":            false,
+	"initial-rtt":   false,
+	"min-cwnd":      false,
+	"max-cwnd":      false,
+	"stream-window": false,
+	"conn-window":   false,
+}
+
+// ParseHints parses comma-separated key=value hints, such as
+// "think=50ms,entropy=0.5", and returns them for ClientConfig.Hints. It
+// only checks that every key is a known one; the server checks the values.
+// It also returns the keys given that a server only applies per listener.
+func ParseHints(s string) (hints []string, perListener []string, err error) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, _, ok := strings.Cut(field, "=")
+		perConn, known := hintKeys[key]
+		switch {
+		case !ok:
+			return nil, nil, fmt.Errorf("hint %q is not key=value", field)
+		case !known:
+			return nil, nil, fmt.Errorf("unknown hint %q", key)
+		case !perConn:
+			perListener = append(perListener, key)
+		}
+		hints = append(hints, field)
+	}
+	return hints, perListener, nil
+}
+
+// hintFields returns hints as the fields to append to a request line, each
+// with its leading space.
+func hintFields(hints []string) string {
+	if len(hints) == 0 {
+		return ""
+	}
+	return " " + strings.Join(hints, " ")
+}
+
+// splitHints strips the trailing key=value fields off request, which must
+// already be stripped of its auth= field, and returns them.
+func splitHints(request string) (string, []string) {
+	fields := strings.Fields(request)
+	i := len(fields)
+	for i > 1 && strings.Contains(fields[i-1], "=") {
+		i--
+	}
+	if i == len(fields) {
+		return request, nil
+	}
+	return strings.Join(fields[:i], " "), fields[i:]
+}
+
+// applyHints strips the hints off request and applies them to cfg, which
+// is the configuration of the connection from peer. A malformed hint fails
+// with errBadRequest; the ones that cannot be applied are logged and
+// skipped.
+func (cfg *ServerConfig) applyHints(request string, peer net.Addr) (string, error) {
+	request, hints := splitHints(request)
+	if len(hints) == 0 {
+		return request, nil
+	}
+	if !cfg.AcceptHints {
+		log.Printf("Ignoring the hints of %s: the server runs without -accept-hints", peer)
+		return request, nil
+	}
+	next := *cfg
+	var applied, ignored []string
+	for _, h := range hints {
+		key, val, _ := strings.Cut(h, "=")
+		perConn, known := hintKeys[key]
+		if !known {
+			return "", fmt.Errorf("%w: unknown hint %q", errBadRequest, key)
+		}
+		if !perConn {
+			ignored = append(ignored, h+" (per listener)")
+			continue
+		}
+		if next.Dataset != nil && (key == "entropy" || key == "seed" || key == "pattern") {
+			ignored = append(ignored, h+" (preloaded dataset)")
+			continue
+		}
+		var err error
+		switch key {
+		case "think":
+			var d time.Duration
+			if d, err = time.ParseDuration(val); err == nil && d < 0 {
+				err = errors.New("negative")
+			}
+			next.ThinkTime = d
+		case "entropy":
+			var e float64
+			if e, err = strconv.ParseFloat(val, 64); err == nil && (e < 0 || e > 1) {
+				err = errors.New("not within [0, 1]")
+			}
+			next.Entropy = e
+		case "seed":
+			next.PayloadSeed, err = strconv.ParseUint(val, 10, 64)
+		case "pattern":
+			if val != PatternEntropy && val != PatternSignature {
+				err = fmt.Errorf("not %s or %s", PatternEntropy, PatternSignature)
+			}
+			next.PayloadPattern = val
+		case "max":
+			var n int
+			if n, err = strconv.Atoi(val); err == nil && n <= 0 {
+				err = errors.New("not positive")
+			}
+			if next.MaxBytes == 0 || n < next.MaxBytes {
+				next.MaxBytes = n
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: hint %s: %v", errBadRequest, h, err)
+		}
+		applied = append(applied, h)
+	}
+	*cfg = next
+	if len(applied) > 0 {
+		log.Printf("Hints of %s applied: %s", peer, strings.Join(applied, " "))
+	}
+	if len(ignored) > 0 {
+		log.Printf("Hints of %s ignored: %s", peer, strings.Join(ignored, ", "))
+	}
+	return request, nil
+}
@@ -0,0 +1,118 @@
+package goodput
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+// Sample is one progress interval of a transfer, as published to a
+// LivePublisher.
+type Sample struct {
+	// Start and End bound the interval, in seconds since the request.
+	Start float64 `json:"start_s"`
+	End   float64 `json:"end_s"`
+	Bytes int     `json:"bytes"`
+	// Goodput is in Mbps.
+	Goodput float64 `json:"goodput_mbps"`
+	// RTT is the smoothed RTT sampled at the end of the interval, in
+	// milliseconds, on QUIC transfers.
+	RTT float64 `json:"rtt_ms,omitempty"`
+}
+
+// liveQueue is how many samples wait for a slow reader before the newest
+// are dropped.
+const liveQueue = 64
+
+// LivePublisher serves progress samples as JSON lines on a Unix socket to
+// any number of readers, such as a live plotter. Publishing never blocks:
+// a reader that falls liveQueue samples behind misses the samples that do
+// not fit, and a reader that goes away is dropped.
+type LivePublisher struct {
+	ln   *net.UnixListener
+	path string
+
+	mu      sync.Mutex
+	readers map[chan []byte]struct{}
+}
+
+// NewLivePublisher listens on the Unix socket at path, replacing a stale
+// socket file left there by an earlier run, and accepts readers in the
+// background until Close.
+func NewLivePublisher(path string) (*LivePublisher, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	p := &LivePublisher{ln: ln, path: path, readers: make(map[chan []byte]struct{})}
+	go p.accept()
+	return p, nil
+}
+
+func (p *LivePublisher) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, liveQueue)
+		p.mu.Lock()
+		p.readers[ch] = struct{}{}
+		p.mu.Unlock()
+		go p.serve(conn, ch)
+	}
+}
+
+// serve writes the samples queued on ch to conn until either goes away.
+func (p *LivePublisher) serve(conn net.Conn, ch chan []byte) {
+	defer conn.Close()
+	for line := range ch {
+		if _, err := conn.Write(line); err != nil {
+			p.mu.Lock()
+			if _, ok := p.readers[ch]; ok {
+				delete(p.readers, ch)
+				close(ch)
+			}
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Publish queues s for every connected reader.
+func (p *LivePublisher) Publish(s Sample) {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.readers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// Close stops accepting readers and removes the socket file; connected
+// readers still receive the samples already queued for them.
+func (p *LivePublisher) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	for ch := range p.readers {
+		delete(p.readers, ch)
+		close(ch)
+	}
+	p.mu.Unlock()
+	if rmErr := os.Remove(p.path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) && err == nil {
+		err = rmErr
+	}
+	return err
+}
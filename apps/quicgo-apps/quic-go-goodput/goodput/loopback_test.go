@@ -0,0 +1,51 @@
+package goodput
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveLoopback runs a server with cfg on a loopback port for the rest of
+// the test and returns its address.
+func serveLoopback(tb testing.TB, cfg ServerConfig) string {
+	tb.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	if cfg.FinTimeout == 0 {
+		cfg.FinTimeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- RunServer(ctx, conn, cfg) }()
+	tb.Cleanup(func() {
+		cancel()
+		if err := <-serverErr; err != nil {
+			tb.Errorf("server: %v", err)
+		}
+	})
+	return conn.LocalAddr().String()
+}
+
+// fetchLoopback runs one GETN transfer of n bytes from the server at addr.
+func fetchLoopback(tb testing.TB, addr string, n, readBuffer int) *Result {
+	tb.Helper()
+	res, err := RunClient(context.Background(), ClientConfig{
+		Addr:         addr,
+		RequestBytes: n,
+		ReadBuffer:   readBuffer,
+		Quiet:        true,
+		// the test server uses a throwaway self-signed certificate
+		Insecure: true,
+	})
+	if err != nil {
+		tb.Fatalf("client: %v", err)
+	}
+	if res.Bytes != n {
+		tb.Fatalf("received %d of %d bytes", res.Bytes, n)
+	}
+	return res
+}
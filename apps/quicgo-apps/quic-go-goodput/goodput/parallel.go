@@ -0,0 +1,165 @@
+package goodput
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/telemetry"
+)
+
+// StreamResult is the share of a parallel transfer carried by one stream.
+type StreamResult struct {
+	Stream  int           `json:"stream"`
+	Bytes   int           `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Goodput is in Mbps.
+	Goodput float64 `json:"goodput_mbps"`
+}
+
+// lockedStats serialises updates to the aggregate ClientStats from the
+// per-stream readers.
+type lockedStats struct {
+	mu sync.Mutex
+	*ClientStats
+}
+
+func (s *lockedStats) Add(n int) {
+	s.mu.Lock()
+	s.ClientStats.Add(n)
+	s.mu.Unlock()
+}
+
+// runParallel splits cfg.RequestBytes evenly across cfg.Parallel GETP
+// requests on their own streams and reads them concurrently. Each reader
+// keeps its own ClientStats next to the shared aggregate.
+func runParallel(ctx context.Context, session *quic.Conn, cfg ClientConfig) (*Result, error) {
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+
+	agg := &lockedStats{ClientStats: NewClientStats()}
+	cfg.salvage.track(agg.ClientStats)
+	agg.discardFirstRTT = cfg.DiscardFirstRTT
+	agg.window = newSlidingWindow(cfg.Window)
+	agg.summaryEvery = cfg.SummaryInterval
+	agg.quiet = cfg.Quiet
+	agg.keepSamples = cfg.KeepSamples
+	agg.gapThreshold = cfg.GapThreshold
+	agg.live = cfg.Live
+	agg.watch(session)
+
+	streams := make([]StreamResult, cfg.Parallel)
+	errs := make([]error, cfg.Parallel)
+	ends := make([]error, cfg.Parallel)
+	var wg sync.WaitGroup
+	for i := range cfg.Parallel {
+		n := cfg.RequestBytes / cfg.Parallel
+		if i < cfg.RequestBytes%cfg.Parallel {
+			n++
+		}
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("open stream %d: %w", i, err)
+		}
+		if _, err := stream.Write([]byte(fmt.Sprintf("GETP %d%s\r\n", n, authField(cfg.Token)))); err != nil {
+			return nil, fmt.Errorf("write GETP on stream %d: %w", i, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats := NewClientStats()
+			stats.quiet = true
+			buf := make([]byte, cfg.ReadBuffer)
+			for {
+				n, err := stream.Read(buf)
+				if n > 0 {
+					stats.Add(n)
+					agg.Add(n)
+				}
+				if err != nil {
+					ends[i] = err
+					var qe *quic.ApplicationError
+					if err != io.EOF && !(errors.As(err, &qe) && qe.ErrorCode == 0) {
+						errs[i] = fmt.Errorf("read stream %d: %w", i, err)
+					}
+					break
+				}
+			}
+			streams[i] = StreamResult{
+				Stream:  i,
+				Bytes:   stats.bytesRecv,
+				Elapsed: time.Since(stats.startTime),
+				Goodput: stats.Goodput(),
+			}
+		}()
+	}
+	wg.Wait()
+
+	agg.PrintFinal()
+	if !cfg.Quiet {
+		printStreamTable(streams)
+	}
+
+	res := &Result{
+		Bytes:   agg.bytesRecv,
+		Elapsed: time.Since(agg.startTime),
+		Goodput: agg.Goodput(),
+		TTFB:    agg.TTFB(),
+		Streams: streams,
+		RTT:     agg.RTT(),
+		Samples: agg.Samples(),
+		Gaps:    agg.Gaps(),
+	}
+	// the first stream that ended other than by FIN stands for the transfer
+	res.Termination = terminationOf(nil)
+	for _, end := range ends {
+		if t := terminationOf(end); t.Kind != TermFIN {
+			res.Termination = t
+			break
+		}
+	}
+	if cfg.DiscardFirstRTT {
+		res.AdjustedGoodput, _ = agg.AdjustedGoodput()
+	}
+	xferSpan.SetAttributes(
+		attribute.Int("bytes", res.Bytes),
+		attribute.Float64("goodput_mbps", res.Goodput),
+		attribute.Int("streams", cfg.Parallel),
+	)
+	return res, errors.Join(errs...)
+}
+
+// printStreamTable prints the per-stream breakdown of a parallel transfer
+// together with Jain's fairness index over the stream goodputs.
+func printStreamTable(streams []StreamResult) {
+	fmt.Printf("%-8s %12s %10s %14s\n", "stream", "KB", "time (s)", "goodput (Mbps)")
+	goodputs := make([]float64, len(streams))
+	for i, s := range streams {
+		fmt.Printf("%-8d %12.2f %10.3f %14.2f\n", s.Stream, float64(s.Bytes)/1024.0, s.Elapsed.Seconds(), s.Goodput)
+		goodputs[i] = s.Goodput
+	}
+	if j := jainIndex(goodputs); j > 0 {
+		fmt.Printf("Jain's fairness index over %d streams: %.3f\n", len(streams), j)
+	}
+}
+
+// jainIndex returns Jain's fairness index over xs, from 1/len(xs) (one
+// takes all) to 1 (all equal), or 0 if they are all zero.
+func jainIndex(xs []float64) float64 {
+	var sum, sumSq float64
+	for _, x := range xs {
+		sum += x
+		sumSq += x * x
+	}
+	if sumSq == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(xs)) * sumSq)
+}
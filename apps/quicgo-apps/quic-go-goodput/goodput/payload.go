@@ -0,0 +1,72 @@
+package goodput
+
+import (
+	"quic-go-common/payload"
+)
+
+// The payload patterns a server can send: PatternEntropy follows
+// ServerConfig.Entropy, PatternSignature stamps the payload with its offsets
+// (see FillSignature).
+const (
+	PatternEntropy   = "entropy"
+	PatternSignature = "signature"
+)
+
+// signatureCell is the length of one offset stamp of FillSignature.
+const signatureCell = 16
+
+// FillSignature fills b, which starts at offset in the response, with
+// 16-byte cells stamping the offset each cell starts at, in hex, as in
+// "pemi:00000001f0\n". In a hex dump of a decrypted packet capture the
+// payload boundaries of each packet then read off directly, and reordered
+// or duplicated data shows up as stamps out of sequence. Unlike random
+// payload the stamps compress well.
+func FillSignature(b []byte, offset int64) {
+	const hex = "0123456789abcdef"
+	cell := [signatureCell]byte{'p', 'e', 'm', 'i', ':'}
+	cell[signatureCell-1] = '\n'
+	skip := int(offset % signatureCell)
+	for pos := offset - int64(skip); len(b) > 0; pos += signatureCell {
+		v := pos
+		for i := signatureCell - 2; i >= 5; i-- {
+			cell[i] = hex[v&0xf]
+			v >>= 4
+		}
+		n := copy(b, cell[skip:])
+		b = b[n:]
+		skip = 0
+	}
+}
+
+// NewDataset returns the n payload bytes cfg asks for, generated once, to
+// preload as its Dataset. With a PayloadSeed they are the same from run to
+// run.
+func NewDataset(n int, cfg ServerConfig) []byte {
+	cfg.Dataset = nil
+	return newPayload(n, 0, cfg)
+}
+
+// newPayload returns the n payload bytes cfg asks for, starting at offset
+// in the response: a read-only slice of its Dataset if it has one, which
+// requestCap keeps the request within.
+func newPayload(n int, offset int64, cfg ServerConfig) []byte {
+	if cfg.Dataset != nil {
+		return cfg.Dataset[offset : offset+int64(n)]
+	}
+	b := make([]byte, n)
+	fillPayload(b, offset, cfg)
+	return b
+}
+
+// fillPayload fills b, which starts at offset in the response, with the
+// payload cfg asks for: a copy from its Dataset, its signature pattern, or
+// payload of its entropy, seeded by its PayloadSeed unless that is zero.
+func fillPayload(b []byte, offset int64, cfg ServerConfig) {
+	if cfg.Dataset != nil {
+		copy(b, cfg.Dataset[offset:])
+	} else if cfg.PayloadPattern == PatternSignature {
+		FillSignature(b, offset)
+	} else if cfg.Entropy > 0 {
+		payload.Fill(b, cfg.Entropy, payloadRand(cfg.PayloadSeed))
+	}
+}
@@ -0,0 +1,75 @@
+package goodput
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/telemetry"
+)
+
+// Pipe is a payload of unknown length, such as the server's stdin, that the
+// first GETN response, or TCP GETN one, streams until it ends, whatever size
+// the request asks for, and then finishes with a FIN. It can only be read
+// once, so the GETN requests after that one are rejected. The client reads
+// until the clean close either way.
+type Pipe struct {
+	r       io.Reader
+	claimed atomic.Bool
+}
+
+// errPipeClaimed rejects the GETN requests after the one that got the Pipe.
+var errPipeClaimed = errors.New("the piped payload was already streamed to an earlier GETN")
+
+func NewPipe(r io.Reader) *Pipe {
+	return &Pipe{r: r}
+}
+
+// copyTo streams the pipe to w until it ends, for the first caller only.
+func (p *Pipe) copyTo(w io.Writer) (int64, error) {
+	if !p.claimed.CompareAndSwap(false, true) {
+		return 0, errPipeClaimed
+	}
+	return io.Copy(w, p.r)
+}
+
+// servePipe streams cfg.Pipe to stream in answer to a GETN and closes it.
+// It reports whether the transfer completed.
+func servePipe(ctx context.Context, stream *quic.Stream, cfg ServerConfig) bool {
+	if !cfg.think(stream.Context()) {
+		return false
+	}
+	if _, err := streamPipe(ctx, stream, stream.Close, cfg); err != nil {
+		log.Println("Pipe error:", err)
+		stream.CancelWrite(42)
+		return false
+	}
+	return true
+}
+
+// streamPipe copies cfg.Pipe to w, calls finish once it ends and logs the
+// transfer. It returns how many bytes it copied.
+func streamPipe(ctx context.Context, w io.Writer, finish func() error, cfg ServerConfig) (int64, error) {
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	start := time.Now()
+	n, err := cfg.Pipe.copyTo(w)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		return n, err
+	}
+	elapsed := time.Since(start).Seconds()
+	mbps := float64(n) / 1_000_000.0 * 8.0 / elapsed
+
+	xferSpan.SetAttributes(attribute.Int64("bytes", n), attribute.Float64("goodput_mbps", mbps))
+	log.Printf("Send %.2f KB piped until EOF in %.3f s, goodput: %.2f Mbps\n", float64(n)/1024.0, elapsed, mbps)
+	return n, nil
+}
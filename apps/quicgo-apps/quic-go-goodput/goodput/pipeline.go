@@ -0,0 +1,144 @@
+package goodput
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/telemetry"
+)
+
+// PipelineHeaderLen is the length prefix in front of each response to a
+// pipelined GETL request.
+const PipelineHeaderLen = 8
+
+// RequestResult is the timing of one response in a pipelined transfer.
+type RequestResult struct {
+	Request int `json:"request"`
+	Bytes   int `json:"bytes"`
+	// Done is when the response completed, relative to sending the
+	// pipeline; Elapsed is the time since the previous response completed.
+	Done    time.Duration `json:"done_ns"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Goodput is in Mbps, over Elapsed.
+	Goodput float64 `json:"goodput_mbps"`
+}
+
+// runPipelined splits cfg.RequestBytes evenly across cfg.Pipeline GETL
+// requests sent back-to-back on a single stream, and reads the
+// length-prefixed responses in order.
+func runPipelined(ctx context.Context, session *quic.Conn, cfg ClientConfig) (*Result, error) {
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		reqSpan.End()
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	var cmds strings.Builder
+	for i := range cfg.Pipeline {
+		n := cfg.RequestBytes / cfg.Pipeline
+		if i < cfg.RequestBytes%cfg.Pipeline {
+			n++
+		}
+		// the server authenticates a stream by its first request
+		auth := ""
+		if i == 0 {
+			auth = authField(cfg.Token)
+		}
+		fmt.Fprintf(&cmds, "GETL %d%s\r\n", n, auth)
+	}
+	if _, err := stream.Write([]byte(cmds.String())); err != nil {
+		reqSpan.End()
+		return nil, fmt.Errorf("write GETL: %w", err)
+	}
+	// closing our side tells the server no further requests follow
+	stream.Close()
+	reqSpan.SetAttributes(attribute.Int("request_bytes", cfg.RequestBytes), attribute.Int("requests", cfg.Pipeline))
+	reqSpan.End()
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	stats := NewClientStats()
+	cfg.salvage.track(stats)
+	stats.discardFirstRTT = cfg.DiscardFirstRTT
+	stats.window = newSlidingWindow(cfg.Window)
+	stats.summaryEvery = cfg.SummaryInterval
+	stats.quiet = cfg.Quiet
+	stats.keepSamples = cfg.KeepSamples
+	stats.gapThreshold = cfg.GapThreshold
+	stats.live = cfg.Live
+	stats.watch(session)
+	buf := make([]byte, cfg.ReadBuffer)
+
+	requests := make([]RequestResult, 0, cfg.Pipeline)
+	var readErr error
+	prev := stats.startTime
+	hdr := make([]byte, PipelineHeaderLen)
+	for i := range cfg.Pipeline {
+		if _, err := io.ReadFull(stream, hdr); err != nil {
+			readErr = fmt.Errorf("read response %d header: %w", i, err)
+			break
+		}
+		size := int(binary.BigEndian.Uint64(hdr))
+		for remaining := size; remaining > 0; {
+			n, err := stream.Read(buf[:min(len(buf), remaining)])
+			if n > 0 {
+				stats.Add(n)
+				remaining -= n
+			}
+			if err != nil && remaining > 0 {
+				readErr = fmt.Errorf("read response %d: %w", i, err)
+				break
+			}
+		}
+		if readErr != nil {
+			break
+		}
+		now := time.Now()
+		requests = append(requests, RequestResult{
+			Request: i,
+			Bytes:   size,
+			Done:    now.Sub(stats.startTime),
+			Elapsed: now.Sub(prev),
+			Goodput: float64(size) * 8.0 / 1e6 / now.Sub(prev).Seconds(),
+		})
+		prev = now
+	}
+
+	stats.PrintFinal()
+	if !cfg.Quiet {
+		printRequestTable(requests)
+	}
+
+	res := &Result{
+		Bytes:    stats.bytesRecv,
+		Elapsed:  time.Since(stats.startTime),
+		Goodput:  stats.Goodput(),
+		TTFB:     stats.TTFB(),
+		Requests: requests,
+
+		Termination: terminationOf(readErr),
+		RTT:         stats.RTT(),
+		Samples:     stats.Samples(),
+		Gaps:        stats.Gaps(),
+	}
+	if cfg.DiscardFirstRTT {
+		res.AdjustedGoodput, _ = stats.AdjustedGoodput()
+	}
+	xferSpan.SetAttributes(attribute.Int("bytes", res.Bytes), attribute.Float64("goodput_mbps", res.Goodput))
+	return res, readErr
+}
+
+// printRequestTable prints the per-response timing of a pipelined transfer.
+func printRequestTable(requests []RequestResult) {
+	fmt.Printf("%-8s %12s %10s %10s %14s\n", "request", "KB", "done (s)", "time (s)", "goodput (Mbps)")
+	for _, r := range requests {
+		fmt.Printf("%-8d %12.2f %10.3f %10.3f %14.2f\n", r.Request, float64(r.Bytes)/1024.0, r.Done.Seconds(), r.Elapsed.Seconds(), r.Goodput)
+	}
+}
@@ -0,0 +1,31 @@
+package goodput
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrefillLead(t *testing.T) {
+	addr := serveLoopback(t, ServerConfig{})
+	const prefill, request = 4 << 20, 1 << 20
+	for _, lead := range []int{0, prefill / 2, prefill, 2 * prefill} {
+		res, err := RunClient(context.Background(), ClientConfig{
+			Addr:         addr,
+			RequestBytes: request,
+			ReadBuffer:   64 * 1024,
+			PrefillBytes: prefill,
+			PrefillLead:  lead,
+			Quiet:        true,
+			Insecure:     true,
+		})
+		if err != nil {
+			t.Fatalf("lead %d: %v", lead, err)
+		}
+		if res.Bytes != request {
+			t.Errorf("lead %d: received %d of %d bytes", lead, res.Bytes, request)
+		}
+		if res.PrefillDuration <= 0 {
+			t.Errorf("lead %d: no prefill duration recorded", lead)
+		}
+	}
+}
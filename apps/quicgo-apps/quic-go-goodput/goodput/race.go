@@ -0,0 +1,148 @@
+package goodput
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// raceGrace is how long the losers of a connection race may go on after
+// the winner's handshake, to tell by how much it won, before they are
+// abandoned.
+const raceGrace = time.Second
+
+// RaceDial is one address's attempt in a connection race. Handshake is
+// how long it took to connect, if it did before it was abandoned.
+type RaceDial struct {
+	Addr      string        `json:"addr"`
+	Handshake time.Duration `json:"handshake_ns,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// RaceResult is how a connection race went. Margin is how much later than
+// the winner the runner-up connected, or zero if no other address did
+// within the grace period.
+type RaceResult struct {
+	Winner string        `json:"winner"`
+	Margin time.Duration `json:"margin_ns,omitempty"`
+	Dials  []RaceDial    `json:"dials"`
+}
+
+// raceDial dials every address in cfg.RaceAddrs at once and returns the
+// first connection to complete its handshake. The others are closed once
+// they connect, fail or the grace period after the winner ends; the
+// returned function waits for that and reports the race.
+func raceDial(ctx context.Context, cfg ClientConfig, tlsConf *tls.Config) (*quic.Conn, func() *RaceResult, error) {
+	type attempt struct {
+		i       int
+		conn    *quic.Conn
+		elapsed time.Duration
+		err     error
+	}
+	dialCtx, cancel := context.WithCancel(ctx)
+	attempts := make(chan attempt, len(cfg.RaceAddrs))
+	start := time.Now()
+	for i, addr := range cfg.RaceAddrs {
+		go func() {
+			c := cfg
+			c.Addr = addr
+			conn, err := dial(dialCtx, c, tlsConf.Clone())
+			attempts <- attempt{i: i, conn: conn, elapsed: time.Since(start), err: err}
+		}()
+	}
+
+	res := &RaceResult{Dials: make([]RaceDial, len(cfg.RaceAddrs))}
+	for i, addr := range cfg.RaceAddrs {
+		res.Dials[i].Addr = addr
+	}
+	record := func(a attempt) {
+		if a.err != nil {
+			res.Dials[a.i].Error = a.err.Error()
+			return
+		}
+		res.Dials[a.i].Handshake = a.elapsed
+	}
+
+	var winner attempt
+	var errs []error
+	pending := len(cfg.RaceAddrs)
+	for ; pending > 0 && winner.conn == nil; pending-- {
+		a := <-attempts
+		record(a)
+		if a.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.RaceAddrs[a.i], a.err))
+			continue
+		}
+		winner = a
+	}
+	if winner.conn == nil {
+		cancel()
+		return nil, nil, errors.Join(errs...)
+	}
+	res.Winner = cfg.RaceAddrs[winner.i]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		grace := time.After(raceGrace)
+		abandoned := false
+		for ; pending > 0; pending-- {
+			var a attempt
+			select {
+			case a = <-attempts:
+			case <-grace:
+				cancel()
+				abandoned = true
+				a = <-attempts
+			}
+			if a.err != nil && abandoned {
+				continue
+			}
+			record(a)
+			if a.conn == nil {
+				continue
+			}
+			a.conn.CloseWithError(0, "")
+			recordState(cfg.StateDump, a.conn)
+			if res.Margin == 0 {
+				res.Margin = a.elapsed - winner.elapsed
+			}
+		}
+	}()
+	return winner.conn, func() *RaceResult {
+		<-done
+		return res
+	}, nil
+}
+
+// logRace logs which address won a connection race and by how much.
+func logRace(res *RaceResult) {
+	var won time.Duration
+	for _, d := range res.Dials {
+		if d.Addr == res.Winner {
+			won = d.Handshake
+		}
+	}
+	if res.Margin > 0 {
+		log.Printf("Connection race: %s won in %.2f ms, %.2f ms ahead of the runner-up", res.Winner, toMs(won), toMs(res.Margin))
+	} else {
+		log.Printf("Connection race: %s won in %.2f ms, no other address connected within %v", res.Winner, toMs(won), raceGrace)
+	}
+	for _, d := range res.Dials {
+		switch {
+		case d.Addr == res.Winner:
+		case d.Handshake > 0:
+			log.Printf("  %s connected in %.2f ms", d.Addr, toMs(d.Handshake))
+		case d.Error != "":
+			log.Printf("  %s failed: %s", d.Addr, d.Error)
+		default:
+			log.Printf("  %s abandoned", d.Addr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package goodput
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RateSlice is one time slice of a RateTrace.
+type RateSlice struct {
+	Duration time.Duration
+	// Mbps is the target sending rate over the slice.
+	Mbps float64
+}
+
+// RateTrace is a variable-bitrate sending schedule. On disk it is a CSV with
+// a "duration_s,rate_mbps" header and one row per slice.
+type RateTrace []RateSlice
+
+// LoadRateTrace reads a rate trace from path.
+func LoadRateTrace(path string) (RateTrace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("%s: missing header: %w", path, err)
+	}
+	var t RateTrace
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		secs, err := strconv.ParseFloat(rec[0], 64)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("%s: invalid duration %q in slice %d", path, rec[0], len(t)+1)
+		}
+		mbps, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil || mbps < 0 {
+			return nil, fmt.Errorf("%s: invalid rate %q in slice %d", path, rec[1], len(t)+1)
+		}
+		t = append(t, RateSlice{Duration: time.Duration(secs * float64(time.Second)), Mbps: mbps})
+	}
+	if len(t) == 0 {
+		return nil, fmt.Errorf("%s: no slices", path)
+	}
+	return t, nil
+}
+
+// paceChunk is the largest write the paced sender issues at once.
+const paceChunk = 16 * 1024
+
+// writePaced writes data to w following trace: within each slice, writes are
+// spaced so the bytes sent track the slice's target rate. Once the trace is
+// exhausted its last slice repeats until data is written. It logs the
+// target and achieved rate of each slice; a slice whose writes block on
+// congestion or flow control runs long and falls short of its target.
+func writePaced(w io.Writer, data []byte, trace RateTrace) error {
+	for i := 0; len(data) > 0; i++ {
+		slice := trace[min(i, len(trace)-1)]
+		start := time.Now()
+		budget := int(slice.Mbps * 1e6 / 8 * slice.Duration.Seconds())
+		sent := 0
+		for sent < budget && len(data) > 0 {
+			n := min(paceChunk, budget-sent, len(data))
+			if _, err := w.Write(data[:n]); err != nil {
+				return err
+			}
+			data = data[n:]
+			sent += n
+			time.Sleep(time.Until(start.Add(time.Duration(float64(slice.Duration) * float64(sent) / float64(budget)))))
+		}
+		if len(data) > 0 {
+			time.Sleep(time.Until(start.Add(slice.Duration)))
+		}
+		elapsed := time.Since(start).Seconds()
+		log.Printf("Rate slice %d: target %.2f Mbps, achieved %.2f Mbps over %.3f s",
+			i+1, slice.Mbps, float64(sent)*8/1e6/elapsed, elapsed)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package goodput
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// BenchmarkReadBuffer measures the client's loopback goodput for a range
+// of -read-buffer sizes, each iteration a GETN of 8 MiB on a fresh
+// connection. Run it with -benchtime=20x or so; each size reports its
+// goodput in Mbps next to the usual per-transfer time.
+func BenchmarkReadBuffer(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	addr := serveLoopback(b, ServerConfig{})
+	const n = 8 << 20
+	for _, size := range []int{4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(n)
+			var mbps float64
+			for b.Loop() {
+				mbps += fetchLoopback(b, addr, n, size).Goodput
+			}
+			b.ReportMetric(mbps/float64(b.N), "Mbps")
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package goodput
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/qtrace"
+	"quic-go-common/telemetry"
+)
+
+// A resumable transfer is fetched with "GETRESUME <id> <offset> <length>"
+// requests: the first starts transfer id at offset 0, and after an
+// interruption the client reconnects and asks for the rest from the last
+// byte offset it received. The server remembers, per id, the length and how
+// far it got, so it can check the offset and log how much was in flight when
+// the connection broke. The length may be omitted when serving a -file, in
+// which case it is the file size.
+//
+// Generated payloads are generated afresh on every request, so only file
+// contents and zero-entropy payloads are byte-identical across a resume.
+//
+// Unless it is Concurrent, RunServer handles one connection at a time, so a
+// resumed connection is served only once the server's idle timeout has
+// ended the broken one.
+
+// transfer is the server's record of one resumable transfer.
+type transfer struct {
+	length int64
+	// sent is how far the server has written
+	sent int64
+	seen time.Time
+}
+
+// transferTable holds the resumable transfers seen in the last ttl.
+type transferTable struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]*transfer
+}
+
+func newTransferTable(ttl time.Duration) *transferTable {
+	return &transferTable{ttl: ttl, m: make(map[string]*transfer)}
+}
+
+// resume looks up transfer id, creating it if offset is zero, and checks
+// that offset and length are consistent with what was sent before. It
+// returns the transfer and how far it had been sent.
+func (tt *transferTable) resume(id string, offset, length int64) (*transfer, int64, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	now := time.Now()
+	for k, t := range tt.m {
+		if now.Sub(t.seen) > tt.ttl {
+			delete(tt.m, k)
+		}
+	}
+
+	t, ok := tt.m[id]
+	switch {
+	case !ok && offset != 0:
+		return nil, 0, fmt.Errorf("%w: unknown or expired transfer %q", errBadRequest, id)
+	case !ok:
+		t = &transfer{length: length}
+		tt.m[id] = t
+	case length != t.length:
+		return nil, 0, fmt.Errorf("%w: transfer %q has length %d, not %d", errBadRequest, id, t.length, length)
+	case offset > t.sent:
+		return nil, 0, fmt.Errorf("%w: offset %d of transfer %q is past the %d bytes sent", errBadRequest, offset, id, t.sent)
+	}
+	sent := t.sent
+	t.sent = offset
+	t.seen = now
+	return t, sent, nil
+}
+
+// advance records n more bytes of t written to the client.
+func (tt *transferTable) advance(t *transfer, n int) {
+	tt.mu.Lock()
+	t.sent += int64(n)
+	t.seen = time.Now()
+	tt.mu.Unlock()
+}
+
+// transferWriter passes writes through to w and records their progress on t.
+type transferWriter struct {
+	w     io.Writer
+	table *transferTable
+	t     *transfer
+}
+
+func (tw transferWriter) Write(p []byte) (int, error) {
+	n, err := tw.w.Write(p)
+	tw.table.advance(tw.t, n)
+	return n, err
+}
+
+// parseResume parses the "<id> <offset> [<length>]" argument of a GETRESUME
+// request. A missing length is returned as zero.
+func parseResume(arg string) (id string, offset, length int64, err error) {
+	fields := strings.Fields(arg)
+	if len(fields) != 2 && len(fields) != 3 {
+		return "", 0, 0, fmt.Errorf("%w: invalid resume request %q", errBadRequest, strings.TrimSpace(arg))
+	}
+	id = fields[0]
+	offset, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, 0, fmt.Errorf("%w: invalid offset %q", errBadRequest, fields[1])
+	}
+	if len(fields) == 3 {
+		length, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || length <= 0 {
+			return "", 0, 0, fmt.Errorf("%w: invalid length %q", errBadRequest, fields[2])
+		}
+	}
+	return id, offset, length, nil
+}
+
+// serveResume writes the rest of the resumable transfer requested by arg,
+// from cfg.File if set, to stream and closes it. It reports whether the
+// transfer completed.
+func serveResume(ctx context.Context, stream *quic.Stream, arg string, cfg ServerConfig) bool {
+	t, offset, sent, err := resolveResume(arg, cfg)
+	if err != nil {
+		log.Println(err)
+		stream.CancelWrite(42)
+		return false
+	}
+	if offset > 0 {
+		log.Printf("Resuming transfer %s at offset %d of %d; %d bytes written before the interruption were not received",
+			strings.Fields(arg)[0], offset, t.length, sent-offset)
+	}
+
+	var src io.Reader
+	if cfg.File != nil {
+		src = io.NewSectionReader(cfg.File, offset, t.length-offset)
+	} else {
+		src = bytes.NewReader(newPayload(int(t.length-offset), offset, cfg))
+	}
+	if !cfg.think(stream.Context()) {
+		return false
+	}
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	start := time.Now()
+	n, err := io.Copy(transferWriter{stream, cfg.transfers, t}, src)
+	if err != nil {
+		log.Printf("Write error at offset %d: %v", offset+n, err)
+		return false
+	}
+	if err := stream.Close(); err != nil {
+		log.Println("Stream close error:", err)
+		return false
+	}
+	elapsed := time.Since(start).Seconds()
+	mbps := float64(n) / 1_000_000.0 * 8.0 / elapsed
+
+	xferSpan.SetAttributes(attribute.Int64("offset", offset), attribute.Int64("bytes", n), attribute.Float64("goodput_mbps", mbps))
+	log.Printf("Send %.2f KB from offset %d in %.3f s, goodput: %.2f Mbps\n", float64(n)/1024.0, offset, elapsed, mbps)
+	return true
+}
+
+// resolveResume parses a GETRESUME argument and looks up the transfer,
+// returning it, the offset to continue from and how far it had been sent.
+func resolveResume(arg string, cfg ServerConfig) (*transfer, int64, int64, error) {
+	if cfg.transfers == nil {
+		return nil, 0, 0, fmt.Errorf("%w: resumable transfers are disabled", errBadRequest)
+	}
+	id, offset, length, err := parseResume(arg)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.File != nil {
+		info, err := cfg.File.Stat()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if length == 0 {
+			length = info.Size()
+		}
+		if length > info.Size() {
+			return nil, 0, 0, fmt.Errorf("%w: length %d is beyond the %d byte file", errBadRequest, length, info.Size())
+		}
+	}
+	if length == 0 {
+		return nil, 0, 0, fmt.Errorf("%w: GETRESUME needs a length unless serving a file", errBadRequest)
+	}
+	if limit := cfg.requestCap(); limit > 0 && length > int64(limit) {
+		return nil, 0, 0, fmt.Errorf("%w: %d bytes exceeds the %d byte cap", errBadRequest, length, limit)
+	}
+	if offset > length {
+		return nil, 0, 0, fmt.Errorf("%w: offset %d is past the %d byte transfer", errBadRequest, offset, length)
+	}
+	t, sent, err := cfg.transfers.resume(id, offset, length)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return t, offset, sent, nil
+}
+
+// runResumable fetches cfg.RequestBytes as a resumable transfer, dialing a
+// fresh connection after each interruption, up to cfg.Resumes times, and
+// continuing from the last byte received. Goodput covers the whole transfer,
+// reconnections included.
+func runResumable(ctx context.Context, cfg ClientConfig, tlsConf *tls.Config) (*Result, error) {
+	id := cfg.TransferID
+	if id == "" {
+		var b [8]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+
+	ctx, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	stats := NewClientStats()
+	cfg.salvage.track(stats)
+	stats.discardFirstRTT = cfg.DiscardFirstRTT
+	stats.window = newSlidingWindow(cfg.Window)
+	stats.summaryEvery = cfg.SummaryInterval
+	stats.quiet = cfg.Quiet
+	stats.keepSamples = cfg.KeepSamples
+	stats.gapThreshold = cfg.GapThreshold
+	stats.live = cfg.Live
+	buf := make([]byte, cfg.ReadBuffer)
+
+	var offset, resumes int
+	var lastErr error
+	var negotiated *qtrace.Negotiated
+	for {
+		if resumes > 0 {
+			log.Printf("Resuming transfer %s at offset %d (%d of %d resumes)", id, offset, resumes, cfg.Resumes)
+		}
+		dialStart := time.Now()
+		session, err := dial(ctx, cfg, tlsConf)
+		if err == nil {
+			if resumes > 0 {
+				log.Printf("Reconnected in %.3f s", time.Since(dialStart).Seconds())
+			}
+			stats.watch(session)
+			if negotiated == nil {
+				negotiated = logNegotiated(session, cfg.Params)
+			}
+			var n int
+			n, err = fetchResume(ctx, session, id, offset, cfg.RequestBytes, cfg.Token, buf, stats)
+			offset += n
+			session.CloseWithError(0, "")
+			recordState(cfg.StateDump, session)
+		} else {
+			err = dialError(err)
+		}
+		lastErr = err
+		var se *quic.StreamError
+		if err == nil || errors.As(err, &se) || resumes >= cfg.Resumes || ctx.Err() != nil {
+			break
+		}
+		log.Printf("Transfer interrupted at offset %d: %v", offset, err)
+		resumes++
+	}
+
+	stats.PrintFinal()
+	res := &Result{
+		Bytes:   stats.bytesRecv,
+		Elapsed: time.Since(stats.startTime),
+		Goodput: stats.Goodput(),
+		TTFB:    stats.TTFB(),
+		Resumes: resumes,
+
+		Termination: terminationOf(lastErr),
+		RTT:         stats.RTT(),
+		Samples:     stats.Samples(),
+		Gaps:        stats.Gaps(),
+		Negotiated:  negotiated,
+	}
+	if cfg.DiscardFirstRTT {
+		res.AdjustedGoodput, _ = stats.AdjustedGoodput()
+	}
+	xferSpan.SetAttributes(attribute.Int("bytes", res.Bytes), attribute.Float64("goodput_mbps", res.Goodput), attribute.Int("resumes", resumes))
+	return res, lastErr
+}
+
+// fetchResume requests transfer id from offset on session and reads the
+// response into stats. It returns the number of bytes read, and an error
+// unless the transfer reached length.
+func fetchResume(ctx context.Context, session *quic.Conn, id string, offset, length int, token string, buf []byte, stats *ClientStats) (int, error) {
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("open stream: %w", err)
+	}
+	if _, err := fmt.Fprintf(stream, "GETRESUME %s %d %d%s\r\n", id, offset, length, authField(token)); err != nil {
+		return 0, fmt.Errorf("write GETRESUME: %w", err)
+	}
+	got := 0
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			got += n
+			stats.Add(n)
+		}
+		if err == io.EOF {
+			if offset+got < length {
+				return got, fmt.Errorf("server ended the transfer at offset %d of %d", offset+got, length)
+			}
+			return got, nil
+		}
+		if err != nil {
+			return got, fmt.Errorf("read: %w", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package goodput
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// salvage remembers the stats of the transfer in progress, so that a
+// result can be made of them if the run panics.
+type salvage struct {
+	mu    sync.Mutex
+	stats *ClientStats
+}
+
+// track makes stats the ones to salvage. A nil salvage tracks nothing.
+func (s *salvage) track(stats *ClientStats) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+}
+
+// result returns what the tracked stats got to before the panic r.
+func (s *salvage) result(r any) *Result {
+	res := &Result{
+		Termination: Termination{Kind: TermPanic},
+		Incomplete:  fmt.Sprintf("panic: %v", r),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats != nil {
+		res.Bytes = s.stats.bytesRecv
+		res.Elapsed = time.Since(s.stats.startTime)
+		res.Goodput = s.stats.Goodput()
+		res.TTFB = s.stats.TTFB()
+		res.RTT = s.stats.RTT()
+		res.Samples = s.stats.Samples()
+	}
+	return res
+}
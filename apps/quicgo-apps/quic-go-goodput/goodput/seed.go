@@ -0,0 +1,28 @@
+package goodput
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// DeriveSeed returns the sub-seed named name of a run's seed, so that one
+// seed fixes every random choice of a run while each choice draws from its
+// own stream. The same seed and name always give the same sub-seed.
+func DeriveSeed(seed uint64, name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return rand.New(rand.NewPCG(seed, h.Sum64())).Uint64()
+}
+
+// payloadStream is the PCG stream seeded payloads draw from.
+const payloadStream = 0x676f6f647075742d
+
+// payloadRand returns the generator for one response's payload: seeded by
+// seed, so every response of a run carries the same bytes, or nil for the
+// global generator when seed is zero.
+func payloadRand(seed uint64) *rand.Rand {
+	if seed == 0 {
+		return nil
+	}
+	return rand.New(rand.NewPCG(seed, payloadStream))
+}
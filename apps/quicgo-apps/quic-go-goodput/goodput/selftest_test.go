@@ -0,0 +1,15 @@
+package goodput
+
+import "testing"
+
+// TestSelftest is the client's selftest mode as a smoke test: a server and
+// a client in this process over loopback, with every requested byte
+// arriving.
+func TestSelftest(t *testing.T) {
+	addr := serveLoopback(t, ServerConfig{})
+	for _, n := range []int{1, 64 << 10, 10 << 20} {
+		if res := fetchLoopback(t, addr, n, 65536); res.Goodput <= 0 {
+			t.Errorf("%d bytes: goodput %.2f Mbps", n, res.Goodput)
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package goodput
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/qtrace"
+	"quic-go-common/telemetry"
+)
+
+// ServerConfig configures RunServer.
+type ServerConfig struct {
+	// TLSConfig is used for the QUIC listener; a self-signed certificate is
+	// generated when it is nil.
+	TLSConfig *tls.Config
+	// QUICConfig is passed to quic.Listen, or to quic.ListenEarly if it
+	// sets Allow0RTT; nil uses the quic-go defaults.
+	QUICConfig *quic.Config
+	// Entropy sets how compressible the payload is, from 0 (all zeros) to 1
+	// (random); see payload.Fill.
+	Entropy float64
+	// PayloadSeed, if nonzero, seeds the random payload bytes so that
+	// every response of a given size carries the same bytes from run to
+	// run; zero draws them from the global generator.
+	PayloadSeed uint64
+	// PayloadPattern is PatternEntropy (the default when empty) or
+	// PatternSignature, which ignores Entropy and PayloadSeed.
+	PayloadPattern string
+	// MaxBytes caps the payload size of a single request; larger requests
+	// are rejected with errBadRequest. A response is generated in memory
+	// before it is sent, so zero, no cap, lets a single request take as
+	// much memory as it asks for; servers default to DefaultMaxBytes.
+	MaxBytes int
+	// RateTrace, if set, paces GETN and GETP responses (each GETP stream on
+	// its own) to its schedule of target rates and logs target against
+	// achieved rate per slice.
+	RateTrace RateTrace
+	// Control, if set, paces, stops and starts GETN and GETP responses as
+	// told by its control connections, in place of RateTrace.
+	Control *Control
+	// Dataset, if set, is a payload preloaded at startup that every GETN,
+	// GETP, GETL, GETRESUME and TCP GETN response is taken from, read-only,
+	// instead of being generated per request: a response of n bytes is its
+	// first n bytes (GETRESUME continuing at its offset), so every client
+	// receives identical bytes. It caps requests at its size, below
+	// MaxBytes. Connections share it, so it must not be modified.
+	Dataset []byte
+	// Pipe, if set, is streamed by the first GETN response in place of a
+	// generated payload, unpaced, and the GETN requests after it are
+	// rejected; see Pipe.
+	Pipe *Pipe
+	// File, if set, is served by GETRANGE requests. It is only read, with
+	// ReadAt, so connections may share it.
+	File *os.File
+	// Token, if set, must be carried by the first request line of every
+	// stream as a trailing auth=<token> field. A QUIC connection whose
+	// request lacks it is closed with AuthErrorCode before any work is done.
+	Token string
+	// Concurrent handles connections in parallel rather than one at a time,
+	// so that several clients' flows compete for the network.
+	Concurrent bool
+	// AcceptWorkers, if positive, accepts connections from this many
+	// goroutines into a queue while earlier connections are handled, and
+	// logs each connection's accept-to-handle latency.
+	AcceptWorkers int
+	// ResumeTTL is how long the server remembers a resumable transfer after
+	// its last activity; zero disables GETRESUME.
+	ResumeTTL time.Duration
+	// FinTimeout bounds how long the server waits after a GETN, GETRANGE or
+	// GETRESUME response for the client to close the connection, so the
+	// tail of the response is not lost to an early close. Zero closes right
+	// away.
+	FinTimeout time.Duration
+	// Stats, if set, counts every connection and the bytes it sent: all
+	// packet bytes on QUIC, retransmissions included, and the response on
+	// TCP.
+	Stats *ServerStats
+	// Sender, if set, must also be installed as QUICConfig.Tracer. Each
+	// connection then logs, when it ends, its application goodput against
+	// its estimated on-the-wire throughput if ReportWire is set, the time
+	// it spent blocked on flow control if ReportFlowControl is set, and the
+	// payload it sent and received in 0-RTT against 1-RTT packets if
+	// ReportSpaces is set.
+	Sender            *qtrace.SenderCounter
+	ReportWire        bool
+	ReportFlowControl bool
+	ReportSpaces      bool
+
+	// Params, if set, must also be installed as QUICConfig.Tracer; each
+	// connection then logs its negotiated QUIC version and transport
+	// parameters.
+	Params *qtrace.ParamsRecorder
+	// StateDump, if set, must also be installed as QUICConfig.Tracer; each
+	// connection's final state is then written to it once it closes.
+	StateDump *qtrace.StateDump
+
+	// ThinkTime holds every response for this long after its request is
+	// parsed, before the first byte is sent, like a server processing the
+	// request. The logged transfer times exclude it; the client's TTFB
+	// includes it.
+	ThinkTime time.Duration
+	// InitialBurst sends the first this many bytes of every GETN and GETP
+	// response, and of TCP GETN ones, as fast as flow and congestion
+	// control allow before RateTrace or Control paces the rest, like a
+	// video player filling its buffer before the steady rate, and logs
+	// when the burst completed.
+	InitialBurst int
+	// AcceptHints applies the hints a client carries in its requests to its
+	// connection; see hintKeys for what can vary per connection. Without
+	// it, hints are logged and ignored.
+	AcceptHints bool
+	// HandshakeOnly closes every connection with HandshakeOnlyErrorCode as
+	// soon as its handshake completes, serving no requests, and logs how
+	// long the handshake took from the connection's first packet, to
+	// isolate the server's handshake cost.
+	HandshakeOnly bool
+
+	// transfers is set up by RunServer from ResumeTTL.
+	transfers *transferTable
+	// handshakes is set up by RunServer for HandshakeOnly.
+	handshakes *handshakeClock
+}
+
+// errBadRequest is returned for a request whose size is malformed or over
+// ServerConfig.MaxBytes.
+var errBadRequest = errors.New("bad request")
+
+// parseRequestBytes parses the payload size argument of a request and checks
+// it against maxBytes.
+func parseRequestBytes(arg string, maxBytes int) (int, error) {
+	numBytes, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || numBytes <= 0 {
+		return 0, fmt.Errorf("%w: invalid size %q", errBadRequest, strings.TrimSpace(arg))
+	}
+	if maxBytes > 0 && numBytes > maxBytes {
+		return 0, fmt.Errorf("%w: %d bytes exceeds the %d byte cap", errBadRequest, numBytes, maxBytes)
+	}
+	return numBytes, nil
+}
+
+// think holds a response for cfg.ThinkTime, and reports false if ctx ends
+// first.
+func (cfg ServerConfig) think(ctx context.Context) bool {
+	if cfg.ThinkTime <= 0 {
+		return true
+	}
+	t := time.NewTimer(cfg.ThinkTime)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// writeBurst writes the first cfg.InitialBurst bytes of data to w unpaced
+// and logs how long they took from start. It returns the rest of data.
+func (cfg ServerConfig) writeBurst(w io.Writer, data []byte, start time.Time) ([]byte, error) {
+	if cfg.InitialBurst <= 0 {
+		return data, nil
+	}
+	n := min(cfg.InitialBurst, len(data))
+	if _, err := w.Write(data[:n]); err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start).Seconds()
+	log.Printf("Initial burst of %.2f KB in %.3f s, %.2f Mbps; %.2f KB left to pace\n",
+		float64(n)/1024.0, elapsed, float64(n)*8.0/1e6/elapsed, float64(len(data)-n)/1024.0)
+	return data[n:], nil
+}
+
+// DefaultMaxBytes is the MaxBytes a server applies unless told otherwise.
+const DefaultMaxBytes = 1 << 30
+
+// requestCap is the largest payload a request may ask for, zero for no
+// cap: MaxBytes, lowered to the size of the Dataset.
+func (cfg ServerConfig) requestCap() int {
+	if cfg.Dataset != nil && (cfg.MaxBytes == 0 || cfg.MaxBytes > len(cfg.Dataset)) {
+		return len(cfg.Dataset)
+	}
+	return cfg.MaxBytes
+}
+
+// parseRange parses the "<offset> <length>" argument of a GETRANGE request
+// and checks it against the size of f and against maxBytes.
+func parseRange(arg string, f *os.File, maxBytes int) (offset, length int64, err error) {
+	if f == nil {
+		return 0, 0, fmt.Errorf("%w: GETRANGE needs a file to serve", errBadRequest)
+	}
+	fields := strings.Fields(arg)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("%w: invalid range %q", errBadRequest, strings.TrimSpace(arg))
+	}
+	offset, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("%w: invalid offset %q", errBadRequest, fields[0])
+	}
+	length, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, fmt.Errorf("%w: invalid length %q", errBadRequest, fields[1])
+	}
+	if maxBytes > 0 && length > int64(maxBytes) {
+		return 0, 0, fmt.Errorf("%w: %d bytes exceeds the %d byte cap", errBadRequest, length, maxBytes)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset > info.Size()-length {
+		return 0, 0, fmt.Errorf("%w: range %d+%d is beyond the %d byte file", errBadRequest, offset, length, info.Size())
+	}
+	return offset, length, nil
+}
+
+// RunServer serves GETN requests on conn, one connection at a time unless
+// cfg.Concurrent is set, until ctx is cancelled.
+func RunServer(ctx context.Context, conn net.PacketConn, cfg ServerConfig) error {
+	return RunShards(ctx, []net.PacketConn{conn}, cfg)
+}
+
+// RunShards is RunServer over several sockets bound to the same address
+// with SO_REUSEPORT, each with its own listener and accept loop, so that
+// the kernel spreads the connections over them by 4-tuple and one process
+// can receive and accept on several CPUs. The shards share cfg: without
+// cfg.Concurrent each still handles one connection at a time, and a
+// GETRESUME finds its transfer whichever shard it lands on. With more than
+// one shard it logs how many connections each took once they stop
+// accepting.
+func RunShards(ctx context.Context, conns []net.PacketConn, cfg ServerConfig) error {
+	tlsConf := cfg.TLSConfig
+	if tlsConf == nil {
+		var err error
+		if tlsConf, err = GenerateTLSConfig(); err != nil {
+			return err
+		}
+	}
+
+	quicConf := cfg.QUICConfig
+	if quicConf == nil {
+		quicConf = &quic.Config{}
+	}
+	if cfg.HandshakeOnly {
+		cfg.handshakes = newHandshakeClock()
+		quicConf = quicConf.Clone()
+		quicConf.Tracer = qtrace.Multi(quicConf.Tracer, cfg.handshakes.tracer)
+	}
+	if cfg.ResumeTTL > 0 {
+		cfg.transfers = newTransferTable(cfg.ResumeTTL)
+	}
+
+	// 0-RTT is only accepted on an early listener, which also hands out
+	// connections before their handshake completes
+	listeners := make([]*countingAcceptor, 0, len(conns))
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, conn := range conns {
+		var l acceptor
+		var err error
+		if quicConf.Allow0RTT {
+			l, err = quic.ListenEarly(conn, tlsConf, quicConf)
+		} else {
+			l, err = quic.Listen(conn, tlsConf, quicConf)
+		}
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, &countingAcceptor{acceptor: l})
+	}
+
+	// the connections are handled, and drained, before the listeners close
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if len(listeners) > 1 {
+		defer reportShards(listeners)
+	}
+	// the first shard to fail stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			err := serveListener(ctx, l, cfg, &wg)
+			if err != nil {
+				cancel()
+			}
+			errc <- err
+		}()
+	}
+	var err error
+	for range listeners {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// serveListener accepts connections from listener and dispatches them
+// until ctx is cancelled.
+func serveListener(ctx context.Context, listener acceptor, cfg ServerConfig, wg *sync.WaitGroup) error {
+	if cfg.AcceptWorkers > 0 {
+		return serveAccepted(ctx, listener, cfg, wg)
+	}
+	for {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		dispatch(conn, cfg, wg)
+	}
+}
+
+// countingAcceptor counts the connections a shard's listener accepts.
+type countingAcceptor struct {
+	acceptor
+	accepted atomic.Int64
+}
+
+func (l *countingAcceptor) Accept(ctx context.Context) (*quic.Conn, error) {
+	conn, err := l.acceptor.Accept(ctx)
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// reportShards logs how many connections each shard accepted.
+func reportShards(listeners []*countingAcceptor) {
+	counts := make([]string, len(listeners))
+	var total int64
+	for i, l := range listeners {
+		n := l.accepted.Load()
+		total += n
+		counts[i] = strconv.FormatInt(n, 10)
+	}
+	log.Printf("Connections per shard over %d shards: %s (%d in all)", len(listeners), strings.Join(counts, ", "), total)
+}
+
+// acceptor is a quic.Listener or quic.EarlyListener.
+type acceptor interface {
+	Accept(context.Context) (*quic.Conn, error)
+	Close() error
+}
+
+// dispatch handles conn, in a goroutine tracked by wg if cfg.Concurrent.
+func dispatch(conn *quic.Conn, cfg ServerConfig, wg *sync.WaitGroup) {
+	if !cfg.Concurrent {
+		handleConnection(conn, cfg)
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handleConnection(conn, cfg)
+	}()
+}
+
+func handleConnection(conn *quic.Conn, cfg ServerConfig) {
+	cfg.Stats.opened()
+	defer func() { cfg.Stats.closed(int64(conn.ConnectionStats().BytesSent)) }()
+	defer func() {
+		conn.CloseWithError(0, "")
+		recordState(cfg.StateDump, conn)
+	}()
+	if cfg.Sender != nil {
+		// looked up now, as the counter forgets the connection once it closes
+		sender := cfg.Sender.Conn(conn.Context())
+		defer func() {
+			s, ok := sender.Stats()
+			if !ok {
+				return
+			}
+			if cfg.ReportWire {
+				log.Printf("Wire: %s", s.WireSummary())
+			}
+			if cfg.ReportFlowControl {
+				log.Printf("Flow control: %s", s.FlowControlSummary())
+			}
+			if cfg.ReportSpaces {
+				log.Printf("Packet spaces: %s", s.SpaceSummary())
+			}
+		}()
+	}
+
+	ctx, connSpan := telemetry.Tracer().Start(context.Background(), "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", conn.RemoteAddr().String()))
+	defer connSpan.End()
+	if cfg.HandshakeOnly {
+		closeAfterHandshake(conn, cfg.handshakes)
+		return
+	}
+	log.Printf("Negotiated ALPN: %s", conn.ConnectionState().TLS.NegotiatedProtocol)
+	logNegotiated(conn, cfg.Params)
+
+	// FILL requests keep the connection open for a following request; the
+	// connection is closed after the first GETN, GETRANGE or GETRESUME. FILL
+	// and GETP requests are served concurrently, so a prefill is still in
+	// flight when the measured request arrives; GETP, like GETL pipelines
+	// and PING streams, leaves closing the connection to the client.
+	var parallel sync.WaitGroup
+	defer parallel.Wait()
+	for {
+		stream, rd, request, err := acceptRequest(ctx, conn)
+		if err != nil {
+			var qe *quic.ApplicationError
+			if !(errors.As(err, &qe) && qe.ErrorCode == 0) {
+				log.Println(err)
+			}
+			return
+		}
+		var ok bool
+		if request, ok = authenticate(request, cfg.Token); !ok {
+			log.Printf("Rejected request without a valid token from %s", conn.RemoteAddr())
+			conn.CloseWithError(AuthErrorCode, "unauthorized")
+			return
+		}
+		if request, err = cfg.applyHints(request, conn.RemoteAddr()); err != nil {
+			log.Println(err)
+			stream.CancelWrite(42)
+			return
+		}
+		// GETP and PING streams run on with the hints as of their request
+		cfg := cfg
+
+		switch {
+		case strings.HasPrefix(request, "FILL"):
+			parallel.Add(1)
+			go func() {
+				defer parallel.Done()
+				serveBytes(ctx, stream, strings.TrimPrefix(request, "FILL"), "prefill", cfg)
+			}()
+		case strings.HasPrefix(request, "GETP"):
+			parallel.Add(1)
+			go func() {
+				defer parallel.Done()
+				serveBytes(ctx, stream, strings.TrimPrefix(request, "GETP"), "transfer", cfg)
+			}()
+		case strings.HasPrefix(request, "PING"):
+			parallel.Add(1)
+			go func() {
+				defer parallel.Done()
+				servePing(stream, rd, request)
+			}()
+		case strings.HasPrefix(request, "GETL"):
+			if !servePipelined(ctx, stream, rd, request, cfg) {
+				return
+			}
+		case strings.HasPrefix(request, "GETRESUME"):
+			if serveResume(ctx, stream, strings.TrimPrefix(request, "GETRESUME"), cfg) {
+				awaitClientClose(conn, cfg.FinTimeout)
+			}
+			return
+		case strings.HasPrefix(request, "GETRANGE"):
+			if serveRange(ctx, stream, strings.TrimPrefix(request, "GETRANGE"), cfg) {
+				awaitClientClose(conn, cfg.FinTimeout)
+			}
+			return
+		case strings.HasPrefix(request, "GETN") && cfg.Pipe != nil:
+			if servePipe(ctx, stream, cfg) {
+				awaitClientClose(conn, cfg.FinTimeout)
+			}
+			return
+		case strings.HasPrefix(request, "GETN"):
+			if serveBytes(ctx, stream, strings.TrimPrefix(request, "GETN"), "transfer", cfg) {
+				awaitClientClose(conn, cfg.FinTimeout)
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// awaitClientClose waits up to timeout for the client to close conn. quic-go
+// does not report when a stream has been fully acknowledged, but the client
+// closes the connection once it has read the whole response, so by then
+// nothing is left in flight.
+func awaitClientClose(conn *quic.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(timeout):
+		log.Printf("Client did not close the connection within %v, closing", timeout)
+	}
+}
+
+// acceptRequest accepts the next client stream and reads its request line.
+// The returned reader holds any pipelined requests that follow it.
+func acceptRequest(ctx context.Context, conn *quic.Conn) (*quic.Stream, *bufio.Reader, string, error) {
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
+	defer reqSpan.End()
+
+	stream, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("accept stream: %w", err)
+	}
+
+	rd := bufio.NewReaderSize(stream, 4096)
+	line, err := rd.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, nil, "", fmt.Errorf("read request: %w", err)
+	}
+
+	request := strings.TrimSpace(line)
+	reqSpan.SetAttributes(attribute.String("request", request))
+	return stream, rd, request, nil
+}
+
+// servePipelined answers request and every GETL request following it on
+// the stream, in order, each with an 8-byte big-endian length and then the
+// payload. The stream is closed once the client has closed its side. It
+// reports whether all requests were served.
+func servePipelined(ctx context.Context, stream *quic.Stream, rd *bufio.Reader, request string, cfg ServerConfig) bool {
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+
+	served := 0
+	for {
+		if !strings.HasPrefix(request, "GETL") {
+			log.Printf("Invalid pipelined request %q", request)
+			stream.CancelWrite(42)
+			return false
+		}
+		numBytes, err := parseRequestBytes(strings.TrimPrefix(request, "GETL"), cfg.requestCap())
+		if err != nil {
+			log.Println(err)
+			stream.CancelWrite(42)
+			return false
+		}
+
+		if !cfg.think(stream.Context()) {
+			return false
+		}
+		start := time.Now()
+		resp := make([]byte, PipelineHeaderLen+numBytes)
+		fillPayload(resp[PipelineHeaderLen:], 0, cfg)
+		binary.BigEndian.PutUint64(resp, uint64(numBytes))
+		if err := writeFull(stream, resp); err != nil {
+			log.Println("Write error:", err)
+			return false
+		}
+		elapsed := time.Since(start).Seconds()
+		served++
+		log.Printf("Send %.2f KB (request %d) in %.3f s, goodput: %.2f Mbps\n",
+			float64(numBytes)/1024.0, served, elapsed, float64(numBytes)*8.0/1e6/elapsed)
+
+		line, err := rd.ReadString('\n')
+		if err != nil && (err != io.EOF || strings.TrimSpace(line) == "") {
+			break
+		}
+		// the stream was authenticated, and its hints applied, by its first
+		// request
+		request, _ = authenticate(strings.TrimSpace(line), "")
+		request, _ = splitHints(request)
+	}
+
+	if err := stream.Close(); err != nil {
+		log.Println("Stream close error:", err)
+		return false
+	}
+	xferSpan.SetAttributes(attribute.Int("requests", served))
+	return true
+}
+
+// serveBytes writes the number of payload bytes given by arg to stream and
+// closes it. It reports whether the transfer completed.
+func serveBytes(ctx context.Context, stream *quic.Stream, arg string, phase string, cfg ServerConfig) bool {
+	numBytes, err := parseRequestBytes(arg, cfg.requestCap())
+	if err != nil {
+		log.Println(err)
+		stream.CancelWrite(42)
+		return false
+	}
+
+	packetBuf := newPayload(numBytes, 0, cfg)
+	if !cfg.think(stream.Context()) {
+		return false
+	}
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, phase)
+	defer xferSpan.End()
+	start := time.Now()
+	if phase == "transfer" {
+		packetBuf, err = cfg.writeBurst(stream, packetBuf, start)
+	}
+	switch {
+	case err != nil:
+	case phase == "transfer" && cfg.Control != nil:
+		err = cfg.Control.write(stream.Context(), stream, packetBuf)
+	case phase == "transfer" && cfg.RateTrace != nil:
+		err = writePaced(stream, packetBuf, cfg.RateTrace)
+	default:
+		err = writeFull(stream, packetBuf)
+	}
+	if err != nil {
+		log.Println("Write error:", err)
+		return false
+	}
+	if err := stream.Close(); err != nil {
+		log.Println("Stream close error:", err)
+		return false
+	}
+	elapsed := time.Since(start).Seconds()
+
+	mb := float64(numBytes) / 1_000_000.0
+	mbps := mb * 8.0 / elapsed
+	KB := float64(numBytes) / 1024.0
+
+	xferSpan.SetAttributes(attribute.Int("bytes", numBytes), attribute.Float64("goodput_mbps", mbps))
+	if phase == "prefill" {
+		log.Printf("Prefill %.2f KB in %.3f s\n", KB, elapsed)
+	} else {
+		log.Printf("Send %.2f KB in %.3f s, goodput: %.2f Mbps\n", KB, elapsed, mbps)
+	}
+	return true
+}
+
+// serveRange writes the byte range of cfg.File given by arg to stream and
+// closes it. It reports whether the transfer completed.
+func serveRange(ctx context.Context, stream *quic.Stream, arg string, cfg ServerConfig) bool {
+	offset, length, err := parseRange(arg, cfg.File, cfg.requestCap())
+	if err != nil {
+		log.Println(err)
+		stream.CancelWrite(42)
+		return false
+	}
+	if !cfg.think(stream.Context()) {
+		return false
+	}
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	start := time.Now()
+	if _, err := io.Copy(stream, io.NewSectionReader(cfg.File, offset, length)); err != nil {
+		log.Println("Write error:", err)
+		return false
+	}
+	if err := stream.Close(); err != nil {
+		log.Println("Stream close error:", err)
+		return false
+	}
+	elapsed := time.Since(start).Seconds()
+	mbps := float64(length) / 1_000_000.0 * 8.0 / elapsed
+
+	xferSpan.SetAttributes(attribute.Int64("offset", offset), attribute.Int64("bytes", length), attribute.Float64("goodput_mbps", mbps))
+	log.Printf("Send %.2f KB from offset %d in %.3f s, goodput: %.2f Mbps\n", float64(length)/1024.0, offset, elapsed, mbps)
+	return true
+}
+
+// GenerateTLSConfig returns a server TLS config with a fresh self-signed
+// certificate for localhost, offering DefaultALPN.
+func GenerateTLSConfig() (*tls.Config, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Subject:      pkix.Name{CommonName: "localhost"},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{DefaultALPN},
+	}, nil
+}
+
+func writeFull(stream *quic.Stream, data []byte) error {
+	remaining := data
+	for len(remaining) > 0 {
+		n, err := stream.Write(remaining)
+		if n > 0 {
+			remaining = remaining[n:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
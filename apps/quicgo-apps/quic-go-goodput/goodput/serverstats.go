@@ -0,0 +1,58 @@
+package goodput
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats are aggregate counters over every connection a server
+// handles. They are updated with atomics once per connection, so sharing
+// them between RunServer and concurrent connections costs nothing on the
+// transfer path.
+type ServerStats struct {
+	start  time.Time
+	conns  atomic.Int64
+	active atomic.Int64
+	peak   atomic.Int64
+	bytes  atomic.Int64
+}
+
+// NewServerStats starts the uptime clock.
+func NewServerStats() *ServerStats {
+	return &ServerStats{start: time.Now()}
+}
+
+// opened counts a new connection. It is a no-op on nil stats, as are the
+// other methods.
+func (s *ServerStats) opened() {
+	if s == nil {
+		return
+	}
+	s.conns.Add(1)
+	n := s.active.Add(1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// closed counts the end of a connection that sent bytes.
+func (s *ServerStats) closed(bytes int64) {
+	if s == nil {
+		return
+	}
+	s.active.Add(-1)
+	s.bytes.Add(bytes)
+}
+
+// Report logs the totals since NewServerStats.
+func (s *ServerStats) Report() {
+	if s == nil {
+		return
+	}
+	log.Printf("Served %d connections in %s of uptime: %.2f MB sent, peak %d concurrent",
+		s.conns.Load(), time.Since(s.start).Round(time.Second), float64(s.bytes.Load())/1_000_000.0, s.peak.Load())
+}
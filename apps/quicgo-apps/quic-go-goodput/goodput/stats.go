@@ -0,0 +1,307 @@
+package goodput
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+type ClientStats struct {
+	bytesRecv     int
+	intervalRecv  int
+	startTime     time.Time
+	lastPrintTime time.Time
+
+	// firstByteTime is when the first response byte arrived; the gap from
+	// startTime (TTFB) serves as an RTT estimate.
+	firstByteTime time.Time
+	// discardFirstRTT excludes bytes that arrive within one TTFB after the
+	// first byte from the adjusted goodput, so slow start is not averaged in.
+	discardFirstRTT bool
+	firstRTTBytes   int
+
+	// quiet suppresses the per-second progress lines and PrintFinal, for
+	// stats that are reported only in aggregate.
+	quiet bool
+	// window, when set, adds a sliding average to each progress line.
+	window *slidingWindow
+	// rtt, when set, samples the connection's smoothed RTT for each
+	// progress line.
+	rtt     func() time.Duration
+	rttSeen RTTSummary
+	// loss, when set, reports the packets this endpoint has had declared
+	// lost and sent so far.
+	loss func() (lost, sent uint64)
+	// live, when set, is sent each progress interval as a Sample.
+	live *LivePublisher
+	// keepSamples records each progress interval in samples, even when
+	// quiet.
+	keepSamples bool
+	samples     []Sample
+	// summaryEvery, when positive, follows the progress line ending each
+	// multiple of that many seconds with a cumulative summary line.
+	summaryEvery int
+	lastSummary  int
+	// gapThreshold, when positive, records each stretch of at least that
+	// long without a read in gaps.
+	gapThreshold time.Duration
+	lastRead     time.Time
+	gaps         []Gap
+}
+
+// RTTSummary is the range of the smoothed RTT over the samples taken with
+// the progress lines of a transfer, in milliseconds.
+type RTTSummary struct {
+	Samples int     `json:"samples"`
+	MinMs   float64 `json:"min_ms"`
+	AvgMs   float64 `json:"avg_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// connRTT returns a sampler of conn's smoothed RTT.
+func connRTT(conn *quic.Conn) func() time.Duration {
+	return func() time.Duration { return conn.ConnectionStats().SmoothedRTT }
+}
+
+// connLoss returns a counter of the packets conn has lost and sent.
+func connLoss(conn *quic.Conn) func() (lost, sent uint64) {
+	return func() (uint64, uint64) {
+		cs := conn.ConnectionStats()
+		return cs.PacketsLost, cs.PacketsSent
+	}
+}
+
+// watch samples the RTT and loss of conn for the progress and summary lines.
+func (s *ClientStats) watch(conn *quic.Conn) {
+	s.rtt = connRTT(conn)
+	s.loss = connLoss(conn)
+}
+
+// printSummary prints the cumulative bytes and goodput at sec seconds, with
+// the current RTT and the loss so far on QUIC.
+func (s *ClientStats) printSummary(sec int) {
+	elapsed := time.Since(s.startTime).Seconds()
+	fmt.Printf("Summary at %d s: %.2f MB received, goodput %.2f Mbps",
+		sec, float64(s.bytesRecv)/1_000_000.0, float64(s.bytesRecv)/1_000_000.0*8.0/elapsed)
+	if s.rtt != nil {
+		fmt.Printf(", RTT %.2f ms", float64(s.rtt())/float64(time.Millisecond))
+	}
+	if s.loss != nil {
+		lost, sent := s.loss()
+		pct := 0.0
+		if sent > 0 {
+			pct = float64(lost) / float64(sent) * 100
+		}
+		fmt.Printf(", %d of %d packets sent lost (%.2f%%)", lost, sent, pct)
+	}
+	fmt.Println()
+	s.lastSummary = sec
+}
+
+// sampleRTT takes an RTT sample in milliseconds, if a sampler is set, and
+// prints it as the tail of a progress line unless quiet.
+func (s *ClientStats) sampleRTT() float64 {
+	if s.rtt == nil {
+		return 0
+	}
+	ms := float64(s.rtt()) / float64(time.Millisecond)
+	r := &s.rttSeen
+	if r.Samples == 0 || ms < r.MinMs {
+		r.MinMs = ms
+	}
+	r.MaxMs = max(r.MaxMs, ms)
+	r.AvgMs += (ms - r.AvgMs) / float64(r.Samples+1)
+	r.Samples++
+	if !s.quiet {
+		fmt.Printf("   RTT %.2f ms", ms)
+	}
+	return ms
+}
+
+// publish sends the interval that just ended to the live publisher, if any,
+// and keeps it if samples are kept.
+func (s *ClientStats) publish(start, end float64, mbps, rttMs float64) {
+	sample := Sample{Start: start, End: end, Bytes: s.intervalRecv, Goodput: mbps, RTT: rttMs}
+	if s.live != nil {
+		s.live.Publish(sample)
+	}
+	if s.keepSamples {
+		s.samples = append(s.samples, sample)
+	}
+}
+
+// Samples returns the kept progress intervals.
+func (s *ClientStats) Samples() []Sample {
+	return s.samples
+}
+
+// RTT returns the range of the sampled RTT, or nil if none was sampled.
+func (s *ClientStats) RTT() *RTTSummary {
+	if s.rttSeen.Samples == 0 {
+		return nil
+	}
+	r := s.rttSeen
+	return &r
+}
+
+// slidingWindow is a ring buffer of the most recent per-second goodput
+// samples.
+type slidingWindow struct {
+	samples []float64
+	next    int
+	filled  int
+}
+
+// newSlidingWindow returns a window over the last n samples, or nil if n is
+// not positive.
+func newSlidingWindow(n int) *slidingWindow {
+	if n <= 0 {
+		return nil
+	}
+	return &slidingWindow{samples: make([]float64, n)}
+}
+
+// add records a sample and returns the average over the window, which
+// covers fewer samples until it has filled up.
+func (w *slidingWindow) add(mbps float64) float64 {
+	w.samples[w.next] = mbps
+	w.next = (w.next + 1) % len(w.samples)
+	w.filled = min(w.filled+1, len(w.samples))
+	sum := 0.0
+	for _, v := range w.samples[:w.filled] {
+		sum += v
+	}
+	return sum / float64(w.filled)
+}
+
+func NewClientStats() *ClientStats {
+	now := time.Now()
+	return &ClientStats{
+		bytesRecv:     0,
+		intervalRecv:  0,
+		lastPrintTime: now,
+		startTime:     now,
+	}
+}
+
+func (s *ClientStats) Add(n int) {
+	now := time.Now()
+	if s.firstByteTime.IsZero() {
+		s.firstByteTime = now
+	}
+	if s.discardFirstRTT && now.Before(s.firstByteTime.Add(s.TTFB())) {
+		s.firstRTTBytes += n
+	}
+	s.noteRead(now)
+	s.bytesRecv += n
+	s.intervalRecv += n
+
+	elapsedSec := time.Since(s.startTime).Seconds()
+	if (!s.quiet || s.keepSamples) && elapsedSec-s.lastPrintTime.Sub(s.startTime).Seconds() >= 1.0 {
+		start := int(elapsedSec) - 1
+		end := int(elapsedSec)
+		mbps := float64(s.intervalRecv) / 1_000_000.0 * 8.0
+		if !s.quiet {
+			fmt.Printf("%d-%d sec   %.2f MB   %.2f Mbits/sec",
+				start,
+				end,
+				float64(s.intervalRecv)/1_000_000.0,
+				mbps)
+			if s.window != nil {
+				fmt.Printf("   (%ds avg %.2f Mbits/sec)", len(s.window.samples), s.window.add(mbps))
+			}
+		}
+		rttMs := s.sampleRTT()
+		if !s.quiet {
+			fmt.Println()
+		}
+		s.publish(float64(start), float64(end), mbps, rttMs)
+		s.intervalRecv = 0
+		s.lastPrintTime = time.Now()
+		if !s.quiet && s.summaryEvery > 0 && end/s.summaryEvery > s.lastSummary/s.summaryEvery {
+			s.printSummary(end)
+		}
+	}
+}
+
+func (s *ClientStats) PrintFinal() {
+	s.closeGaps()
+	if s.quiet && !s.keepSamples {
+		return
+	}
+	elapsed := time.Since(s.startTime).Seconds()
+
+	if s.intervalRecv > 0 {
+		startSec := elapsed - (elapsed - s.lastPrintTime.Sub(s.startTime).Seconds())
+		mbps := float64(s.intervalRecv) / 1_000_000.0 * 8.0 / (elapsed - startSec)
+		if !s.quiet {
+			fmt.Printf("%d-%.3f sec   %.2f MB   %.2f Mbits/sec",
+				int(startSec),
+				elapsed,
+				float64(s.intervalRecv)/1_000_000.0,
+				mbps)
+		}
+		rttMs := s.sampleRTT()
+		if !s.quiet {
+			fmt.Println()
+		}
+		s.publish(float64(int(startSec)), elapsed, mbps, rttMs)
+	}
+	if s.quiet {
+		return
+	}
+
+	fmt.Printf("Recv %.2f KB bytes in %.3f s, goodput: %.2f Mbps\n",
+		float64(s.bytesRecv)/1024.0,
+		elapsed,
+		float64(s.bytesRecv)/1_000_000.0*8.0/elapsed)
+
+	if r := s.RTT(); r != nil {
+		fmt.Printf("RTT over %d samples: min %.2f ms, avg %.2f ms, max %.2f ms\n", r.Samples, r.MinMs, r.AvgMs, r.MaxMs)
+	}
+	if s.gapThreshold > 0 {
+		printGaps(s.gaps, s.gapThreshold)
+	}
+
+	if s.discardFirstRTT {
+		adjusted, ok := s.AdjustedGoodput()
+		if !ok {
+			fmt.Printf("Adjusted goodput: n/a (transfer ended within the first RTT)\n")
+			return
+		}
+		fmt.Printf("Adjusted goodput (first RTT %.1f ms, %.2f KB excluded): %.2f Mbps\n",
+			float64(s.TTFB())/float64(time.Millisecond),
+			float64(s.firstRTTBytes)/1024.0,
+			adjusted)
+	}
+}
+
+// AdjustedGoodput returns the goodput in Mbps excluding the first RTT of the
+// transfer. It reports false if the transfer did not outlast that window.
+func (s *ClientStats) AdjustedGoodput() (float64, bool) {
+	cutoff := s.firstByteTime.Add(s.TTFB())
+	adjElapsed := time.Since(cutoff).Seconds()
+	if s.firstByteTime.IsZero() || adjElapsed <= 0 {
+		return 0, false
+	}
+	return float64(s.bytesRecv-s.firstRTTBytes) / 1_000_000.0 * 8.0 / adjElapsed, true
+}
+
+// TTFB returns the time from the request to the first response byte, or zero
+// if nothing has been received yet.
+func (s *ClientStats) TTFB() time.Duration {
+	if s.firstByteTime.IsZero() {
+		return 0
+	}
+	return s.firstByteTime.Sub(s.startTime)
+}
+
+// Goodput returns the average goodput so far in Mbps.
+func (s *ClientStats) Goodput() float64 {
+	elapsed := time.Since(s.startTime).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.bytesRecv) / 1_000_000.0 * 8.0 / elapsed
+}
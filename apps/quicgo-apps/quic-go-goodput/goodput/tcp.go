@@ -0,0 +1,225 @@
+package goodput
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/telemetry"
+)
+
+// Transports accepted by ClientConfig.Transport and the -transport flags.
+const (
+	TransportQUIC = "quic"
+	TransportTCP  = "tcp"
+)
+
+// runTCPClient performs the GETN transfer over a plain TCP connection,
+// optionally wrapped in TLS, as a baseline for the QUIC numbers.
+func runTCPClient(ctx context.Context, cfg ClientConfig, tlsConf *tls.Config) (*Result, error) {
+	if cfg.PrefillBytes > 0 || cfg.Parallel > 1 || cfg.Pipeline > 1 {
+		return nil, errors.New("prefill, parallel streams and pipelining require QUIC")
+	}
+
+	ctx, connSpan := telemetry.Tracer().Start(ctx, "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", cfg.Addr), attribute.String("transport", TransportTCP))
+	defer connSpan.End()
+
+	_, hsSpan := telemetry.Tracer().Start(ctx, "handshake")
+	dialCtx, cancel := connectContext(ctx, cfg.ConnectTimeout)
+	var d net.Dialer
+	conn, err := d.DialContext(dialCtx, "tcp", cfg.Addr)
+	if err == nil && cfg.TCPTLS {
+		tlsConn := tls.Client(conn, tlsConf)
+		if err = tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+		} else {
+			log.Printf("Negotiated ALPN: %s", tlsConn.ConnectionState().NegotiatedProtocol)
+			conn = tlsConn
+		}
+	}
+	err = connectError(dialCtx, err, cfg.Addr, cfg.ConnectTimeout)
+	cancel()
+	hsSpan.End()
+	if err != nil {
+		return nil, dialError(err)
+	}
+	defer conn.Close()
+
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
+	if _, err := fmt.Fprintf(conn, "GETN %d%s%s\r\n", cfg.RequestBytes, hintFields(cfg.Hints), authField(cfg.Token)); err != nil {
+		reqSpan.End()
+		return nil, fmt.Errorf("write GETN: %w", err)
+	}
+	reqSpan.SetAttributes(attribute.Int("request_bytes", cfg.RequestBytes))
+	reqSpan.End()
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	stats := NewClientStats()
+	cfg.salvage.track(stats)
+	stats.discardFirstRTT = cfg.DiscardFirstRTT
+	stats.window = newSlidingWindow(cfg.Window)
+	stats.summaryEvery = cfg.SummaryInterval
+	stats.quiet = cfg.Quiet
+	stats.keepSamples = cfg.KeepSamples
+	stats.gapThreshold = cfg.GapThreshold
+	stats.live = cfg.Live
+	buf := make([]byte, cfg.ReadBuffer)
+
+	var readErr error
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			stats.Add(n)
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = fmt.Errorf("read: %w", err)
+			}
+			break
+		}
+	}
+
+	stats.PrintFinal()
+	res := &Result{
+		Bytes:   stats.bytesRecv,
+		Elapsed: time.Since(stats.startTime),
+		Goodput: stats.Goodput(),
+		TTFB:    stats.TTFB(),
+
+		Termination: terminationOf(readErr),
+		Samples:     stats.Samples(),
+		Gaps:        stats.Gaps(),
+	}
+	if cfg.DiscardFirstRTT {
+		res.AdjustedGoodput, _ = stats.AdjustedGoodput()
+	}
+	xferSpan.SetAttributes(attribute.Int("bytes", res.Bytes), attribute.Float64("goodput_mbps", res.Goodput))
+	return res, readErr
+}
+
+// RunTCPServer serves GETN requests over TCP on ln, one connection at a
+// time, until ctx is cancelled. With cfg.TLSConfig set, connections are
+// wrapped in TLS; cfg.QUICConfig is ignored.
+func RunTCPServer(ctx context.Context, ln net.Listener, cfg ServerConfig) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	if cfg.TLSConfig != nil {
+		ln = tls.NewListener(ln, cfg.TLSConfig)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !cfg.Concurrent {
+			handleTCPConnection(conn, cfg)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleTCPConnection(conn, cfg)
+		}()
+	}
+}
+
+func handleTCPConnection(conn net.Conn, cfg ServerConfig) {
+	var sent int
+	cfg.Stats.opened()
+	defer func() { cfg.Stats.closed(int64(sent)) }()
+	defer conn.Close()
+
+	ctx, connSpan := telemetry.Tracer().Start(context.Background(), "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", conn.RemoteAddr().String()), attribute.String("transport", TransportTCP))
+	defer connSpan.End()
+
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	request := strings.TrimSpace(line)
+	reqSpan.SetAttributes(attribute.String("request", request))
+	reqSpan.End()
+	if err != nil && (err != io.EOF || request == "") {
+		log.Println("read request:", err)
+		return
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		log.Printf("Negotiated ALPN: %s", tlsConn.ConnectionState().NegotiatedProtocol)
+	}
+
+	var ok bool
+	if request, ok = authenticate(request, cfg.Token); !ok {
+		log.Printf("Rejected request without a valid token from %s", conn.RemoteAddr())
+		return
+	}
+	if request, err = cfg.applyHints(request, conn.RemoteAddr()); err != nil {
+		log.Println(err)
+		return
+	}
+	if !strings.HasPrefix(request, "GETN") {
+		return
+	}
+	if cfg.Pipe != nil {
+		if !cfg.think(ctx) {
+			return
+		}
+		// the deferred close is the FIN
+		n, err := streamPipe(ctx, conn, func() error { return nil }, cfg)
+		sent = int(n)
+		if err != nil {
+			log.Println("Pipe error:", err)
+		}
+		return
+	}
+	numBytes, err := parseRequestBytes(strings.TrimPrefix(request, "GETN"), cfg.requestCap())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !cfg.think(ctx) {
+		return
+	}
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
+	start := time.Now()
+	payload, err := cfg.writeBurst(conn, newPayload(numBytes, 0, cfg), start)
+	switch {
+	case err != nil:
+	case cfg.Control != nil:
+		err = cfg.Control.write(ctx, conn, payload)
+	case cfg.RateTrace != nil:
+		err = writePaced(conn, payload, cfg.RateTrace)
+	default:
+		_, err = conn.Write(payload)
+	}
+	if err != nil {
+		log.Println("Write error:", err)
+		return
+	}
+	sent = numBytes
+	elapsed := time.Since(start).Seconds()
+	mbps := float64(numBytes) / 1_000_000.0 * 8.0 / elapsed
+
+	xferSpan.SetAttributes(attribute.Int("bytes", numBytes), attribute.Float64("goodput_mbps", mbps))
+	log.Printf("Send %.2f KB in %.3f s, goodput: %.2f Mbps\n", float64(numBytes)/1024.0, elapsed, mbps)
+}
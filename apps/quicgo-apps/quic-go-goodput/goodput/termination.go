@@ -0,0 +1,91 @@
+package goodput
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Termination kinds, from the error that ended a transfer's reads.
+const (
+	TermFIN            = "fin"
+	TermAppClose       = "app_close"
+	TermStreamReset    = "stream_reset"
+	TermIdleTimeout    = "idle_timeout"
+	TermClientTimeout  = "client_timeout"
+	TermTransportError = "transport_error"
+	TermStatelessReset = "stateless_reset"
+	TermError          = "error"
+	TermPanic          = "panic"
+)
+
+// Termination records how a transfer ended.
+type Termination struct {
+	Kind string `json:"kind"`
+	// Code is the application or transport error code, for the kinds that
+	// carry one.
+	Code uint64 `json:"code,omitempty"`
+	// Remote is set when the peer, not the client, closed or reset.
+	Remote bool `json:"remote,omitempty"`
+}
+
+// terminationOf classifies the error that ended a transfer; nil and io.EOF
+// are a clean FIN.
+func terminationOf(err error) Termination {
+	var appErr *quic.ApplicationError
+	var streamErr *quic.StreamError
+	var idleErr *quic.IdleTimeoutError
+	var transportErr *quic.TransportError
+	var resetErr *quic.StatelessResetError
+	var netErr net.Error
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		return Termination{Kind: TermFIN}
+	case errors.As(err, &streamErr):
+		return Termination{Kind: TermStreamReset, Code: uint64(streamErr.ErrorCode), Remote: streamErr.Remote}
+	case errors.As(err, &appErr):
+		return Termination{Kind: TermAppClose, Code: uint64(appErr.ErrorCode), Remote: appErr.Remote}
+	case errors.As(err, &idleErr):
+		return Termination{Kind: TermIdleTimeout}
+	case errors.As(err, &resetErr):
+		return Termination{Kind: TermStatelessReset, Remote: true}
+	case errors.As(err, &transportErr):
+		return Termination{Kind: TermTransportError, Code: uint64(transportErr.ErrorCode), Remote: transportErr.Remote}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return Termination{Kind: TermClientTimeout}
+	default:
+		return Termination{Kind: TermError}
+	}
+}
+
+func (t Termination) String() string {
+	by := "client"
+	if t.Remote {
+		by = "server"
+	}
+	switch t.Kind {
+	case TermFIN:
+		return "clean FIN"
+	case TermAppClose:
+		return fmt.Sprintf("%s closed the connection with application error 0x%x", by, t.Code)
+	case TermStreamReset:
+		return fmt.Sprintf("%s reset the stream with error code 0x%x", by, t.Code)
+	case TermIdleTimeout:
+		return "connection idle timeout"
+	case TermClientTimeout:
+		return "client-side timeout"
+	case TermTransportError:
+		return fmt.Sprintf("%s closed the connection with transport error 0x%x", by, t.Code)
+	case TermStatelessReset:
+		return "stateless reset by the server"
+	case TermPanic:
+		return "panic"
+	default:
+		return "unclassified error"
+	}
+}
@@ -0,0 +1,50 @@
+package goodput
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"quic-go-common/handshake"
+)
+
+// errNoTrust is returned when a client has neither a CA to verify the server
+// against nor an explicit opt-out from verification.
+var errNoTrust = errors.New("no RootCAs to verify the server against and Insecure not set")
+
+// LoadCertPool reads PEM-encoded CA certificates from path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// LoadTLSConfig returns a server TLS config using the certificate and key in
+// the given PEM files, offering DefaultALPN.
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{DefaultALPN},
+	}, nil
+}
+
+// dialError wraps an error from dialing the server, leading with a
+// diagnosis if the handshake failed.
+func dialError(err error) error {
+	if diag := handshake.Diagnose(err); diag != "" {
+		return fmt.Errorf("handshake failed: %s (%w)", diag, err)
+	}
+	return fmt.Errorf("dial: %w", err)
+}
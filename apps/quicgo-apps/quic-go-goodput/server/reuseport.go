@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+// errReusePortUnsupported is returned by listenReusePort on platforms where
+// the server does not set SO_REUSEPORT.
+var errReusePortUnsupported = errors.New("SO_REUSEPORT is only supported on Linux")
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+func listenReusePort(*net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errReusePortUnsupported
+}
@@ -2,137 +2,428 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
+	"errors"
 	"flag"
 	"log"
-	"math/big"
 	"net"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/quic-go/quic-go"
+
+	"quic-go-common/preflight"
+	"quic-go-common/qtrace"
+	"quic-go-common/scenario"
+	"quic-go-common/telemetry"
+	"quic-go-common/tuning"
+	"quic-go-goodput/goodput"
 )
 
 const MAX_DATAGRAM_SIZE = 1350
 
 func main() {
 	bindAddr := flag.String("p", "127.0.0.1:8080", "bind IP and port")
+	alpn := flag.String("alpn", goodput.DefaultALPN, "comma-separated ALPN protocols to offer")
+	transport := flag.String("transport", goodput.TransportQUIC, "transport to serve GETN over: quic, or tcp for a TCP baseline")
+	tcpTLS := flag.Bool("tcp-tls", false, "wrap the tcp transport in TLS")
+	certFile := flag.String("cert", "", "PEM certificate to serve; a self-signed one is generated when empty")
+	keyFile := flag.String("key", "", "PEM private key for -cert")
+	entropy := flag.Float64("entropy", 0, "payload entropy from 0 (zeros) to 1 (random); gzip compresses it by roughly 1/entropy")
+	payloadPattern := flag.String("payload-pattern", goodput.PatternEntropy, "payload to send: entropy for the -entropy payload, or signature for 16-byte stamps of each cell's offset, to read payload boundaries, reordering and duplication off a decrypted packet capture")
+	seed := flag.Uint64("seed", 0, "seed all random choices of the run, so the same seed sends the same bytes; the derived seeds are logged (0 for unseeded)")
+	file := flag.String("file", "", "file to serve byte ranges of to GETRANGE <offset> <length> requests")
+	payloadFromStdin := flag.Bool("payload-from-stdin", false, "answer the first GETN with the server's stdin, streamed unpaced until EOF on stdin and finished with a FIN, whatever size it asks for, to pipe any content over the transport and measure it; later GETN requests are rejected, while -file ranges are still served")
+	preload := flag.Bool("preload", false, "hold one dataset in memory, loaded at startup, and answer every GETN, GETP, GETL and GETRESUME with a read-only slice of it rather than a fresh buffer, so every client gets identical bytes; requests for more than it holds are rejected")
+	preloadSize := flag.Int("preload-size", 0, "size in bytes of the -preload dataset, generated from -entropy, -payload-pattern and -seed; the contents of -file are loaded instead when it is given")
+	controlAddr := flag.String("control-addr", "", "listen on this TCP address for control connections that set the pacing rate of GETN and GETP responses and stop or start them mid-run (see goodput.Control), apart from the data path")
+	rateTraceFile := flag.String("rate-trace", "", "CSV of duration_s,rate_mbps slices to pace GETN and GETP responses to, logging target against achieved rate per slice")
+	handshakeOnly := flag.Bool("handshake-only", false, "close every connection with application error 0xcc (goodput.HandshakeOnlyErrorCode) as soon as its handshake completes, serving no requests, and log how long each handshake took from its first packet, for the client's handshake-bench")
+	allow0RTT := flag.Bool("allow-0rtt", false, "accept 0-RTT connection attempts from clients resuming a session")
+	reusePort := flag.Bool("reuseport", false, "bind the UDP socket with SO_REUSEPORT so several server processes can share the port, with the kernel spreading connections over them (Linux only)")
+	shards := flag.Int("shards", 1, "bind this many UDP sockets to the address with SO_REUSEPORT, each with its own QUIC listener and accept loop, so the kernel spreads connections over them and one process can use several CPUs, and log each shard's connection count at shutdown (Linux only)")
+	token := flag.String("token", "", "reject requests that do not carry auth=<token>, closing the connection before doing any work (off when empty)")
+	concurrent := flag.Bool("concurrent", false, "serve connections in parallel instead of one at a time, so that several clients' flows compete")
+	acceptWorkers := flag.Int("accept-workers", 0, "accept connections from this many goroutines into a queue while one is served, logging each connection's accept-to-handle latency (0 accepts inline)")
+	resumeTTL := flag.Duration("resume-ttl", 5*time.Minute, "remember resumable (GETRESUME) transfers for this long after their last activity (0 disables them)")
+	maxBytes := flag.Int("max-bytes", goodput.DefaultMaxBytes, "reject requests for more than this many bytes; each response is generated in memory, so 0, which disables the cap, lets a single request take as much memory as it asks for")
+	acceptHints := flag.Bool("accept-hints", false, "apply the hints clients send with -hints to their own connection, so one long-lived listener serves a sequence of differently configured experiments: think, entropy, seed, pattern and max (which can only lower -max-bytes) vary per connection, while -cc, -initial-rtt, -min-cwnd, -max-cwnd and the receive windows are fixed per listener")
+	thinkTime := flag.Duration("think-time", 0, "hold every response for this long (e.g. 50ms) after parsing its request, before sending the first byte, to model server processing time; the client's TTFB includes it (0 disables)")
+	initialBurst := flag.Int("initial-burst", 0, "send the first this many bytes of every response as fast as possible before -rate-trace or -control-addr pacing takes over, like a video prebuffer, and log when the burst completed (0 disables)")
+	finTimeout := flag.Duration("fin-timeout", 2*time.Second, "after a GETN or GETRANGE response, wait up to this long for the client to close the connection so the last bytes are delivered (0 closes right away)")
+	wireStats := flag.Bool("wire-stats", false, "log each connection's application goodput next to its estimated on-the-wire throughput and overhead")
+	mtu := flag.Int("mtu", 0, "cap the datagrams the server sends at this IP MTU in bytes, headers included, dropping the larger ones as a link would, so path MTU discovery stops below it (0 for no cap)")
+	captureSummary := flag.Bool("capture-summary", false, "log a packet-capture style summary at shutdown, over all connections: QUIC packets sent and received by type, retransmissions, UDP bytes and the average packet size")
+	fcStats := flag.Bool("fc-stats", false, "log how long each connection was blocked on connection and stream flow control")
+	transportParams := flag.Bool("transport-params", false, "log each connection's negotiated QUIC version and the transport parameters sent and received")
+	stateDump := flag.String("state-dump", "", "write each connection's final state (negotiated parameters, RTT, congestion window, bytes per packet-number space, stream counts and close reason) as a line of JSON to this file when it closes")
+	spaceStats := flag.Bool("space-stats", false, "log the payload bytes each connection sent and received in 0-RTT and in 1-RTT packets")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector (host:port or URL) to export trace spans to; tracing is off when empty")
+	cc := flag.String("cc", tuning.DefaultCongestionControl, "congestion controller; the linked quic-go only provides cubic and has no hook for custom:<name> controllers")
+	initialRTT := flag.Duration("initial-rtt", 0, "initial RTT estimate for the sender (if supported by quic-go)")
+	minCwnd := flag.Int("min-cwnd", 0, "minimum congestion window in packets (if supported by quic-go)")
+	maxCwnd := flag.Int("max-cwnd", 0, "maximum congestion window in packets (if supported by quic-go)")
+	initialStreamWindow := flag.Uint64("initial-stream-window", 0, "initial per-stream receive window in bytes (0 keeps the quic-go default)")
+	maxStreamWindow := flag.Uint64("max-stream-window", 0, "maximum per-stream receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	initialConnWindow := flag.Uint64("initial-conn-window", 0, "initial connection receive window in bytes (0 keeps the quic-go default)")
+	maxConnWindow := flag.Uint64("max-conn-window", 0, "maximum connection receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	scenarioFile := flag.String(scenario.FlagName, "", "JSON file of flag values to run with, keyed by flag name, e.g. {\"cc\": \"cubic\", \"initial-rtt\": \"50ms\"}; the flags given on the command line take precedence")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile at the end of the run to this file")
+	blockProfile := flag.String("blockprofile", "", "write a profile of every goroutine blocking event to this file (slows the run)")
+	syslogAddr := flag.String("syslog", "", "also send the logs to this syslog server (host:port for UDP, or tcp://host:port), dropping lines rather than waiting on it (off when empty)")
 	flag.Parse()
+	if err := scenario.Load(flag.CommandLine, *scenarioFile); err != nil {
+		log.Fatalf("invalid -scenario: %v", err)
+	}
 	disableGSO()
 
-	udpAddr, err := net.ResolveUDPAddr("udp", *bindAddr)
-	if err != nil {
-		log.Fatalf("Failed to resolve UDP address: %v", err)
+	if *syslogAddr != "" {
+		stopSyslog, err := telemetry.MirrorLogs(*syslogAddr, "quic-go-goodput-server")
+		if err != nil {
+			log.Fatalf("invalid -syslog %q: %v", *syslogAddr, err)
+		}
+		defer stopSyslog()
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		log.Fatalf("Listen UDP error: %v", err)
+	if err := tuning.CheckCongestionControl(*cc); err != nil {
+		log.Fatalf("invalid -cc %q: %v", *cc, err)
 	}
 
-	tlsConf, err := generateTLSConfig()
-	if err != nil {
-		log.Fatalf("TLS config error: %v", err)
+	if *entropy < 0 || *entropy > 1 {
+		log.Fatalf("invalid -entropy %v: must be within [0, 1]", *entropy)
+	}
+	switch *payloadPattern {
+	case goodput.PatternEntropy:
+	case goodput.PatternSignature:
+		if *entropy > 0 {
+			log.Fatal("-payload-pattern signature cannot be combined with -entropy")
+		}
+	default:
+		log.Fatalf("invalid -payload-pattern %q: must be %s or %s", *payloadPattern, goodput.PatternEntropy, goodput.PatternSignature)
+	}
+	var payloadSeed uint64
+	if *seed != 0 {
+		payloadSeed = goodput.DeriveSeed(*seed, "payload")
+		log.Printf("Seeds from -seed %d: payload %d", *seed, payloadSeed)
+	}
+	if *acceptWorkers < 0 {
+		log.Fatalf("invalid -accept-workers %d: must not be negative", *acceptWorkers)
+	}
+	if *thinkTime < 0 {
+		log.Fatalf("invalid -think-time %v: must not be negative", *thinkTime)
+	}
+	if *thinkTime > 0 {
+		log.Printf("Think time: %v before each response", *thinkTime)
+	}
+	if *initialBurst < 0 {
+		log.Fatalf("invalid -initial-burst %d: must not be negative", *initialBurst)
+	}
+	if *initialBurst > 0 {
+		log.Printf("Initial burst: %d bytes unpaced at the start of each response", *initialBurst)
+	}
+	if *acceptHints {
+		log.Print("Accepting per-connection hints: think, entropy, seed, pattern and max")
+	}
+	if *maxBytes < 0 {
+		log.Fatalf("invalid -max-bytes %d: must not be negative", *maxBytes)
 	}
 
-	listener, err := quic.Listen(conn, tlsConf, &quic.Config{})
-	if err != nil {
-		log.Fatalf("QUIC listen error: %v", err)
+	if *transport != goodput.TransportQUIC && *transport != goodput.TransportTCP {
+		log.Fatalf("unknown transport %q", *transport)
 	}
 
-	log.Printf("Server running on %s", *bindAddr)
+	if *reusePort && *transport != goodput.TransportQUIC {
+		log.Fatal("-reuseport only applies to the quic transport")
+	}
+	if *shards < 1 {
+		log.Fatalf("invalid -shards %d: must be at least 1", *shards)
+	}
+	if *shards > 1 && *transport != goodput.TransportQUIC {
+		log.Fatal("-shards only applies to the quic transport")
+	}
+	if *mtu != 0 && *mtu < qtrace.MinMTU {
+		log.Fatalf("invalid -mtu %d: must be 0 or at least %d", *mtu, qtrace.MinMTU)
+	}
+	if *mtu != 0 && *transport != goodput.TransportQUIC {
+		log.Fatal("-mtu only applies to the quic transport")
+	}
+	if *captureSummary && *transport != goodput.TransportQUIC {
+		log.Fatal("-capture-summary only applies to the quic transport")
+	}
+	if *allow0RTT && *transport != goodput.TransportQUIC {
+		log.Fatal("-allow-0rtt only applies to the quic transport")
+	}
+	if *handshakeOnly && *transport != goodput.TransportQUIC {
+		log.Fatal("-handshake-only only applies to the quic transport")
+	}
 
-	for {
-		conn, err := listener.Accept(context.Background())
-		if err != nil {
-			log.Fatal(err)
+	var served *os.File
+	if *file != "" {
+		if *transport != goodput.TransportQUIC {
+			log.Fatal("-file is only served over the quic transport")
+		}
+		var err error
+		if served, err = os.Open(*file); err != nil {
+			log.Fatalf("File error: %v", err)
 		}
-		handleConnection(conn)
+		defer served.Close()
 	}
-}
 
-func handleConnection(conn *quic.Conn) {
-	defer conn.CloseWithError(0, "")
+	var pipe *goodput.Pipe
+	if *payloadFromStdin {
+		if *preload || *rateTraceFile != "" || *controlAddr != "" || *initialBurst > 0 {
+			log.Fatal("-payload-from-stdin streams stdin unpaced, so cannot be combined with -preload, -rate-trace, -control-addr or -initial-burst")
+		}
+		pipe = goodput.NewPipe(os.Stdin)
+		log.Printf("Streaming stdin to the first GETN until EOF")
+	}
 
-	stream, err := conn.AcceptStream(context.Background())
-	if err != nil {
-		log.Println("Accept stream error:", err)
-		return
+	var dataset []byte
+	switch {
+	case *preload && *file != "":
+		if *preloadSize != 0 {
+			log.Fatal("-preload-size cannot be combined with -file, whose contents are the dataset")
+		}
+		var err error
+		if dataset, err = os.ReadFile(*file); err != nil {
+			log.Fatalf("File error: %v", err)
+		}
+		if len(dataset) == 0 {
+			log.Fatal("-preload needs a non-empty -file")
+		}
+		log.Printf("Preloaded %s as the dataset: %d bytes (%.2f MiB) held in memory", *file, len(dataset), float64(len(dataset))/(1<<20))
+	case *preload:
+		if *preloadSize <= 0 {
+			log.Fatalf("invalid -preload-size %d: must be positive without -file", *preloadSize)
+		}
+		dataset = goodput.NewDataset(*preloadSize, goodput.ServerConfig{Entropy: *entropy, PayloadSeed: payloadSeed, PayloadPattern: *payloadPattern})
+		log.Printf("Generated the dataset: %d bytes (%.2f MiB) held in memory", len(dataset), float64(len(dataset))/(1<<20))
+	case *preloadSize != 0:
+		log.Fatal("-preload-size needs -preload")
 	}
 
-	buf := make([]byte, 4096)
-	n, err := stream.Read(buf)
-	if err != nil {
-		log.Println("Read error:", err)
-		return
+	if *controlAddr != "" && *rateTraceFile != "" {
+		log.Fatal("-control-addr cannot be combined with -rate-trace")
+	}
+	var rateTrace goodput.RateTrace
+	if *rateTraceFile != "" {
+		var err error
+		if rateTrace, err = goodput.LoadRateTrace(*rateTraceFile); err != nil {
+			log.Fatalf("Rate trace error: %v", err)
+		}
 	}
 
-	request := strings.TrimSpace(string(buf[:n]))
-	if strings.HasPrefix(request, "GETN") {
-		numStr := strings.TrimSpace(strings.TrimPrefix(request, "GETN"))
-		numBytes, err := strconv.Atoi(numStr)
-		if err != nil || numBytes <= 0 {
-			stream.CancelWrite(42)
-			return
+	// preflight: bind and load the TLS material before anything else, so a
+	// misconfiguration fails here rather than mid-run
+	var conn *net.UDPConn
+	var ln net.Listener
+	var err error
+	if *transport == goodput.TransportTCP {
+		ln, err = net.Listen("tcp", *bindAddr)
+	} else {
+		var udpAddr *net.UDPAddr
+		if udpAddr, err = net.ResolveUDPAddr("udp", *bindAddr); err != nil {
+			log.Fatalf("Failed to resolve UDP address: %v", err)
 		}
+		if *reusePort || *shards > 1 {
+			conn, err = listenReusePort(udpAddr)
+			if errors.Is(err, errReusePortUnsupported) && *shards == 1 {
+				log.Printf("Warning: %v, binding without it", err)
+				conn, err = net.ListenUDP("udp", udpAddr)
+			}
+		} else {
+			conn, err = net.ListenUDP("udp", udpAddr)
+		}
+	}
+	if err != nil {
+		log.Fatal(preflight.ExplainBindError(*bindAddr, err))
+	}
+	// the other shards bind the port the first one got, which -p :0 picks
+	shardConns := []net.PacketConn{conn}
+	for len(shardConns) < *shards {
+		c, err := listenReusePort(conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			log.Fatal(preflight.ExplainBindError(*bindAddr, err))
+		}
+		shardConns = append(shardConns, c)
+	}
+	var control *goodput.Control
+	var controlLn net.Listener
+	if *controlAddr != "" {
+		if controlLn, err = net.Listen("tcp", *controlAddr); err != nil {
+			log.Fatal(preflight.ExplainBindError(*controlAddr, err))
+		}
+		control = goodput.NewControl()
+	}
 
-		packetBuf := make([]byte, numBytes)
+	tlsConf, err := goodput.GenerateTLSConfig()
+	if *certFile != "" {
+		tlsConf, err = goodput.LoadTLSConfig(*certFile, *keyFile)
+	}
+	if err == nil {
+		err = preflight.CheckCertificates(tlsConf)
+	}
+	if err != nil {
+		log.Fatalf("TLS config error: %v", err)
+	}
+	tlsConf.NextProtos = goodput.ParseALPN(*alpn)
 
-		start := time.Now()
-		if err := writeFull(stream, packetBuf); err != nil {
-			log.Println("Write error:", err)
-			return
+	// spans are batched and exported in the background while the server runs
+	shutdownTracing, err := telemetry.Setup(context.Background(), "quic-go-goodput-server", *otlpEndpoint)
+	if err != nil {
+		log.Fatalf("Tracing setup error: %v", err)
+	}
+	// flushes, within a bounded time, the spans of the last sessions still
+	// queued in the batch exporter once the server has drained
+	defer func() {
+		if err := shutdownTracing(); err != nil {
+			log.Printf("Tracing shutdown error: %v", err)
 		}
-		if err := stream.Close(); err != nil {
-			log.Println("Stream close error:", err)
-			return
+	}()
+	stopProfiles, err := telemetry.Profiles{CPU: *cpuProfile, Mem: *memProfile, Block: *blockProfile}.Start()
+	if err != nil {
+		log.Fatalf("Profiling error: %v", err)
+	}
+	defer func() {
+		if err := stopProfiles(); err != nil {
+			log.Printf("Profiling error: %v", err)
 		}
-		elapsed := time.Since(start).Seconds()
+	}()
 
-		mb := float64(numBytes) / 1_000_000.0
-		mbps := mb * 8.0 / elapsed
-		KB := float64(numBytes) / 1024.0
+	// the first interrupt stops accepting connections and lets the ones in
+	// progress finish; a second one exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	draining := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("Shutting down after the connections in progress; interrupt again to exit now")
+		close(draining)
+	}()
+	if control != nil {
+		log.Printf("Control listener on %s", controlLn.Addr())
+		go func() {
+			if err := control.Serve(ctx, controlLn); err != nil {
+				log.Printf("Control listener error: %v", err)
+			}
+		}()
+	}
+	stats := goodput.NewServerStats()
+	report := func() {
+		<-draining
+		stats.Report()
+	}
 
-		log.Printf("Send %.2f KB in %.3f s, goodput: %.2f Mbps\n", KB, elapsed, mbps)
+	if *transport == goodput.TransportTCP {
+		if !*tcpTLS {
+			tlsConf = nil
+		}
+		log.Printf("Server running on %s (tcp)", *bindAddr)
+		if err := goodput.RunTCPServer(ctx, ln, goodput.ServerConfig{TLSConfig: tlsConf, Stats: stats, Entropy: *entropy, PayloadSeed: payloadSeed, PayloadPattern: *payloadPattern, Dataset: dataset, Pipe: pipe, MaxBytes: *maxBytes, ThinkTime: *thinkTime, InitialBurst: *initialBurst, AcceptHints: *acceptHints, Token: *token, RateTrace: rateTrace, Control: control, Concurrent: *concurrent}); err != nil {
+			log.Fatal(err)
+		}
+		report()
 		return
 	}
-}
 
-func generateTLSConfig() (*tls.Config, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
+	quicConf := &quic.Config{Allow0RTT: *allow0RTT}
+	tuning.CongestionTuning{
+		InitialRTT:     *initialRTT,
+		MinCwndPackets: *minCwnd,
+		MaxCwndPackets: *maxCwnd,
+	}.Apply(quicConf)
+	windows := tuning.FlowControlWindows{
+		InitialStream: *initialStreamWindow,
+		MaxStream:     *maxStreamWindow,
+		InitialConn:   *initialConnWindow,
+		MaxConn:       *maxConnWindow,
 	}
-
-	tmpl := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().Add(24 * time.Hour),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		Subject:      pkix.Name{CommonName: "localhost"},
+	if err := windows.Apply(quicConf); err != nil {
+		log.Fatalf("invalid receive windows: %v", err)
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
-	if err != nil {
-		return nil, err
+	var sender *qtrace.SenderCounter
+	var senderTracer, paramsTracer, dumpTracer, captureTracer qtrace.Tracer
+	if *wireStats || *fcStats || *spaceStats {
+		sender = qtrace.NewSenderCounter()
+		senderTracer = sender.Tracer
 	}
+	var params *qtrace.ParamsRecorder
+	if *transportParams {
+		params = qtrace.NewParamsRecorder()
+		paramsTracer = params.Tracer
+	}
+	var dump *qtrace.StateDump
+	if *stateDump != "" {
+		var err error
+		if dump, err = qtrace.NewStateDump(*stateDump); err != nil {
+			log.Fatal("State dump error:", err)
+		}
+		defer dump.Close()
+		dumpTracer = dump.Tracer
+	}
+	var capture *qtrace.Capture
+	if *captureSummary {
+		capture = qtrace.NewCapture()
+		captureTracer = capture.Tracer
+	}
+	if *mtu > 0 {
+		for i, c := range shardConns {
+			capped, err := qtrace.CapMTU(quicConf, c, *mtu)
+			if err != nil {
+				log.Fatalf("invalid -mtu %d: %v", *mtu, err)
+			}
+			shardConns[i] = capped
+		}
+	}
+	mtus := qtrace.NewMTURecorder(quicConf, *mtu)
+	quicConf.Tracer = qtrace.Multi(senderTracer, paramsTracer, dumpTracer, captureTracer, mtus.Tracer)
 
-	cert := tls.Certificate{
-		Certificate: [][]byte{certDER},
-		PrivateKey:  key,
+	if *shards > 1 {
+		log.Printf("Server running on %s over %d shards", *bindAddr, *shards)
+	} else {
+		log.Printf("Server running on %s", *bindAddr)
 	}
 
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		NextProtos:   []string{"http/0.9"},
-	}, nil
+	cfg := goodput.ServerConfig{
+		TLSConfig:         tlsConf,
+		QUICConfig:        quicConf,
+		Entropy:           *entropy,
+		PayloadPattern:    *payloadPattern,
+		PayloadSeed:       payloadSeed,
+		Dataset:           dataset,
+		MaxBytes:          *maxBytes,
+		RateTrace:         rateTrace,
+		Control:           control,
+		Token:             *token,
+		Pipe:              pipe,
+		File:              served,
+		Concurrent:        *concurrent,
+		AcceptWorkers:     *acceptWorkers,
+		ResumeTTL:         *resumeTTL,
+		FinTimeout:        *finTimeout,
+		Stats:             stats,
+		Sender:            sender,
+		ReportWire:        *wireStats,
+		ReportFlowControl: *fcStats,
+		ReportSpaces:      *spaceStats,
+		Params:            params,
+		StateDump:         dump,
+		ThinkTime:         *thinkTime,
+		InitialBurst:      *initialBurst,
+		AcceptHints:       *acceptHints,
+		HandshakeOnly:     *handshakeOnly,
+	}
+	if err := goodput.RunShards(ctx, shardConns, cfg); err != nil {
+		log.Fatal(err)
+	}
+	report()
+	mtus.Report()
+	if capture != nil {
+		capture.Report()
+	}
 }
 
 // disable GSO; in Mininet’s virtual links, GSO behaves unexpectedly and
@@ -143,17 +434,3 @@ func disableGSO() {
 		log.Fatalf("failed to disable GSO: %v", err)
 	}
 }
-
-func writeFull(stream *quic.Stream, data []byte) error {
-	remaining := data
-	for len(remaining) > 0 {
-		n, err := stream.Write(remaining)
-		if n > 0 {
-			remaining = remaining[n:]
-		}
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
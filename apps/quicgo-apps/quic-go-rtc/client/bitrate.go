@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// bitrateStep is how often the bitrate series is sampled, at most; a
+// shorter window is sampled once per window.
+const bitrateStep = 100 * time.Millisecond
+
+// bitratePoint is the received bitrate over the window ending at T, in
+// seconds since baseline as in the "fin time" output.
+type bitratePoint struct {
+	T    float64 `json:"t_s"`
+	Kbps float64 `json:"kbps"`
+}
+
+// frameSizeSummary is the distribution of the sizes of the delivered
+// frames, headers included. The frame header carries only the sequence
+// number, so each size is what its stream carried.
+type frameSizeSummary struct {
+	Frames int     `json:"frames"`
+	Min    int     `json:"min_bytes"`
+	Avg    float64 `json:"avg_bytes"`
+	P50    int     `json:"p50_bytes"`
+	P95    int     `json:"p95_bytes"`
+	Max    int     `json:"max_bytes"`
+}
+
+// bitrateProfile is the delivered bitrate profile of a run: the frame size
+// distribution and the received bitrate over a sliding window.
+type bitrateProfile struct {
+	WindowMs float64          `json:"window_ms"`
+	Sizes    frameSizeSummary `json:"frame_sizes"`
+	MinKbps  float64          `json:"min_kbps"`
+	AvgKbps  float64          `json:"avg_kbps"`
+	MaxKbps  float64          `json:"max_kbps"`
+	Series   []bitratePoint   `json:"series,omitempty"`
+}
+
+// profile computes the bitrate profile of the delivered frames, counting
+// each frame's bytes at its FIN. The series runs from the first FIN plus one
+// window, so that every point covers a full window, to the last FIN.
+func (d *deliveryStats) profile(window time.Duration, baseline time.Time) bitrateProfile {
+	d.mu.Lock()
+	frames := slices.Clone(d.frames)
+	d.mu.Unlock()
+	p := bitrateProfile{WindowMs: toMs(window)}
+	if len(frames) == 0 {
+		return p
+	}
+	slices.SortFunc(frames, func(a, b frameDelivery) int { return a.end.Compare(b.end) })
+
+	sizes := make([]int, len(frames))
+	total := 0
+	for i, f := range frames {
+		sizes[i] = f.bytes
+		total += f.bytes
+	}
+	slices.Sort(sizes)
+	p.Sizes = frameSizeSummary{
+		Frames: len(sizes),
+		Min:    sizes[0],
+		Avg:    float64(total) / float64(len(sizes)),
+		P50:    sizes[int(0.50*float64(len(sizes)-1)+0.5)],
+		P95:    sizes[int(0.95*float64(len(sizes)-1)+0.5)],
+		Max:    sizes[len(sizes)-1],
+	}
+
+	step := min(bitrateStep, window)
+	first, last := frames[0].end, frames[len(frames)-1].end
+	var sum float64
+	// lo and hi bound the frames whose FIN falls in (t-window, t]
+	lo, hi, bytes := 0, 0, 0
+	for t := first.Add(window); !t.After(last.Add(step - 1)); t = t.Add(step) {
+		for hi < len(frames) && !frames[hi].end.After(t) {
+			bytes += frames[hi].bytes
+			hi++
+		}
+		for lo < hi && !frames[lo].end.After(t.Add(-window)) {
+			bytes -= frames[lo].bytes
+			lo++
+		}
+		kbps := float64(bytes) * 8 / 1e3 / window.Seconds()
+		if len(p.Series) == 0 || kbps < p.MinKbps {
+			p.MinKbps = kbps
+		}
+		p.MaxKbps = max(p.MaxKbps, kbps)
+		sum += kbps
+		p.Series = append(p.Series, bitratePoint{T: t.Sub(baseline).Seconds(), Kbps: kbps})
+	}
+	if len(p.Series) > 0 {
+		p.AvgKbps = sum / float64(len(p.Series))
+	}
+	return p
+}
+
+// report logs the frame size distribution and the range of the bitrate.
+func (p bitrateProfile) report() {
+	if p.Sizes.Frames == 0 {
+		return
+	}
+	s := p.Sizes
+	log.Printf("Frame sizes over %d frames: min %d B, avg %.0f B, p50 %d B, p95 %d B, max %d B",
+		s.Frames, s.Min, s.Avg, s.P50, s.P95, s.Max)
+	if len(p.Series) == 0 {
+		log.Printf("Received bitrate: n/a (the run is shorter than the %v window)", time.Duration(p.WindowMs*float64(time.Millisecond)))
+		return
+	}
+	log.Printf("Received bitrate over a %v window: min %.1f kbps, avg %.1f kbps, max %.1f kbps (%d points)",
+		time.Duration(p.WindowMs*float64(time.Millisecond)), p.MinKbps, p.AvgKbps, p.MaxKbps, len(p.Series))
+}
+
+// write writes the profile to path: the series as CSV, or the whole
+// profile as JSON for a path ending in .json.
+func (p bitrateProfile) write(path string) error {
+	if filepath.Ext(path) == ".json" {
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "t_s,kbps")
+	for _, pt := range p.Series {
+		fmt.Fprintf(w, "%.6f,%.3f\n", pt.T, pt.Kbps)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
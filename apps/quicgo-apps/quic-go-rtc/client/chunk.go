@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"quic-go-rtc/frame"
+)
+
+// chunkAssembler reassembles the frames a server running with
+// -chunked-frame splits into chunks on parallel streams. A frame is
+// complete, and delivered, once its last chunk's stream finishes; its
+// delivery time runs from the first chunk's stream being accepted. Each
+// chunk stream's own delivery is kept apart, for comparing the two.
+type chunkAssembler struct {
+	mu     sync.Mutex
+	frames map[uint32]*chunkedFrame
+	// streams holds each chunk's delivery, accept to FIN, by frame
+	streams  deliveryStats
+	count    int
+	complete int
+}
+
+// chunkedFrame is a frame whose chunks are still arriving.
+type chunkedFrame struct {
+	parts [][]byte
+	got   int
+	first time.Time
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{frames: make(map[uint32]*chunkedFrame)}
+}
+
+// add records chunk c, header included, whose stream was accepted at start
+// and finished at end. Once it completes its frame, add returns the frame's
+// payload and when its first chunk was accepted.
+func (a *chunkAssembler) add(c []byte, start, end time.Time, bidi bool) (seq uint32, body []byte, first time.Time, done bool, err error) {
+	seq, index, count, err := frame.ParseChunkHeader(c)
+	if err != nil {
+		return 0, nil, time.Time{}, false, err
+	}
+	a.streams.add(seq, start, end, len(c), bidi)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count = count
+	f, ok := a.frames[seq]
+	if !ok {
+		f = &chunkedFrame{parts: make([][]byte, count), first: start}
+		a.frames[seq] = f
+	}
+	if len(f.parts) != count {
+		return 0, nil, time.Time{}, false, fmt.Errorf("chunk %d of frame %d claims %d chunks, not %d", index, seq, count, len(f.parts))
+	}
+	if f.parts[index] != nil {
+		return 0, nil, time.Time{}, false, fmt.Errorf("duplicate chunk %d of frame %d", index, seq)
+	}
+	f.parts[index] = c[frame.ChunkHeaderLen:]
+	f.got++
+	if start.Before(f.first) {
+		f.first = start
+	}
+	if f.got < count {
+		return seq, nil, time.Time{}, false, nil
+	}
+	delete(a.frames, seq)
+	a.complete++
+	for _, p := range f.parts {
+		body = append(body, p...)
+	}
+	return seq, body, f.first, true, nil
+}
+
+// chunkStreams returns the delivery time distribution of the chunk
+// streams, or nil if no chunks arrived.
+func (a *chunkAssembler) chunkStreams() *deliverySummary {
+	s := a.streams.summary()
+	if s.Frames == 0 {
+		return nil
+	}
+	return &s
+}
+
+// report logs each chunk stream's delivery time next to the frame-complete
+// time of frames, the distribution over the whole frames, and how many
+// frames are missing chunks.
+func (a *chunkAssembler) report(frames deliverySummary) {
+	a.mu.Lock()
+	count, complete, partial := a.count, a.complete, len(a.frames)
+	a.mu.Unlock()
+	if count == 0 {
+		return
+	}
+	s := a.streams.summary()
+	log.Printf("Chunked frames: %d complete in %d chunks each, %d missing chunks", complete, count, partial)
+	log.Printf("  per chunk stream (accept to FIN) over %d chunks: p50 %.2f ms, p95 %.2f ms, max %.2f ms",
+		s.Frames, s.P50Ms, s.P95Ms, s.MaxMs)
+	log.Printf("  frame complete (first chunk accept to last chunk FIN): p50 %.2f ms, p95 %.2f ms, max %.2f ms",
+		frames.P50Ms, frames.P95Ms, frames.MaxMs)
+}
@@ -3,105 +3,921 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/handshake"
+	"quic-go-common/qtrace"
+	"quic-go-common/relay"
+	"quic-go-common/results"
+	"quic-go-common/scenario"
+	"quic-go-common/telemetry"
+	"quic-go-common/tuning"
+	"quic-go-rtc/frame"
 )
 
+// stallErrorCode is the application error code used when the client aborts a
+// stalled connection.
+const stallErrorCode quic.ApplicationErrorCode = 0x5
+
+// noDataErrorCode is the application error code used when the client aborts
+// a connection that delivered nothing within -zero-grace.
+const noDataErrorCode quic.ApplicationErrorCode = 0x6
+
 func main() {
 	serverAddr := flag.String("p", "127.0.0.1:8080", "server IP:port")
 	requestFrames := flag.Int("f", 300, "number of frames to request")
+	duration := flag.Duration("duration", 0, "stream for this long (e.g. 30s) instead of requesting -f frames")
+	stallTimeout := flag.Duration("stall-timeout", 0, "abort with partial stats if no data arrives on any stream for this long, counted from the connection being established until the first byte (0 disables)")
+	zeroGrace := flag.Duration("zero-grace", 0, "abort and exit non-zero if no data at all arrives within this long of the request (0 disables)")
 	t := flag.Float64("t", 0.0, "Start time of the test (unix seconds)")
+	sinkNames := flag.String("sink", "count,discard", "comma-separated sinks for received frames: count, file, discard; the received bytes are always counted, whichever are named")
+	saveDir := flag.String("save-dir", "", "directory for the file sink (one file per frame)")
+	alpn := flag.String("alpn", "http/0.9", "comma-separated ALPN protocols to propose, in order of preference")
+	maxP95Delay := flag.Duration("max-p95-delay", 0, "exit non-zero if the p95 frame delivery time exceeds this, printing PASS/FAIL (0 disables)")
+	bitrateWindow := flag.Duration("bitrate-window", 0, "report the distribution of received frame sizes and the received bitrate over a sliding window of this length, sampled every 100ms (0 disables)")
+	rawTimestamps := flag.String("raw-timestamps", "", "write a CSV of each frame's raw send time, from a server running with -send-timestamps, and its local accept and FIN times, all in Unix nanoseconds with no offset correction, to this file, for recomputing one-way delay offline")
+	clockOffset := flag.Duration("clock-offset", 0, "externally measured offset of the server clock from the client's (server minus client, e.g. from PTP), recorded with -raw-timestamps and in the -results-dir result; it is not applied to any output")
+	bitrateOut := flag.String("bitrate-out", "", "write the -bitrate-window series to this file, as CSV, or with the frame size distribution as JSON if it ends in .json")
+	reportGaps := flag.Bool("report-gaps", false, "list each stretch of at least -gap-threshold in which no frame completed, such as a loss recovery or RTO, with its start time and duration")
+	gapThreshold := flag.Duration("gap-threshold", 200*time.Millisecond, "shortest gap -report-gaps lists; keep it well above the frame interval")
+	startupFrames := flag.Int("startup-frames", 0, "also report delivery times of the first this many frames (join lag) apart from the rest (steady state); 0 disables")
+	jitterBuffer := flag.Duration("jitter-buffer", 0, "simulate playout through a jitter buffer of this depth (e.g. 100ms) and report underruns (0 disables)")
+	fps := flag.Int("fps", 30, "frame rate of the simulated playout")
+	recoverFEC := flag.Bool("fec", false, "recover single lost frames from the parity frames of a server running with -fec")
+	verify := flag.Bool("verify", false, "check each frame's payload against the seeded content of a server running with -seeded and report mismatches")
+	ackFrames := flag.Bool("ack-frames", false, "acknowledge each frame on a control stream so the server can log per-frame RTTs (adds uplink traffic)")
+	reconcileFlag := flag.Bool("reconcile", false, "at the end of the session, check the count of distinct frames received against the frames the server wrote, over the request stream, and exit with the integrity code on a mismatch")
+	caFile := flag.String("ca", "", "PEM file with the CA certificates to verify the server against")
+	insecure := flag.Bool("insecure", false, "skip server certificate verification (for the server's default self-signed certificate)")
+	connectTimeout := flag.Duration("connect-timeout", 0, "give up on connecting to the server after this long, handshake included, apart from the session that follows (0 leaves it to the QUIC handshake idle timeout)")
+	injectDelay := flag.Duration("inject-delay", 0, "test aid: delay every packet by this much in each direction in-process, for trying the delay and loss reporting without Mininet (0 disables)")
+	injectLoss := flag.Float64("inject-loss", 0, "test aid: drop each packet with this probability in each direction in-process (0 disables)")
+	relayAddr := flag.String("relay", "", "SOCKS5 proxy (host:port) to send the QUIC traffic through via UDP ASSOCIATE")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector (host:port or URL) to export trace spans to; tracing is off when empty")
+	resultsDir := flag.String("results-dir", "", "write the result, qlog, cwnd CSV, per-frame CSV and a manifest into a timestamped subdirectory of this directory")
+	stateDump := flag.String("state-dump", "", "write the connection's final state (negotiated parameters, RTT, congestion window, bytes per packet-number space, stream counts and close reason) as JSON to this file when it closes")
+	transportParams := flag.Bool("transport-params", false, "log the negotiated QUIC version and the transport parameters sent and received, and record them in the -results-dir result")
+	packetLog := flag.String("packet-log", "", "write a CSV of every packet sent and received, with timestamps, packet numbers and ACK ranges, to this file (large)")
+	flushInterval := flag.Duration("flush-interval", time.Second, "flush the qlog, cwnd CSV and -packet-log files this often during the run, so a run killed outright keeps its traces up to the last flush; SIGINT and SIGTERM flush them before exiting (0 flushes only at the end)")
+	captureSummary := flag.Bool("capture-summary", false, "log a packet-capture style summary at the end: QUIC packets sent and received by type, retransmissions, UDP bytes and the average packet size")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	initialStreamWindow := flag.Uint64("initial-stream-window", 0, "initial per-stream receive window in bytes (0 keeps the quic-go default)")
+	maxStreamWindow := flag.Uint64("max-stream-window", 0, "maximum per-stream receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	initialConnWindow := flag.Uint64("initial-conn-window", 0, "initial connection receive window in bytes (0 keeps the quic-go default)")
+	maxConnWindow := flag.Uint64("max-conn-window", 0, "maximum connection receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	scenarioFile := flag.String(scenario.FlagName, "", "JSON file of flag values to run with, keyed by flag name, e.g. {\"cc\": \"cubic\", \"initial-rtt\": \"50ms\"}; the flags given on the command line take precedence, and -results-dir records the effective value of every flag in the manifest")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile at the end of the run to this file")
+	blockProfile := flag.String("blockprofile", "", "write a profile of every goroutine blocking event to this file (slows the run)")
 	flag.Parse()
+	if err := scenario.Load(flag.CommandLine, *scenarioFile); err != nil {
+		log.Fatalf("invalid -scenario: %v", err)
+	}
 	disableGSO()
 
+	if *fps <= 0 {
+		log.Fatalf("invalid -fps %d: must be positive", *fps)
+	}
+	if *startupFrames < 0 {
+		log.Fatalf("invalid -startup-frames %d: must not be negative", *startupFrames)
+	}
+
+	if *zeroGrace < 0 {
+		log.Fatalf("invalid -zero-grace %v: must not be negative", *zeroGrace)
+	}
+	if *connectTimeout < 0 {
+		log.Fatalf("invalid -connect-timeout %v: must not be negative", *connectTimeout)
+	}
+	if *flushInterval < 0 {
+		log.Fatalf("invalid -flush-interval %v: must not be negative", *flushInterval)
+	}
+	if *bitrateWindow < 0 {
+		log.Fatalf("invalid -bitrate-window %v: must not be negative", *bitrateWindow)
+	}
+	if *clockOffset != 0 && *rawTimestamps == "" {
+		log.Fatal("-clock-offset needs -raw-timestamps")
+	}
+	if *bitrateOut != "" && *bitrateWindow == 0 {
+		log.Fatal("-bitrate-out needs a -bitrate-window")
+	}
+	if *reportGaps && *gapThreshold <= 0 {
+		log.Fatalf("invalid -gap-threshold %v: must be positive", *gapThreshold)
+	}
+	if *injectDelay < 0 {
+		log.Fatalf("invalid -inject-delay %v: must not be negative", *injectDelay)
+	}
+	if *injectLoss < 0 || *injectLoss >= 1 {
+		log.Fatalf("invalid -inject-loss %v: must be within [0, 1)", *injectLoss)
+	}
+
+	if *showVersion {
+		fmt.Println(results.BuildVersion())
+		return
+	}
+
+	if *caFile == "" && !*insecure {
+		log.Fatal("No CA given: pass -ca to verify the server certificate, or -insecure to skip verification")
+	}
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		var err error
+		if rootCAs, err = loadCertPool(*caFile); err != nil {
+			log.Fatal("CA error:", err)
+		}
+	}
+
+	var bundle *results.Bundle
+	quicConf := &quic.Config{}
+	windows := tuning.FlowControlWindows{
+		InitialStream: *initialStreamWindow,
+		MaxStream:     *maxStreamWindow,
+		InitialConn:   *initialConnWindow,
+		MaxConn:       *maxConnWindow,
+	}
+	if err := windows.Apply(quicConf); err != nil {
+		log.Fatalf("invalid receive windows: %v", err)
+	}
+	// an unresponsive server must run into the connect timeout, not the
+	// shorter default handshake idle timeout
+	if *connectTimeout > defaultHandshakeIdleTimeout {
+		quicConf.HandshakeIdleTimeout = *connectTimeout
+	}
+	traceFiles := qtrace.Files{PacketCSV: *packetLog}
+	if *resultsDir != "" {
+		var err error
+		if bundle, err = results.NewBundle(*resultsDir); err != nil {
+			log.Fatal("Results dir error:", err)
+		}
+		bundle.SetConfig(scenario.Effective(flag.CommandLine))
+		traceFiles.Qlog = bundle.Path("client.sqlog")
+		traceFiles.CwndCSV = bundle.Path("cwnd.csv")
+	}
+	var fileTracer, paramsTracer, dumpTracer, captureTracer qtrace.Tracer
+	if traceFiles != (qtrace.Files{}) {
+		traceFiles.FlushInterval = *flushInterval
+		fileTracer = qtrace.New(traceFiles)
+		qtrace.FlushOnSignal()
+	}
+	var params *qtrace.ParamsRecorder
+	if *transportParams {
+		params = qtrace.NewParamsRecorder()
+		paramsTracer = params.Tracer
+	}
+	var dump *qtrace.StateDump
+	if *stateDump != "" {
+		var err error
+		if dump, err = qtrace.NewStateDump(*stateDump); err != nil {
+			log.Fatal("State dump error:", err)
+		}
+		defer dump.Close()
+		dumpTracer = dump.Tracer
+	}
+	var capture *qtrace.Capture
+	if *captureSummary {
+		capture = qtrace.NewCapture()
+		captureTracer = capture.Tracer
+	}
+	mtus := qtrace.NewMTURecorder(quicConf, 0)
+	quicConf.Tracer = qtrace.Multi(fileTracer, paramsTracer, dumpTracer, captureTracer, mtus.Tracer)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "quic-go-rtc-client", *otlpEndpoint)
+	if err != nil {
+		log.Fatal("Tracing setup error:", err)
+	}
+	defer shutdownTracing()
+
+	stopProfiles, err := telemetry.Profiles{CPU: *cpuProfile, Mem: *memProfile, Block: *blockProfile}.Start()
+	if err != nil {
+		log.Fatalf("Profiling error: %v", err)
+	}
+	defer func() {
+		if err := stopProfiles(); err != nil {
+			log.Printf("Profiling error: %v", err)
+		}
+	}()
+
+	var totalBytes int64
+	sink, err := newSinks(*sinkNames, *saveDir, &totalBytes)
+	if err != nil {
+		log.Fatal("Sink error:", err)
+	}
+
 	var baseline time.Time
 	sec := int64(*t)
 	nsec := int64((*t - float64(sec)) * 1e9)
 	baseline = time.Unix(sec, nsec)
 
 	tlsConf := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"http/0.9"},
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: rootCAs == nil,
+		NextProtos:         parseALPN(*alpn),
 	}
 
-	session, err := quic.DialAddr(context.Background(), *serverAddr, tlsConf, nil)
+	ctx, connSpan := telemetry.Tracer().Start(context.Background(), "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", *serverAddr))
+	defer connSpan.End()
+
+	_, hsSpan := telemetry.Tracer().Start(ctx, "handshake")
+	session, err := dial(ctx, *serverAddr, *relayAddr, impairment{Delay: *injectDelay, Loss: *injectLoss}, tlsConf, quicConf, *connectTimeout)
+	hsSpan.End()
 	if err != nil {
+		if diag := handshake.Diagnose(err); diag != "" {
+			log.Printf("Handshake failed: %s (%v)", diag, err)
+			os.Exit(exitHandshake)
+		}
 		log.Fatal("Dial error:", err)
 	}
-	defer session.CloseWithError(0, "")
+	defer func() {
+		session.CloseWithError(0, "")
+		recordState(dump, session)
+	}()
+	log.Printf("Negotiated ALPN: %s", session.ConnectionState().TLS.NegotiatedProtocol)
+	var negotiated *qtrace.Negotiated
+	if params != nil {
+		n := params.Negotiated(session)
+		n.Log()
+		negotiated = &n
+	}
 
-	log.Printf("GetN request: %d frames ( %d seconds)", *requestFrames, int(*requestFrames/30))
+	cmd := fmt.Sprintf("GETN %d\r\n", *requestFrames)
+	if *duration > 0 {
+		log.Printf("GetT request: %v", *duration)
+		cmd = fmt.Sprintf("GETT %.3f\r\n", duration.Seconds())
+	} else {
+		log.Printf("GetN request: %d frames ( %d seconds)", *requestFrames, int(*requestFrames/30))
+	}
+	if *reconcileFlag {
+		cmd = strings.TrimSuffix(cmd, "\r\n") + " " + frame.ReconcileOption + "\r\n"
+	}
 
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
 	stream, err := session.OpenStreamSync(context.Background())
 	if err != nil {
 		log.Fatal("Open stream error:", err)
 	}
-	cmd := fmt.Sprintf("GETN %d\r\n", *requestFrames)
 	if _, err := stream.Write([]byte(cmd)); err != nil {
-		log.Fatal("Write GETN error:", err)
+		log.Fatal("Write request error:", err)
+	}
+	reqSpan.SetAttributes(attribute.String("request", strings.TrimSpace(cmd)))
+	reqSpan.End()
+
+	var counts *countCheck
+	if *reconcileFlag {
+		counts = newCountCheck(stream)
+	}
+
+	var ackStream *quic.Stream
+	var ackMu sync.Mutex
+	if *ackFrames {
+		if ackStream, err = session.OpenStreamSync(context.Background()); err != nil {
+			log.Fatal("Open ACK stream error:", err)
+		}
 	}
 
-	totalBytes := 0
-	var totalBytesMutex sync.Mutex
 	var wg sync.WaitGroup
-	var frameCounter int64
+	// received holds the sequence numbers (1-based) of fully read frames
+	received := make(map[uint32]bool)
+	var maxSeq uint32
+	var receivedMu sync.Mutex
 
-	// record the actual request start time (for elapsed/goodput)
+	// the session is established, so a server that never sends a frame
+	// stalls like one that stops
+	var watch stallWatch
+	watch.start()
+	var stalled atomic.Bool
+	var noData atomic.Bool
+	// aborted reports whether the client closed the connection itself, so
+	// that the errors this causes on the streams are expected
+	aborted := func() bool { return stalled.Load() || noData.Load() }
+
+	var delivery deliveryStats
+
+	var fec *fecDecoder
+	if *recoverFEC {
+		fec = newFECDecoder()
+	}
+
+	// corrupt holds the frames whose payload failed -verify
+	var corrupt []uint32
+	var corruptMu sync.Mutex
+	check := func(seq uint32, body []byte) {
+		if !*verify || frame.CheckSeeded(body, seq) {
+			return
+		}
+		corruptMu.Lock()
+		corrupt = append(corrupt, seq)
+		corruptMu.Unlock()
+	}
+
+	// a server running with -chunked-frame splits every frame into chunks
+	chunks := newChunkAssembler()
+
+	var stamps *sendStamps
+	if *rawTimestamps != "" {
+		stamps = newSendStamps()
+	}
+
+	deliver := func(seq uint32, start time.Time, size int, bidi bool) {
+		delivery.add(seq, start, time.Now(), size, bidi)
+		receivedMu.Lock()
+		received[seq] = true
+		maxSeq = max(maxSeq, seq)
+		receivedMu.Unlock()
+		fmt.Printf("frame %d, fin time: %.6f\n", seq, time.Since(baseline).Seconds())
+		if ackStream != nil {
+			ack := make([]byte, frame.AckLen)
+			frame.PutAck(ack, seq, time.Now())
+			ackMu.Lock()
+			_, err := ackStream.Write(ack)
+			ackMu.Unlock()
+			if err != nil && !aborted() {
+				log.Println("Write ACK error:", err)
+			}
+		}
+	}
+
+	var bundleWritten bool
+	writeBundle := func(res Result) {
+		bundleWritten = true
+		if res.Bitrate != nil {
+			if err := res.Bitrate.write(bundle.Path("bitrate.csv")); err != nil {
+				log.Fatal("Write bitrate series error:", err)
+			}
+			// the series is in bitrate.csv
+			p := *res.Bitrate
+			p.Series = nil
+			res.Bitrate = &p
+		}
+		if err := bundle.WriteJSON("result.json", res); err != nil {
+			log.Fatal("Write result error:", err)
+		}
+		if err := delivery.writeCSV(bundle.Path("frames.csv"), baseline); err != nil {
+			log.Fatal("Write frames CSV error:", err)
+		}
+		if stamps != nil {
+			if err := delivery.writeRawTimestamps(bundle.Path("raw_timestamps.csv"), stamps, *clockOffset); err != nil {
+				log.Fatal("Write raw timestamps error:", err)
+			}
+		}
+		dir, err := bundle.Finish()
+		if err != nil {
+			log.Fatal("Results dir error:", err)
+		}
+		log.Printf("Results written to %s", dir)
+	}
+
+	// a panic on the way still writes out the frames delivered so far; one
+	// in a frame reader is caught by guard, and ends the run the normal way
+	var guard crashGuard
 	requestStart := time.Now()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if guard.reason() != "" {
+			// repanicking what a frame reader panicked with, already flushed
+			panic(r)
+		}
+		receivedMu.Lock()
+		frames := len(received)
+		receivedMu.Unlock()
+		log.Printf("Run panicked after %d frames; partial stats follow", frames)
+		delivery.report()
+		if bundle != nil && !bundleWritten {
+			session.CloseWithError(0, "")
+			total, elapsed := atomic.LoadInt64(&totalBytes), time.Since(requestStart)
+			writeBundle(Result{
+				Request:       strings.TrimSpace(cmd),
+				Negotiated:    negotiated,
+				Frames:        frames,
+				Bytes:         int(total),
+				Elapsed:       elapsed,
+				RawThroughput: float64(total) * 8.0 / 1e6 / elapsed.Seconds(),
+				Delivery:      delivery.summary(),
+				Termination:   Termination{Kind: termPanic},
+				Incomplete:    fmt.Sprintf("panic: %v", r),
+			})
+		}
+		panic(r)
+	}()
 
-	// receive each server-initiated uni stream
-	wg.Add(*requestFrames)
-	for i := 0; i < *requestFrames; i++ {
-		go func() {
-			defer wg.Done()
+	handleStream := func(s frameStream, bidi bool) {
+		start := time.Now()
+		seq, body, size, err := readFrame(s, sink, &watch, fec != nil || *verify || stamps != nil)
+		if err != nil {
+			if !aborted() {
+				log.Println("Read frame error:", err)
+			}
+			return
+		}
 
-			s, err := session.AcceptUniStream(context.Background())
+		// a recovered frame counts as delivered when the stream that
+		// completed its group finished
+		var rec *recoveredFrame
+		switch {
+		case frame.IsChunk(seq):
+			seq, body, first, done, err := chunks.add(body, start, time.Now(), bidi)
 			if err != nil {
-				if qerr, ok := err.(*quic.ApplicationError); ok && qerr.ErrorCode == 0 {
-					// normal close signal, ignore
-					return
-				} else {
-					log.Println("AcceptUniStream error:", err)
-					return
-				}
+				log.Println("Chunk error:", err)
+				return
+			}
+			if done {
+				check(seq, body)
+				stamps.note(seq, body)
+				deliver(seq, first, writeRebuilt(sink, seq, body), bidi)
+			}
+			return
+		case frame.IsParity(seq):
+			if fec == nil {
+				return
+			}
+			if rec, err = fec.addParity(body); err != nil {
+				log.Println("Parity frame error:", err)
+				return
+			}
+		case fec != nil:
+			check(seq, body)
+			var dup bool
+			if dup, rec = fec.addData(seq, body); dup {
+				return
 			}
+			deliver(seq, start, size, bidi)
+		default:
+			check(seq, body)
+			stamps.note(seq, body)
+			deliver(seq, start, size, bidi)
+		}
+		if rec != nil {
+			check(rec.seq, rec.body)
+			deliver(rec.seq, start, writeRebuilt(sink, rec.seq, rec.body), bidi)
+		}
+	}
 
-			buf := make([]byte, 12500)
-			for {
-				n, err := s.Read(buf)
-				if n > 0 {
-					totalBytesMutex.Lock()
-					totalBytes += n
-					totalBytesMutex.Unlock()
-				}
-				if err != nil {
-					if err != io.EOF {
-						log.Println("Read stream error:", err)
-					}
-					break
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+
+	// record the actual request start time (for elapsed/goodput)
+	requestStart = time.Now()
+
+	if *stallTimeout > 0 {
+		watchDone := make(chan struct{})
+		defer close(watchDone)
+		go watch.watch(*stallTimeout, watchDone, func() {
+			stalled.Store(true)
+			session.CloseWithError(stallErrorCode, "stalled")
+		})
+	}
+	if *zeroGrace > 0 {
+		graceDone := make(chan struct{})
+		defer close(graceDone)
+		go watch.awaitFirst(*zeroGrace, graceDone, func() {
+			noData.Store(true)
+			session.CloseWithError(noDataErrorCode, "no data")
+		})
+	}
+
+	// Accept uni streams until the server closes the connection, starting a
+	// reader per stream actually received. In GETN mode stop early once every
+	// requested frame has arrived.
+	acceptCtx, stopAccept := context.WithCancel(context.Background())
+	defer stopAccept()
+	read := func(s frameStream, bidi bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer guard.catch(func() {
+				stopAccept()
+				session.CloseWithError(0, "")
+			})
+			handleStream(s, bidi)
+			if *duration == 0 {
+				receivedMu.Lock()
+				done := len(received) >= *requestFrames
+				receivedMu.Unlock()
+				if done {
+					stopAccept()
 				}
 			}
-			id := int(atomic.AddInt64(&frameCounter, 1))
-			fmt.Printf("frame %d, fin time: %.6f\n", id, time.Since(baseline).Seconds())
 		}()
 	}
+	// a server running with -stream-type bidi or alternate sends frames on
+	// bidi streams too; those end with the session like the uni ones
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			s, err := session.AcceptStream(acceptCtx)
+			if err != nil {
+				return
+			}
+			// the client sends nothing on a frame stream
+			s.Close()
+			read(s, true)
+		}
+	}()
+	// with -reconcile the session ends once the frames behind the server's
+	// end marker are in
+	var settled atomic.Bool
+	if counts != nil {
+		go counts.settle(&watch, acceptCtx.Done(), func() {
+			settled.Store(true)
+			stopAccept()
+		})
+	}
+	var acceptErr error
+	for {
+		s, err := session.AcceptUniStream(acceptCtx)
+		if err != nil {
+			acceptErr = err
+			if acceptCtx.Err() != nil || aborted() {
+				break
+			}
+			if qerr, ok := err.(*quic.ApplicationError); !ok || qerr.ErrorCode != 0 {
+				log.Println("AcceptUniStream error:", err)
+			}
+			break
+		}
+		read(s, false)
+	}
 
 	// wait for all frames to be received
 	wg.Wait()
+	transferEnd := time.Now()
+
+	var count *frameCount
+	if counts != nil && !aborted() {
+		receivedMu.Lock()
+		distinct, highest := len(received), maxSeq
+		receivedMu.Unlock()
+		if count, err = counts.exchange(distinct, highest); err != nil {
+			log.Printf("Frame count check error: %v", err)
+		}
+	}
+
+	// let the server read the last ACKs before the connection is torn down
+	if ackStream != nil {
+		ackStream.Close()
+		select {
+		case <-session.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+
+	if stalled.Load() {
+		log.Printf("Result: stalled, no data for %v; partial stats follow", *stallTimeout)
+	}
+	if noData.Load() {
+		log.Printf("Result: no data within -zero-grace %v of the request; aborted", *zeroGrace)
+	}
 
 	elapsed := time.Since(requestStart).Seconds()
-	mb := float64(totalBytes) / 1000.0 / 1000.0
+	total := int(atomic.LoadInt64(&totalBytes))
+	mb := float64(total) / 1000.0 / 1000.0
 	mbps := mb * 8.0 / elapsed
 
-	log.Printf("Recv %s bytes in %.3f s, goodput: %.2f Mbps", printBytes(totalBytes), elapsed, mbps)
+	log.Printf("Recv %s bytes in %.3f s, raw throughput: %.2f Mbps (includes pacing idle time)", printBytes(total), elapsed, mbps)
+	delivery.report()
+	if *startupFrames > 0 {
+		delivery.reportSplit(*startupFrames)
+	}
+	chunks.report(delivery.summary())
+	streamTypes := delivery.byStreamType()
+	reportStreamTypes(streamTypes)
+	var bitrate *bitrateProfile
+	if *bitrateWindow > 0 {
+		p := delivery.profile(*bitrateWindow, baseline)
+		p.report()
+		if *bitrateOut != "" {
+			if err := p.write(*bitrateOut); err != nil {
+				log.Fatal("Write bitrate series error:", err)
+			}
+		}
+		bitrate = &p
+	}
+	if stamps != nil {
+		if err := delivery.writeRawTimestamps(*rawTimestamps, stamps, *clockOffset); err != nil {
+			log.Fatal("Write raw timestamps error:", err)
+		}
+		stamps.mu.Lock()
+		n := len(stamps.at)
+		stamps.mu.Unlock()
+		if n == 0 {
+			log.Printf("No frame carried a send time; is the server running with -send-timestamps?")
+		}
+	}
+	var gaps []gap
+	if *reportGaps {
+		gaps = delivery.gaps(requestStart, transferEnd, *gapThreshold)
+		logGaps(gaps, *gapThreshold)
+	}
+	termination := terminationOf(acceptErr, acceptCtx.Err() != nil && !settled.Load(), settled.Load(), stalled.Load(), noData.Load())
+	if guard.reason() != "" {
+		termination = Termination{Kind: termPanic}
+	}
+	log.Printf("Transfer ended: %s", termination)
+	xferSpan.SetAttributes(attribute.Int("bytes", total), attribute.Float64("goodput_mbps", mbps))
+	xferSpan.End()
+
+	// in duration mode the highest sequence seen, or the server's with
+	// -reconcile, bounds the expected frames
+	expected := uint32(*requestFrames)
+	if *duration > 0 {
+		expected = maxSeq
+		if count != nil {
+			expected = uint32(count.Last)
+		}
+	}
+	var lost []uint32
+	for seq := uint32(1); seq <= expected; seq++ {
+		if !received[seq] {
+			lost = append(lost, seq)
+		}
+	}
+	if len(lost) > 0 {
+		log.Printf("Lost %d of %d frames: %s", len(lost), expected, joinSeqs(lost))
+	}
+
+	if count != nil {
+		count.report()
+	}
+
+	if len(corrupt) > 0 {
+		slices.Sort(corrupt)
+		log.Printf("Corrupt %d of %d frames: %s", len(corrupt), len(received), joinSeqs(corrupt))
+	} else if *verify {
+		log.Printf("Verified %d frames", len(received))
+	}
+
+	var recovered int
+	if fec != nil {
+		recovered = fec.report()
+	}
+
+	var playout *playoutStats
+	if *jitterBuffer > 0 {
+		p := delivery.playout(expected, *jitterBuffer, time.Second/time.Duration(*fps))
+		p.report()
+		playout = &p
+	}
+	mtus.Report()
+	if capture != nil {
+		capture.Report()
+	}
+
+	if bundle != nil {
+		// close the connection first so the qlog and cwnd CSV are flushed
+		session.CloseWithError(0, "")
+		var startup, steady *deliverySummary
+		if *startupFrames > 0 {
+			s, r := delivery.split(*startupFrames)
+			startup, steady = &s, &r
+		}
+		res := Result{
+			Request:       strings.TrimSpace(cmd),
+			Negotiated:    negotiated,
+			Frames:        len(received),
+			Lost:          lost,
+			Bytes:         total,
+			Elapsed:       time.Duration(elapsed * float64(time.Second)),
+			RawThroughput: mbps,
+			Delivery:      delivery.summary(),
+			Startup:       startup,
+			Steady:        steady,
+			StreamTypes:   streamTypes,
+			ChunkStreams:  chunks.chunkStreams(),
+			Stalled:       stalled.Load(),
+			Termination:   termination,
+			Playout:       playout,
+			Recovered:     recovered,
+			Corrupt:       corrupt,
+			FrameCount:    count,
+			Gaps:          gaps,
+			Bitrate:       bitrate,
+			ClockOffset:   clockOffsetOf(stamps, *clockOffset),
+			Incomplete:    guard.reason(),
+		}
+		writeBundle(res)
+	}
+	guard.repanic()
+
+	code := termination.exitCode()
+	if code == exitOK && (len(corrupt) > 0 || count != nil && count.Missing > 0) {
+		code = exitIntegrity
+	}
+	if noData.Load() {
+		fmt.Printf("FAIL: no data within %v of the request\n", *zeroGrace)
+	}
+
+	if *maxP95Delay > 0 {
+		sum := delivery.summary()
+		p95 := time.Duration(sum.P95Ms * float64(time.Millisecond))
+		failed := true
+		switch {
+		case sum.Frames == 0:
+			fmt.Println("FAIL: no frames delivered")
+		case p95 > *maxP95Delay:
+			fmt.Printf("FAIL: p95 delivery time %v above maximum %v\n", p95, *maxP95Delay)
+		default:
+			fmt.Printf("PASS: p95 delivery time %v\n", p95)
+			failed = false
+		}
+		if failed && code == exitOK {
+			code = exitThreshold
+		}
+	}
+	if code != exitOK {
+		// os.Exit skips the deferred close, profiles and trace flush
+		session.CloseWithError(0, "")
+		recordState(dump, session)
+		stopProfiles()
+		shutdownTracing()
+		os.Exit(code)
+	}
+}
+
+// recordState writes the final state of conn, which must have closed, to
+// dump, if set.
+func recordState(dump *qtrace.StateDump, conn *quic.Conn) {
+	if err := dump.Record(conn); err != nil {
+		log.Printf("State dump error: %v", err)
+	}
+}
+
+// frameStream is the receive side of a frame's stream, a
+// *quic.ReceiveStream or a *quic.Stream.
+type frameStream interface {
+	io.Reader
+	CancelRead(quic.StreamErrorCode)
+}
+
+// readFrame reads one frame stream to completion, passing its bytes (header
+// included) to sink, and returns the frame's sequence number and size. With
+// keepBody it also returns the payload after the header. Parity frames and
+// chunks bypass the sink and are returned whole, header included.
+func readFrame(s frameStream, sink FrameSink, watch *stallWatch, keepBody bool) (uint32, []byte, int, error) {
+	hdr := make([]byte, frame.HeaderLen)
+	if _, err := io.ReadFull(s, hdr); err != nil {
+		return 0, nil, 0, err
+	}
+	watch.touch()
+	seq, err := frame.ParseHeader(hdr)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if frame.IsParity(seq) || frame.IsChunk(seq) {
+		rest, err := io.ReadAll(s)
+		watch.touch()
+		return seq, append(hdr, rest...), len(hdr) + len(rest), err
+	}
+
+	w, err := sink.OpenFrame(seq)
+	if err != nil {
+		s.CancelRead(0)
+		return seq, nil, 0, err
+	}
+	defer func() {
+		if err := w.Close(); err != nil {
+			log.Println("Sink close error:", err)
+		}
+	}()
+	if _, err := w.Write(hdr); err != nil {
+		log.Println("Sink write error:", err)
+	}
+
+	size := len(hdr)
+	var body []byte
+	buf := make([]byte, 12500)
+	for {
+		n, err := s.Read(buf)
+		if n > 0 {
+			size += n
+			watch.touch()
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				log.Println("Sink write error:", werr)
+			}
+			if keepBody {
+				body = append(body, buf[:n]...)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				return seq, body, size, err
+			}
+			return seq, body, size, nil
+		}
+	}
+}
+
+// writeRebuilt passes frame seq, rebuilt from parity or from its chunks,
+// to sink as if it had been received whole, and returns its size.
+func writeRebuilt(sink FrameSink, seq uint32, body []byte) int {
+	f := make([]byte, frame.HeaderLen+len(body))
+	frame.PutHeader(f, seq)
+	copy(f[frame.HeaderLen:], body)
+
+	w, err := sink.OpenFrame(seq)
+	if err != nil {
+		log.Println("Sink error:", err)
+		return len(f)
+	}
+	if _, err := w.Write(f); err != nil {
+		log.Println("Sink write error:", err)
+	}
+	if err := w.Close(); err != nil {
+		log.Println("Sink close error:", err)
+	}
+	return len(f)
+}
+
+// defaultHandshakeIdleTimeout is the quic-go default for
+// quic.Config.HandshakeIdleTimeout.
+const defaultHandshakeIdleTimeout = 5 * time.Second
+
+// dial connects to serverAddr, through the SOCKS5 proxy at relayAddr unless
+// it is empty, and through an injectConn if im is active. The relay
+// association and the injecting socket live until the process exits. A
+// positive timeout bounds the whole of it, handshake included.
+func dial(ctx context.Context, serverAddr, relayAddr string, im impairment, tlsConf *tls.Config, quicConf *quic.Config, timeout time.Duration) (*quic.Conn, error) {
+	relayTimeout := 5 * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		relayTimeout = timeout
+	}
+	var conn net.PacketConn
+	var err error
+	switch {
+	case relayAddr != "":
+		if conn, err = relay.DialSOCKS5(relayAddr, relayTimeout); err != nil {
+			return nil, err
+		}
+		log.Printf("Relaying through %s", relayAddr)
+	case im.active():
+		if conn, err = net.ListenUDP("udp", nil); err != nil {
+			return nil, err
+		}
+	}
+	if im.active() {
+		conn = newInjectConn(conn, im)
+		log.Printf("Injecting %v of delay and %.2f%% loss in each direction (test aid, not a network emulator)", im.Delay, 100*im.Loss)
+	}
+
+	var session *quic.Conn
+	if conn == nil {
+		session, err = quic.DialAddr(ctx, serverAddr, tlsConf, quicConf)
+	} else {
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr("udp", serverAddr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		session, err = quic.Dial(ctx, conn, addr, tlsConf, quicConf)
+	}
+	if err != nil && timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("connect timeout: no connection to %s within %v: %w", serverAddr, timeout, err)
+	}
+	return session, err
+}
+
+// loadCertPool reads PEM-encoded CA certificates from path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// parseALPN splits a comma-separated ALPN protocol list, dropping empty
+// entries.
+func parseALPN(list string) []string {
+	var protos []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protos = append(protos, p)
+		}
+	}
+	return protos
 }
 
 // disable GSO; in Mininet’s virtual links, GSO behaves unexpectedly and
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// crashGuard holds on to the first panic in a frame reader, so that the
+// run can end and write out what it collected before the panic goes on.
+// Panics on quic-go's own goroutines, such as in a tracer, cannot be
+// caught.
+type crashGuard struct {
+	mu    sync.Mutex
+	value any
+	stack []byte
+}
+
+// catch, deferred in a frame reader, recovers its panic and calls stop to
+// end the run.
+func (g *crashGuard) catch(stop func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	g.mu.Lock()
+	first := g.stack == nil
+	if first {
+		g.value, g.stack = r, debug.Stack()
+	}
+	g.mu.Unlock()
+	if first {
+		log.Printf("Frame reader panicked: %v; ending the run with partial stats", r)
+		stop()
+	}
+}
+
+// reason returns why the run ended early, or "" if nothing panicked.
+func (g *crashGuard) reason() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stack == nil {
+		return ""
+	}
+	return fmt.Sprintf("panic: %v", g.value)
+}
+
+// repanic goes on with the caught panic, if any, after printing the stack
+// it was caught on.
+func (g *crashGuard) repanic() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stack == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "frame reader panic: %v\n\n%s\n", g.value, g.stack)
+	panic(g.value)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// deliveryStats collects how long each frame took to arrive, measured from
+// the moment its stream is accepted (first data received) to its FIN.
+//
+// The server paces frames, so wall-clock throughput over the whole session
+// mostly measures the pacing schedule. The effective delivery rate instead
+// divides the received bytes by the time at least one frame was in flight,
+// which is what "goodput" means for RTC: how fast frames were delivered, not
+// how much capacity the path had.
+type deliveryStats struct {
+	mu     sync.Mutex
+	frames []frameDelivery
+}
+
+type frameDelivery struct {
+	seq        uint32
+	start, end time.Time
+	bytes      int
+	// bidi is set for frames that came on a bidi stream
+	bidi bool
+}
+
+func (d *deliveryStats) add(seq uint32, start, end time.Time, bytes int, bidi bool) {
+	d.mu.Lock()
+	d.frames = append(d.frames, frameDelivery{seq, start, end, bytes, bidi})
+	d.mu.Unlock()
+}
+
+// busyTime returns the total time covered by the union of the frames'
+// delivery intervals, so overlapping frames are not double counted.
+func busyTime(frames []frameDelivery) time.Duration {
+	frames = slices.Clone(frames)
+	slices.SortFunc(frames, func(a, b frameDelivery) int { return a.start.Compare(b.start) })
+
+	var busy time.Duration
+	var curStart, curEnd time.Time
+	for i, f := range frames {
+		if i == 0 || f.start.After(curEnd) {
+			busy += curEnd.Sub(curStart)
+			curStart, curEnd = f.start, f.end
+		} else if f.end.After(curEnd) {
+			curEnd = f.end
+		}
+	}
+	return busy + curEnd.Sub(curStart)
+}
+
+// deliverySummary is the delivery time distribution and the effective
+// delivery rate, with times in milliseconds.
+type deliverySummary struct {
+	Frames        int     `json:"frames"`
+	MinMs         float64 `json:"min_ms"`
+	AvgMs         float64 `json:"avg_ms"`
+	P50Ms         float64 `json:"p50_ms"`
+	P95Ms         float64 `json:"p95_ms"`
+	MaxMs         float64 `json:"max_ms"`
+	EffectiveMbps float64 `json:"effective_mbps"`
+	BusySeconds   float64 `json:"busy_s"`
+}
+
+func (d *deliveryStats) summary() deliverySummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return summarize(d.frames)
+}
+
+// split summarizes the first n frames of the stream, by sequence number,
+// apart from the rest: startup is dominated by the first keyframe and slow
+// start, which set the join lag, while the steady state shows ongoing
+// smoothness.
+func (d *deliveryStats) split(n int) (startup, steady deliverySummary) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var first, rest []frameDelivery
+	for _, f := range d.frames {
+		if int(f.seq) <= n {
+			first = append(first, f)
+		} else {
+			rest = append(rest, f)
+		}
+	}
+	return summarize(first), summarize(rest)
+}
+
+func summarize(frames []frameDelivery) deliverySummary {
+	if len(frames) == 0 {
+		return deliverySummary{}
+	}
+
+	times := make([]time.Duration, len(frames))
+	total := 0
+	var sum time.Duration
+	for i, f := range frames {
+		times[i] = f.end.Sub(f.start)
+		sum += times[i]
+		total += f.bytes
+	}
+	slices.Sort(times)
+
+	s := deliverySummary{
+		Frames: len(times),
+		MinMs:  toMs(times[0]),
+		AvgMs:  toMs(sum / time.Duration(len(times))),
+		P50Ms:  toMs(percentile(times, 0.50)),
+		P95Ms:  toMs(percentile(times, 0.95)),
+		MaxMs:  toMs(times[len(times)-1]),
+	}
+	if busy := busyTime(frames); busy > 0 {
+		s.BusySeconds = busy.Seconds()
+		s.EffectiveMbps = float64(total) * 8.0 / 1e6 / busy.Seconds()
+	}
+	return s
+}
+
+// report logs the delivery time distribution and the effective delivery rate.
+func (d *deliveryStats) report() {
+	s := d.summary()
+	if s.Frames == 0 {
+		return
+	}
+	log.Printf("Frame delivery time (accept to FIN) over %d frames: min %.2f ms, avg %.2f ms, p50 %.2f ms, p95 %.2f ms, max %.2f ms",
+		s.Frames, s.MinMs, s.AvgMs, s.P50Ms, s.P95Ms, s.MaxMs)
+	if s.BusySeconds > 0 {
+		log.Printf("Effective delivery rate: %.2f Mbps over %.3f s with frames in flight",
+			s.EffectiveMbps, s.BusySeconds)
+	}
+}
+
+// reportSplit logs the delivery time distribution of the first n frames and
+// of the frames after them.
+func (d *deliveryStats) reportSplit(n int) {
+	startup, steady := d.split(n)
+	for _, part := range []struct {
+		name string
+		s    deliverySummary
+	}{
+		{fmt.Sprintf("startup (frames 1-%d)", n), startup},
+		{fmt.Sprintf("steady state (after frame %d)", n), steady},
+	} {
+		if part.s.Frames == 0 {
+			log.Printf("Frame delivery time, %s: no frames", part.name)
+			continue
+		}
+		log.Printf("Frame delivery time, %s, over %d frames: min %.2f ms, avg %.2f ms, p50 %.2f ms, p95 %.2f ms, max %.2f ms",
+			part.name, part.s.Frames, part.s.MinMs, part.s.AvgMs, part.s.P50Ms, part.s.P95Ms, part.s.MaxMs)
+	}
+}
+
+// byStreamType summarizes the frames by the type of stream they came on,
+// "uni" or "bidi". It returns nil if none came on a bidi stream, from a
+// server with the default -stream-type.
+func (d *deliveryStats) byStreamType() map[string]deliverySummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var uni, bidi []frameDelivery
+	for _, f := range d.frames {
+		if f.bidi {
+			bidi = append(bidi, f)
+		} else {
+			uni = append(uni, f)
+		}
+	}
+	if len(bidi) == 0 {
+		return nil
+	}
+	types := map[string]deliverySummary{"bidi": summarize(bidi)}
+	if len(uni) > 0 {
+		types["uni"] = summarize(uni)
+	}
+	return types
+}
+
+// reportStreamTypes logs the delivery time distribution per stream type.
+func reportStreamTypes(types map[string]deliverySummary) {
+	for _, name := range []string{"uni", "bidi"} {
+		s, ok := types[name]
+		if !ok {
+			continue
+		}
+		log.Printf("Frame delivery time on %s streams over %d frames: min %.2f ms, avg %.2f ms, p50 %.2f ms, p95 %.2f ms, max %.2f ms, effective %.2f Mbps",
+			name, s.Frames, s.MinMs, s.AvgMs, s.P50Ms, s.P95Ms, s.MaxMs, s.EffectiveMbps)
+	}
+}
+
+// gap is a stretch in which no frame completed, such as a loss recovery
+// or an RTO stalling the path, with Start relative to the request.
+type gap struct {
+	Start    time.Duration `json:"start_ns"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// gaps returns the stretches of at least threshold between one frame's FIN
+// and the next, and between the last FIN and end, with times relative to
+// origin. Gaps are counted from the first FIN on, and with the frames
+// being paced, threshold must be well above the frame interval.
+func (d *deliveryStats) gaps(origin, end time.Time, threshold time.Duration) []gap {
+	d.mu.Lock()
+	ends := make([]time.Time, len(d.frames), len(d.frames)+1)
+	for i, f := range d.frames {
+		ends[i] = f.end
+	}
+	d.mu.Unlock()
+	if len(ends) == 0 {
+		return nil
+	}
+	slices.SortFunc(ends, time.Time.Compare)
+	ends = append(ends, end)
+
+	var found []gap
+	for i := 1; i < len(ends); i++ {
+		if idle := ends[i].Sub(ends[i-1]); idle >= threshold {
+			found = append(found, gap{Start: ends[i-1].Sub(origin), Duration: idle})
+		}
+	}
+	return found
+}
+
+// logGaps logs the gaps of at least threshold.
+func logGaps(gaps []gap, threshold time.Duration) {
+	if len(gaps) == 0 {
+		log.Printf("No delivery gaps of %v or longer", threshold)
+		return
+	}
+	var total time.Duration
+	for _, g := range gaps {
+		total += g.Duration
+	}
+	log.Printf("Delivery gaps of %v or longer: %d, %.3f s in total", threshold, len(gaps), total.Seconds())
+	for _, g := range gaps {
+		log.Printf("  at %.3f s for %.3f s", g.Start.Seconds(), g.Duration.Seconds())
+	}
+}
+
+// writeCSV writes one row per frame, with times relative to baseline as in
+// the "fin time" output.
+func (d *deliveryStats) writeCSV(path string, baseline time.Time) error {
+	d.mu.Lock()
+	frames := slices.Clone(d.frames)
+	d.mu.Unlock()
+	slices.SortFunc(frames, func(a, b frameDelivery) int { return int(a.seq) - int(b.seq) })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "seq,accept_s,fin_s,delivery_ms,bytes")
+	for _, fr := range frames {
+		fmt.Fprintf(w, "%d,%.6f,%.6f,%.3f,%d\n", fr.seq,
+			fr.start.Sub(baseline).Seconds(), fr.end.Sub(baseline).Seconds(),
+			toMs(fr.end.Sub(fr.start)), fr.bytes)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// percentile returns the nearest-rank p-quantile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
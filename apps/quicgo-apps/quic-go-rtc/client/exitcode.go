@@ -0,0 +1,55 @@
+package main
+
+// Exit codes of the client, so that a sweep runner can branch on the
+// outcome of a run without parsing its logs:
+//
+//	0  success: the transfer ended normally (and passed -max-p95-delay)
+//	1  any other error, such as a failed dial, a transport error or a
+//	   stateless reset
+//	2  bad command line (the flag package's own exit code)
+//	3  bad request: the server rejected the request
+//	4  timeout: the connection idled out, or no data arrived within
+//	   -zero-grace
+//	5  stalled: no data arrived for -stall-timeout mid-transfer
+//	6  handshake failure: TLS, ALPN, version negotiation or a handshake
+//	   timeout
+//	7  integrity mismatch: -verify found corrupt frames, or -reconcile
+//	   frames the server wrote in full that never arrived
+//	8  threshold failure: the p95 delivery time exceeded -max-p95-delay
+//
+// When several apply, the first of 3 to 5 that does wins over 7, which
+// wins over 8. A panic exits with the Go runtime's code 2, after writing
+// the partial result.
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitRejected  = 3
+	exitTimeout   = 4
+	exitStalled   = 5
+	exitHandshake = 6
+	exitIntegrity = 7
+	exitThreshold = 8
+)
+
+// exitCode returns the exit code for a session that ended as t.
+func (t Termination) exitCode() int {
+	switch t.Kind {
+	case termComplete, termSendEnd:
+		return exitOK
+	case termAppClose:
+		// the server ends a GETT session, or any session after its last
+		// frame, with no error
+		if t.Code == 0 {
+			return exitOK
+		}
+		return exitError
+	case termRejected:
+		return exitRejected
+	case termIdleTimeout, termNoData:
+		return exitTimeout
+	case termClientTimeout:
+		return exitStalled
+	default:
+		return exitError
+	}
+}
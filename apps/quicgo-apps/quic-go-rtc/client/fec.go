@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	"quic-go-rtc/frame"
+)
+
+// fecHistory is how many frames behind the newest parity group unresolved
+// groups are kept before their buffered payloads are dropped. Recovered
+// frames are remembered as long, so that one arriving later anyway is not
+// counted twice.
+const fecHistory = 256
+
+// fecDecoder recovers a single missing frame per parity group. It buffers the
+// payload of every received frame until the parity frame covering it has
+// arrived and the group is either complete or has been repaired.
+type fecDecoder struct {
+	mu       sync.Mutex
+	bodies   map[uint32][]byte
+	parities map[uint32]parityGroup
+	// recovered holds the recent recovered frames, and whether each has
+	// arrived afterwards anyway.
+	recovered  map[uint32]bool
+	parityN    int
+	recoveredN int
+	// lateN counts recovered frames that arrived afterwards anyway, when
+	// the parity frame overtook the last data frame of its group.
+	lateN int
+}
+
+type parityGroup struct {
+	k    int
+	data []byte
+}
+
+func newFECDecoder() *fecDecoder {
+	return &fecDecoder{
+		bodies:    make(map[uint32][]byte),
+		parities:  make(map[uint32]parityGroup),
+		recovered: make(map[uint32]bool),
+	}
+}
+
+// addData records the payload of frame seq. It reports whether the frame was
+// already recovered, in which case it must not be counted again, and returns
+// the frame it allowed to recover, if any.
+func (d *fecDecoder) addData(seq uint32, body []byte) (dup bool, rec *recoveredFrame) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if late, ok := d.recovered[seq]; ok {
+		if !late {
+			d.recovered[seq] = true
+			d.lateN++
+		}
+		return true, nil
+	}
+	d.bodies[seq] = body
+	if first, ok := d.group(seq); ok {
+		return false, d.tryRecover(first)
+	}
+	// the parity frame has not arrived yet, and resolves the group when it does
+	return false, nil
+}
+
+// group returns the first frame of the group protecting seq, if its parity
+// frame has arrived. The group is found from the parity frames rather than
+// from the group size, which is only known once a parity frame has arrived
+// and is smaller for the final group of a session.
+func (d *fecDecoder) group(seq uint32) (uint32, bool) {
+	for first, p := range d.parities {
+		if seq >= first && seq < first+uint32(p.k) {
+			return first, true
+		}
+	}
+	return 0, false
+}
+
+// addParity records a parity frame (header included) and returns the frame it
+// allowed to recover, if any.
+func (d *fecDecoder) addParity(b []byte) (*recoveredFrame, error) {
+	first, k, err := frame.ParseParityHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.parityN++
+	d.parities[first] = parityGroup{k, b[frame.ParityHeaderLen:]}
+	d.evict(first)
+	return d.tryRecover(first), nil
+}
+
+type recoveredFrame struct {
+	seq  uint32
+	body []byte
+}
+
+// tryRecover resolves the group starting at first once its parity is known:
+// a complete group is released, and a group missing exactly one frame is
+// repaired by XORing the parity with the frames that did arrive.
+func (d *fecDecoder) tryRecover(first uint32) *recoveredFrame {
+	p, ok := d.parities[first]
+	if !ok {
+		return nil
+	}
+	var missing []uint32
+	for seq := first; seq < first+uint32(p.k); seq++ {
+		if _, ok := d.bodies[seq]; !ok {
+			missing = append(missing, seq)
+		}
+	}
+	if len(missing) > 1 {
+		return nil
+	}
+
+	var rec *recoveredFrame
+	if len(missing) == 1 {
+		body := slices.Clone(p.data)
+		for seq := first; seq < first+uint32(p.k); seq++ {
+			frame.XOR(body, d.bodies[seq])
+		}
+		rec = &recoveredFrame{missing[0], body}
+		d.recovered[missing[0]] = false
+		d.recoveredN++
+	}
+	for seq := first; seq < first+uint32(p.k); seq++ {
+		delete(d.bodies, seq)
+	}
+	delete(d.parities, first)
+	return rec
+}
+
+// evict drops buffered state for groups that can no longer be repaired
+// because more than one of their frames never arrived: groups that end more
+// than fecHistory frames before newest, and frames before that whose parity
+// frame never arrived.
+func (d *fecDecoder) evict(newest uint32) {
+	if newest <= fecHistory {
+		return
+	}
+	cutoff := newest - fecHistory
+	for first, p := range d.parities {
+		if first+uint32(p.k) <= cutoff {
+			delete(d.parities, first)
+		}
+	}
+	for seq := range d.bodies {
+		if _, ok := d.group(seq); seq < cutoff && !ok {
+			delete(d.bodies, seq)
+		}
+	}
+	for seq := range d.recovered {
+		if seq < cutoff {
+			delete(d.recovered, seq)
+		}
+	}
+}
+
+// report logs the parity frames received and the frames recovered, and
+// returns the number of recovered frames.
+func (d *fecDecoder) report() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log.Printf("FEC: %d parity frames received, %d frames recovered (%d of them arrived later anyway)",
+		d.parityN, d.recoveredN, d.lateN)
+	return d.recoveredN
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"quic-go-rtc/frame"
+)
+
+const testBodyLen = 32
+
+// testBody returns the payload of frame seq.
+func testBody(seq uint32) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%08x", seq*2654435761)), testBodyLen/8)
+}
+
+// testParity returns the parity frame protecting frames first..first+k-1.
+func testParity(first uint32, k int) []byte {
+	p := make([]byte, frame.ParityHeaderLen+testBodyLen)
+	frame.PutParityHeader(p, first, k)
+	for seq := first; seq < first+uint32(k); seq++ {
+		frame.XOR(p[frame.ParityHeaderLen:], testBody(seq))
+	}
+	return p
+}
+
+// fecEvent is a data frame, or a parity frame when k is non-zero.
+type fecEvent struct {
+	seq uint32
+	k   int
+}
+
+func data(seqs ...uint32) []fecEvent {
+	events := make([]fecEvent, len(seqs))
+	for i, seq := range seqs {
+		events[i] = fecEvent{seq: seq}
+	}
+	return events
+}
+
+func parity(first uint32, k int) fecEvent { return fecEvent{first, k} }
+
+func join(parts ...[]fecEvent) []fecEvent {
+	var events []fecEvent
+	for _, p := range parts {
+		events = append(events, p...)
+	}
+	return events
+}
+
+// replay feeds events to a new decoder, checks every recovered payload and
+// returns the recovered sequence numbers and the duplicates reported.
+func replay(t *testing.T, events []fecEvent) (d *fecDecoder, recovered, dups []uint32) {
+	t.Helper()
+	d = newFECDecoder()
+	for _, e := range events {
+		var rec *recoveredFrame
+		if e.k > 0 {
+			var err error
+			if rec, err = d.addParity(testParity(e.seq, e.k)); err != nil {
+				t.Fatalf("parity %d: %v", e.seq, err)
+			}
+		} else {
+			var dup bool
+			if dup, rec = d.addData(e.seq, testBody(e.seq)); dup {
+				dups = append(dups, e.seq)
+			}
+		}
+		if rec != nil {
+			if !bytes.Equal(rec.body, testBody(rec.seq)) {
+				t.Errorf("frame %d recovered as %q", rec.seq, rec.body)
+			}
+			recovered = append(recovered, rec.seq)
+		}
+	}
+	return d, recovered, dups
+}
+
+func TestFECDecoder(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []fecEvent
+		recovered []uint32
+		dups      []uint32
+	}{
+		{"no loss", join(data(1, 2, 3, 4), []fecEvent{parity(1, 4)}), nil, nil},
+		{"single loss", join(data(1, 2, 4), []fecEvent{parity(1, 4)}), []uint32{3}, nil},
+		{"single loss per group", join(data(2, 3, 4), []fecEvent{parity(1, 4)}, data(5, 6, 7), []fecEvent{parity(5, 4)}), []uint32{1, 8}, nil},
+		{"two losses", join(data(1, 4), []fecEvent{parity(1, 4)}), nil, nil},
+		{"parity before data", join([]fecEvent{parity(1, 4)}, data(1, 3, 4)), []uint32{2}, nil},
+		{"parity before the last data frame", join(data(1, 2), []fecEvent{parity(1, 4)}, data(4, 3)), []uint32{3}, []uint32{3}},
+		{"final short group", join(data(1, 2, 3, 4), []fecEvent{parity(1, 4)}, data(6), []fecEvent{parity(5, 2)}), []uint32{5}, nil},
+		{"short group parity first", join([]fecEvent{parity(5, 2)}, data(5), []fecEvent{parity(1, 4)}, data(1, 2, 4)), []uint32{6, 3}, nil},
+		{"late frame arriving twice", join(data(1, 2, 3), []fecEvent{parity(1, 4)}, data(4, 4)), []uint32{4}, []uint32{4, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, recovered, dups := replay(t, tt.events)
+			if fmt.Sprint(recovered) != fmt.Sprint(tt.recovered) {
+				t.Errorf("recovered %v, want %v", recovered, tt.recovered)
+			}
+			if fmt.Sprint(dups) != fmt.Sprint(tt.dups) {
+				t.Errorf("duplicates %v, want %v", dups, tt.dups)
+			}
+			if d.recoveredN != len(tt.recovered) {
+				t.Errorf("recoveredN = %d, want %d", d.recoveredN, len(tt.recovered))
+			}
+			if late := min(len(tt.dups), len(tt.recovered)); d.lateN != late {
+				t.Errorf("lateN = %d, want %d", d.lateN, late)
+			}
+		})
+	}
+}
+
+// TestFECEvict checks that a group is kept while it ends within fecHistory
+// frames of the newest parity frame, and dropped once it does not.
+func TestFECEvict(t *testing.T) {
+	const k = 8
+	for _, tt := range []struct {
+		newest uint32
+		kept   bool
+	}{
+		{newest: 1 + k + fecHistory - 1, kept: true},
+		{newest: 1 + k + fecHistory, kept: false},
+	} {
+		d, _, _ := replay(t, join(
+			data(1, 2, 3, 4, 5, 6), []fecEvent{parity(1, k)},
+			[]fecEvent{parity(tt.newest, k)},
+		))
+		if !tt.kept {
+			for seq := range d.bodies {
+				if seq < tt.newest {
+					t.Errorf("newest parity %d: frame %d still buffered", tt.newest, seq)
+				}
+			}
+		}
+		_, rec := d.addData(7, testBody(7))
+		if got := rec != nil; got != tt.kept {
+			t.Errorf("newest parity %d: recovered %v, want the group kept %v", tt.newest, rec, tt.kept)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// injectQueueLen bounds the packets held back in each direction of an
+// injectConn; a packet that finds its queue full is dropped, like at a
+// full router queue.
+const injectQueueLen = 4096
+
+// impairment is what an injectConn adds to the client's socket: a fixed
+// delay in each direction, so the RTT grows by twice Delay, and an
+// independent loss probability per packet in each direction.
+type impairment struct {
+	Delay time.Duration
+	Loss  float64
+}
+
+func (im impairment) active() bool {
+	return im.Delay > 0 || im.Loss > 0
+}
+
+type injectPacket struct {
+	at   time.Time
+	data []byte
+	addr net.Addr
+	err  error
+}
+
+// injectConn is a test aid, not a network emulator: it delays and drops the
+// packets between the QUIC stack and the real socket, so the delay, jitter
+// and loss reporting can be exercised on a laptop without Mininet. The
+// losses are drawn from fixed seeds, so that runs make the same sequence of
+// drop decisions.
+type injectConn struct {
+	net.PacketConn
+	im  impairment
+	in  chan injectPacket
+	out chan injectPacket
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu       sync.Mutex
+	sendRand *rand.Rand
+	recvRand *rand.Rand
+	deadline time.Time
+	// deadlineSet is closed, and replaced, whenever the read deadline
+	// changes, to wake up a blocked ReadFrom
+	deadlineSet chan struct{}
+}
+
+func newInjectConn(conn net.PacketConn, im impairment) *injectConn {
+	c := &injectConn{
+		PacketConn:  conn,
+		im:          im,
+		in:          make(chan injectPacket, injectQueueLen),
+		out:         make(chan injectPacket, injectQueueLen),
+		closed:      make(chan struct{}),
+		sendRand:    rand.New(rand.NewPCG(1, 1)),
+		recvRand:    rand.New(rand.NewPCG(1, 2)),
+		deadlineSet: make(chan struct{}),
+	}
+	go c.receive()
+	go c.send()
+	return c
+}
+
+// lose reports whether the next packet drawn from r is lost.
+func (c *injectConn) lose(r *rand.Rand) bool {
+	if c.im.Loss <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return r.Float64() < c.im.Loss
+}
+
+// receive reads the real socket, queueing each packet that is not lost
+// until its delay is up.
+func (c *injectConn) receive() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err == nil && c.lose(c.recvRand) {
+			continue
+		}
+		if err != nil {
+			// a failed socket is passed on to ReadFrom, which must see it
+			select {
+			case c.in <- injectPacket{err: err}:
+			case <-c.closed:
+			}
+			return
+		}
+		select {
+		case c.in <- injectPacket{at: time.Now().Add(c.im.Delay), data: append([]byte(nil), buf[:n]...), addr: addr}:
+		default:
+		}
+	}
+}
+
+// send writes each queued packet to the real socket once its delay is up.
+func (c *injectConn) send() {
+	for {
+		select {
+		case p := <-c.out:
+			if !c.wait(p.at) {
+				return
+			}
+			c.PacketConn.WriteTo(p.data, p.addr)
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// wait sleeps until at, and reports false if the conn is closed first.
+func (c *injectConn) wait(at time.Time) bool {
+	d := time.Until(at)
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+func (c *injectConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.deadlineSet
+		c.mu.Unlock()
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+		var p injectPacket
+		var got bool
+		var err error
+		select {
+		case p = <-c.in:
+			got = true
+		case <-c.closed:
+			err = net.ErrClosed
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		switch {
+		case err != nil:
+			return 0, nil, err
+		case !got:
+			continue
+		case p.err != nil:
+			return 0, nil, p.err
+		case !c.wait(p.at):
+			return 0, nil, net.ErrClosed
+		}
+		return copy(b, p.data), p.addr, nil
+	}
+}
+
+func (c *injectConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if c.lose(c.sendRand) {
+		return len(b), nil
+	}
+	if c.im.Delay <= 0 {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	select {
+	case c.out <- injectPacket{at: time.Now().Add(c.im.Delay), data: append([]byte(nil), b...), addr: addr}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (c *injectConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.deadlineSet)
+	c.deadlineSet = make(chan struct{})
+	return nil
+}
+
+func (c *injectConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.PacketConn.SetWriteDeadline(t)
+}
+
+func (c *injectConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.PacketConn.Close()
+}
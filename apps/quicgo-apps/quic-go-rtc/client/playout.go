@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// playoutStats is the outcome of replaying the received frames through a
+// fixed-delay jitter buffer.
+type playoutStats struct {
+	BufferMs float64 `json:"buffer_ms"`
+	// Underruns counts playout slots whose frame was not available, either
+	// because it arrived after its slot (Late) or never arrived (Missing).
+	Underruns int `json:"underruns"`
+	Late      int `json:"late"`
+	Missing   int `json:"missing"`
+	// StallSeconds is the playout time spent with nothing to show.
+	StallSeconds float64 `json:"stall_s"`
+}
+
+// playout simulates a player that starts buffer after the first frame
+// arrives and then renders frames 1..expected every interval. A frame is
+// discarded if it completes after its slot.
+func (d *deliveryStats) playout(expected uint32, buffer, interval time.Duration) playoutStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := playoutStats{BufferMs: toMs(buffer)}
+	if len(d.frames) == 0 {
+		s.Underruns = int(expected)
+		s.Missing = int(expected)
+		s.StallSeconds = (time.Duration(expected) * interval).Seconds()
+		return s
+	}
+
+	// anchor the playout clock on the first frame to complete, projected
+	// back to where frame 1's slot would be
+	fin := make(map[uint32]time.Time, len(d.frames))
+	first := d.frames[0]
+	for _, f := range d.frames {
+		fin[f.seq] = f.end
+		if f.end.Before(first.end) {
+			first = f
+		}
+	}
+	start := first.end.Add(buffer - time.Duration(first.seq-1)*interval)
+
+	for seq := uint32(1); seq <= expected; seq++ {
+		slot := start.Add(time.Duration(seq-1) * interval)
+		end, ok := fin[seq]
+		switch {
+		case !ok:
+			s.Missing++
+		case end.After(slot):
+			s.Late++
+		default:
+			continue
+		}
+		s.Underruns++
+	}
+	s.StallSeconds = (time.Duration(s.Underruns) * interval).Seconds()
+	return s
+}
+
+func (s playoutStats) report() {
+	log.Printf("Jitter buffer %.0f ms: %d underruns (%d late, %d missing), stall time %.3f s",
+		s.BufferMs, s.Underruns, s.Late, s.Missing, s.StallSeconds)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"quic-go-rtc/frame"
+)
+
+// sendStamps are the send times a server running with -send-timestamps
+// stamped into the frames, by sequence number.
+type sendStamps struct {
+	mu sync.Mutex
+	at map[uint32]time.Time
+}
+
+func newSendStamps() *sendStamps {
+	return &sendStamps{at: make(map[uint32]time.Time)}
+}
+
+// note records the send time stamped into the payload of frame seq, if it
+// carries one; the zeros of an unstamped default payload read as none. A
+// nil sendStamps records nothing.
+func (s *sendStamps) note(seq uint32, payload []byte) {
+	if s == nil {
+		return
+	}
+	t, ok := frame.ParseSendTime(payload)
+	if !ok || t.UnixNano() == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.at[seq] = t
+	s.mu.Unlock()
+}
+
+// clockOffsetOf returns offset for the result when raw timestamps are
+// written, or nil.
+func clockOffsetOf(stamps *sendStamps, offset time.Duration) *time.Duration {
+	if stamps == nil {
+		return nil
+	}
+	return &offset
+}
+
+// writeRawTimestamps writes one row per frame with the raw send, accept and
+// FIN times in Unix nanoseconds, each on its own host's clock and not
+// corrected for any offset between them, and the externally measured
+// offset of the server clock from the client's. The one-way delay of a
+// frame is then fin_unix_ns - send_unix_ns + clock_offset_ns. The send
+// time is empty for a frame that carried no stamp.
+func (d *deliveryStats) writeRawTimestamps(path string, stamps *sendStamps, offset time.Duration) error {
+	d.mu.Lock()
+	frames := slices.Clone(d.frames)
+	d.mu.Unlock()
+	slices.SortFunc(frames, func(a, b frameDelivery) int { return int(a.seq) - int(b.seq) })
+	stamps.mu.Lock()
+	defer stamps.mu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "seq,send_unix_ns,accept_unix_ns,fin_unix_ns,clock_offset_ns")
+	for _, fr := range frames {
+		sent := ""
+		if t, ok := stamps.at[fr.seq]; ok {
+			sent = fmt.Sprint(t.UnixNano())
+		}
+		fmt.Fprintf(w, "%d,%s,%d,%d,%d\n", fr.seq, sent, fr.start.UnixNano(), fr.end.UnixNano(), offset.Nanoseconds())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
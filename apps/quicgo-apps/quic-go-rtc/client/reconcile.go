@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-go-rtc/frame"
+)
+
+// reconcileGrace is how long the client keeps accepting frame streams after
+// the server's end marker, counted from the last frame data, for the
+// streams still in flight behind it.
+const reconcileGrace = 500 * time.Millisecond
+
+// reconcileTimeout bounds each wait on the server during the frame count
+// check.
+const reconcileTimeout = 5 * time.Second
+
+// frameCount is the outcome of the -reconcile frame count check.
+type frameCount struct {
+	// Last is the highest frame sequence number the server generated, and
+	// Written how many of frames 1 to Last it wrote in full.
+	Last    int `json:"last"`
+	Written int `json:"written"`
+	// Received is how many distinct frames the client received, and
+	// Missing how many of those written in full never arrived.
+	Received int `json:"received"`
+	Missing  int `json:"missing"`
+}
+
+// countCheck is the client's side of the frame count check on the request
+// stream.
+type countCheck struct {
+	stream *quic.Stream
+	rd     *bufio.Reader
+	// ended is closed once the server's end marker arrives, and read once
+	// the wait for it is over, err telling why if it did not arrive
+	ended, read chan struct{}
+	err         error
+}
+
+func newCountCheck(s *quic.Stream) *countCheck {
+	c := &countCheck{stream: s, rd: bufio.NewReader(s), ended: make(chan struct{}), read: make(chan struct{})}
+	go func() {
+		defer close(c.read)
+		line, err := c.rd.ReadString('\n')
+		switch {
+		case err != nil:
+			c.err = err
+		case line != frame.SendEnd:
+			c.err = fmt.Errorf("unexpected line %q", line)
+		default:
+			close(c.ended)
+		}
+	}()
+	return c
+}
+
+// settle calls stop once the server has marked its last frame written and
+// then no frame data has arrived on w for reconcileGrace. It returns when
+// stop fires or done is closed.
+func (c *countCheck) settle(w *stallWatch, done <-chan struct{}, stop func()) {
+	select {
+	case <-c.ended:
+	case <-done:
+		return
+	}
+	end := time.Now()
+	ticker := time.NewTicker(reconcileGrace / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if time.Since(end) > reconcileGrace && w.idle() > reconcileGrace {
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// exchange waits for the server's end marker if it has not arrived yet,
+// sends the count of the distinct frames received, up to maxSeq, and
+// returns the count reconciled with the server's.
+func (c *countCheck) exchange(distinct int, maxSeq uint32) (*frameCount, error) {
+	select {
+	case <-c.read:
+	case <-time.After(reconcileTimeout):
+		return nil, errors.New("no end marker from the server")
+	}
+	if c.err != nil {
+		return nil, fmt.Errorf("no end marker from the server: %w", c.err)
+	}
+	if _, err := c.stream.Write([]byte(frame.FormatReceived(distinct, maxSeq))); err != nil {
+		return nil, err
+	}
+	c.stream.SetReadDeadline(time.Now().Add(reconcileTimeout))
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("no count from the server: %w", err)
+	}
+	c.stream.Close()
+	last, written, err := frame.ParseSent(line)
+	if err != nil {
+		return nil, err
+	}
+	return &frameCount{Last: last, Written: written, Received: distinct, Missing: max(written-distinct, 0)}, nil
+}
+
+// report logs the reconciled counts; the gaps in the frames are logged as
+// lost.
+func (n frameCount) report() {
+	if n.Missing > 0 {
+		log.Printf("Frame count MISMATCH: the server wrote %d frames in full of %d generated, %d received: %d never arrived",
+			n.Written, n.Last, n.Received, n.Missing)
+		return
+	}
+	log.Printf("Frame count check: the server wrote %d frames in full of %d generated, %d received",
+		n.Written, n.Last, n.Received)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"quic-go-common/qtrace"
+)
+
+// Result summarises an RTC client run for the -results-dir bundle.
+type Result struct {
+	Request       string          `json:"request"`
+	Frames        int             `json:"frames_received"`
+	Lost          []uint32        `json:"lost_frames,omitempty"`
+	Bytes         int             `json:"bytes"`
+	Elapsed       time.Duration   `json:"elapsed_ns"`
+	RawThroughput float64         `json:"raw_throughput_mbps"`
+	Delivery      deliverySummary `json:"delivery"`
+	// Startup and Steady split Delivery at -startup-frames.
+	Startup     *deliverySummary `json:"startup_delivery,omitempty"`
+	Steady      *deliverySummary `json:"steady_delivery,omitempty"`
+	Stalled     bool             `json:"stalled,omitempty"`
+	Termination Termination      `json:"termination"`
+	Playout     *playoutStats    `json:"playout,omitempty"`
+	Recovered   int              `json:"fec_recovered,omitempty"`
+	Corrupt     []uint32         `json:"corrupt_frames,omitempty"`
+	FrameCount  *frameCount      `json:"frame_count,omitempty"`
+	// Bitrate is set with -bitrate-window; its series also goes to
+	// bitrate.csv.
+	Bitrate *bitrateProfile `json:"bitrate,omitempty"`
+	// StreamTypes splits Delivery by the type of stream the frames came
+	// on, uni or bidi, when any came on a bidi one.
+	StreamTypes map[string]deliverySummary `json:"stream_types,omitempty"`
+	// ChunkStreams is the delivery of each chunk stream from a server
+	// running with -chunked-frame, whose frames Delivery covers from their
+	// first chunk to their last.
+	ChunkStreams *deliverySummary `json:"chunk_delivery,omitempty"`
+	// ClockOffset is the -clock-offset, set with -raw-timestamps; the raw
+	// times go to raw_timestamps.csv.
+	ClockOffset *time.Duration `json:"clock_offset_ns,omitempty"`
+	// Gaps is set with -report-gaps.
+	Gaps []gap `json:"gaps,omitempty"`
+	// Negotiated is set with -transport-params.
+	Negotiated *qtrace.Negotiated `json:"negotiated,omitempty"`
+	// Incomplete, if set, is why the result only covers part of the run.
+	Incomplete string `json:"incomplete,omitempty"`
+}
+
+// joinSeqs formats sequence numbers as a comma-separated list.
+func joinSeqs(seqs []uint32) string {
+	strs := make([]string, len(seqs))
+	for i, seq := range seqs {
+		strs[i] = strconv.Itoa(int(seq))
+	}
+	return strings.Join(strs, ",")
+}
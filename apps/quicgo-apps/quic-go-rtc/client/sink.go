@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// FrameSink consumes the bytes of received frames. Each frame arrives on its
+// own uni stream; OpenFrame is called once per frame with its sequence number
+// and the returned writer is closed after the stream has been fully read.
+type FrameSink interface {
+	OpenFrame(seq uint32) (io.WriteCloser, error)
+}
+
+// newSinks builds the sink chain from a comma-separated list of sink names.
+// The chain always counts the received bytes into counter, which the goodput
+// and the byte-count checks rely on, so the count sink may be named but is
+// never left out.
+func newSinks(names string, saveDir string, counter *int64) (FrameSink, error) {
+	sinks := teeSink{countSink{counter}}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "count":
+		case "discard":
+			sinks = append(sinks, discardSink{})
+		case "file":
+			if saveDir == "" {
+				return nil, errors.New("file sink requires -save-dir")
+			}
+			if err := os.MkdirAll(saveDir, 0o755); err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink{saveDir})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// teeSink fans each frame out to every sink it holds.
+type teeSink []FrameSink
+
+func (t teeSink) OpenFrame(seq uint32) (io.WriteCloser, error) {
+	ws := make(multiWriteCloser, 0, len(t))
+	for _, s := range t {
+		w, err := s.OpenFrame(seq)
+		if err != nil {
+			ws.Close()
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+type multiWriteCloser []io.WriteCloser
+
+func (m multiWriteCloser) Write(p []byte) (int, error) {
+	for _, w := range m {
+		if _, err := w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (m multiWriteCloser) Close() error {
+	var errs []error
+	for _, w := range m {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// countSink adds the received bytes to a shared counter.
+type countSink struct {
+	total *int64
+}
+
+func (c countSink) OpenFrame(uint32) (io.WriteCloser, error) {
+	return countWriter(c), nil
+}
+
+type countWriter countSink
+
+func (c countWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.total, int64(len(p)))
+	return len(p), nil
+}
+
+func (countWriter) Close() error { return nil }
+
+// discardSink drops the received bytes.
+type discardSink struct{}
+
+func (discardSink) OpenFrame(uint32) (io.WriteCloser, error) {
+	return nopWriteCloser{io.Discard}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// fileSink writes each frame to its own file in dir, named by sequence number.
+type fileSink struct {
+	dir string
+}
+
+func (f fileSink) OpenFrame(seq uint32) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(f.dir, fmt.Sprintf("frame_%d.bin", seq)))
+}
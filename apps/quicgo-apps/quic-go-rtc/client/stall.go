@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// stallWatch records when data last arrived on any frame stream. A
+// connection that never delivers a byte stalls too, counted from start.
+type stallWatch struct {
+	// since is when the watch started and last when data last arrived, in
+	// unix nanoseconds; last is zero until the first byte
+	since, last atomic.Int64
+}
+
+// start marks the connection established, for the idle time before the
+// first byte.
+func (w *stallWatch) start() {
+	w.since.Store(time.Now().UnixNano())
+}
+
+func (w *stallWatch) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+// idle returns how long no data has arrived, since start before the first
+// byte, or zero if the watch was never started and no data arrived.
+func (w *stallWatch) idle() time.Duration {
+	last := w.last.Load()
+	if last == 0 {
+		last = w.since.Load()
+	}
+	if last == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+// watch calls onStall once data has been idle for longer than timeout. It
+// returns when onStall fires or done is closed.
+func (w *stallWatch) watch(timeout time.Duration, done <-chan struct{}, onStall func()) {
+	ticker := time.NewTicker(max(timeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if w.idle() > timeout {
+				onStall()
+				return
+			}
+		}
+	}
+}
+
+// awaitFirst calls onNone if no data has arrived within grace. It returns
+// when onNone fires, data arrives or done is closed.
+func (w *stallWatch) awaitFirst(grace time.Duration, done <-chan struct{}, onNone func()) {
+	ticker := time.NewTicker(max(grace/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	deadline := time.Now().Add(grace)
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if w.last.Load() != 0 {
+				return
+			}
+			if !now.Before(deadline) {
+				onNone()
+				return
+			}
+		}
+	}
+}
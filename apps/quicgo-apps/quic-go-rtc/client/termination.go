@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-go-rtc/frame"
+)
+
+// Termination kinds. Individual frame streams may still be reset; these
+// describe how the session as a whole ended.
+const (
+	termComplete       = "complete"
+	termSendEnd        = "send_end"
+	termAppClose       = "app_close"
+	termIdleTimeout    = "idle_timeout"
+	termClientTimeout  = "client_timeout"
+	termTransportError = "transport_error"
+	termStatelessReset = "stateless_reset"
+	termError          = "error"
+	termPanic          = "panic"
+	termNoData         = "no_data"
+	termRejected       = "rejected"
+)
+
+// Termination records how the session ended.
+type Termination struct {
+	Kind string `json:"kind"`
+	// Code is the application or transport error code, for the kinds that
+	// carry one.
+	Code uint64 `json:"code,omitempty"`
+	// Remote is set when the server, not the client, closed the connection.
+	Remote bool `json:"remote,omitempty"`
+}
+
+// terminationOf classifies the error that ended the accept loop: complete
+// means every requested frame arrived, sendEnd that the frames behind the
+// server's -reconcile end marker did, stalled that the client aborted after
+// -stall-timeout and noData that it aborted after -zero-grace.
+func terminationOf(err error, complete, sendEnd, stalled, noData bool) Termination {
+	var appErr *quic.ApplicationError
+	var idleErr *quic.IdleTimeoutError
+	var transportErr *quic.TransportError
+	var resetErr *quic.StatelessResetError
+	switch {
+	case stalled:
+		return Termination{Kind: termClientTimeout}
+	case noData:
+		return Termination{Kind: termNoData}
+	case complete:
+		return Termination{Kind: termComplete}
+	case sendEnd:
+		return Termination{Kind: termSendEnd, Remote: true}
+	case errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == frame.RequestRejected:
+		return Termination{Kind: termRejected, Code: uint64(appErr.ErrorCode), Remote: true}
+	case errors.As(err, &appErr):
+		return Termination{Kind: termAppClose, Code: uint64(appErr.ErrorCode), Remote: appErr.Remote}
+	case errors.As(err, &idleErr):
+		return Termination{Kind: termIdleTimeout}
+	case errors.As(err, &resetErr):
+		return Termination{Kind: termStatelessReset, Remote: true}
+	case errors.As(err, &transportErr):
+		return Termination{Kind: termTransportError, Code: uint64(transportErr.ErrorCode), Remote: transportErr.Remote}
+	default:
+		return Termination{Kind: termError}
+	}
+}
+
+func (t Termination) String() string {
+	by := "client"
+	if t.Remote {
+		by = "server"
+	}
+	switch t.Kind {
+	case termComplete:
+		return "all requested frames arrived"
+	case termSendEnd:
+		return "server marked its last frame written"
+	case termAppClose:
+		return fmt.Sprintf("%s closed the connection with application error 0x%x", by, t.Code)
+	case termIdleTimeout:
+		return "connection idle timeout"
+	case termClientTimeout:
+		return "client-side stall timeout"
+	case termTransportError:
+		return fmt.Sprintf("%s closed the connection with transport error 0x%x", by, t.Code)
+	case termStatelessReset:
+		return "stateless reset by the server"
+	case termRejected:
+		return "server rejected the request"
+	case termNoData:
+		return "client-side abort, no data within -zero-grace"
+	case termPanic:
+		return "panic, partial stats"
+	default:
+		return "unclassified error"
+	}
+}
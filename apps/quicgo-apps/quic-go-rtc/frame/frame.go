@@ -0,0 +1,202 @@
+// Package frame defines the header carried at the start of every RTC frame
+// stream, shared by the server and the client.
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// HeaderLen is the number of header bytes at the start of each frame. The
+// header counts towards the configured frame size.
+const HeaderLen = 4
+
+// ParityFlag is set in the sequence field of FEC parity frames. The rest of
+// the field holds the sequence number of the first frame in the protected
+// group.
+const ParityFlag uint32 = 1 << 31
+
+// ParityHeaderLen is the header length of a parity frame: the frame header
+// followed by the group size. The XOR of the group's frame payloads (the
+// bytes after HeaderLen) follows.
+const ParityHeaderLen = HeaderLen + 2
+
+// PutHeader writes the header for frame seq into the start of b.
+func PutHeader(b []byte, seq uint32) {
+	binary.BigEndian.PutUint32(b, seq)
+}
+
+// ParseHeader returns the sequence number from a frame header.
+func ParseHeader(b []byte) (uint32, error) {
+	if len(b) < HeaderLen {
+		return 0, fmt.Errorf("short frame header: %d bytes", len(b))
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// IsParity reports whether a parsed sequence field belongs to a parity frame.
+func IsParity(seq uint32) bool {
+	return seq&ParityFlag != 0
+}
+
+// PutParityHeader writes the header of the parity frame protecting frames
+// first..first+k-1 into the start of b.
+func PutParityHeader(b []byte, first uint32, k int) {
+	binary.BigEndian.PutUint32(b, first|ParityFlag)
+	binary.BigEndian.PutUint16(b[HeaderLen:], uint16(k))
+}
+
+// ParseParityHeader returns the protected group from a parity frame header.
+func ParseParityHeader(b []byte) (first uint32, k int, err error) {
+	if len(b) < ParityHeaderLen {
+		return 0, 0, fmt.Errorf("short parity header: %d bytes", len(b))
+	}
+	seq := binary.BigEndian.Uint32(b)
+	if !IsParity(seq) {
+		return 0, 0, fmt.Errorf("frame %d is not a parity frame", seq)
+	}
+	return seq &^ ParityFlag, int(binary.BigEndian.Uint16(b[HeaderLen:])), nil
+}
+
+// ChunkFlag is set in the sequence field of the chunks a frame is split
+// into, each sent on its own stream, when the server runs with
+// -chunked-frame. The rest of the field holds the frame's sequence number.
+const ChunkFlag uint32 = 1 << 30
+
+// ChunkHeaderLen is the header length of a chunk: the frame header followed
+// by the chunk's index and the frame's chunk count. The chunk's share of
+// the frame payload follows.
+const ChunkHeaderLen = HeaderLen + 4
+
+// IsChunk reports whether a parsed sequence field belongs to a chunk.
+func IsChunk(seq uint32) bool {
+	return seq&ParityFlag == 0 && seq&ChunkFlag != 0
+}
+
+// SplitFrame splits frame f, header included, into count chunks of nearly
+// equal payload, each with its chunk header. A payload shorter than count
+// leaves the last chunks empty.
+func SplitFrame(f []byte, count int) [][]byte {
+	seq := binary.BigEndian.Uint32(f)
+	payload := f[HeaderLen:]
+	part := (len(payload) + count - 1) / count
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		lo := min(i*part, len(payload))
+		hi := min(lo+part, len(payload))
+		c := make([]byte, ChunkHeaderLen+hi-lo)
+		binary.BigEndian.PutUint32(c, seq|ChunkFlag)
+		binary.BigEndian.PutUint16(c[HeaderLen:], uint16(i))
+		binary.BigEndian.PutUint16(c[HeaderLen+2:], uint16(count))
+		copy(c[ChunkHeaderLen:], payload[lo:hi])
+		chunks[i] = c
+	}
+	return chunks
+}
+
+// ParseChunkHeader returns the frame, the chunk's index and the frame's
+// chunk count from a chunk header.
+func ParseChunkHeader(b []byte) (seq uint32, index, count int, err error) {
+	if len(b) < ChunkHeaderLen {
+		return 0, 0, 0, fmt.Errorf("short chunk header: %d bytes", len(b))
+	}
+	seq = binary.BigEndian.Uint32(b)
+	if !IsChunk(seq) {
+		return 0, 0, 0, fmt.Errorf("frame %d is not a chunk", seq)
+	}
+	index, count = int(binary.BigEndian.Uint16(b[HeaderLen:])), int(binary.BigEndian.Uint16(b[HeaderLen+2:]))
+	if index >= count {
+		return 0, 0, 0, fmt.Errorf("chunk %d of frame %d out of its %d chunks", index, seq&^ChunkFlag, count)
+	}
+	return seq &^ ChunkFlag, index, count, nil
+}
+
+// RequestRejected is the application error code the server closes the
+// connection with when it rejects the client's request as malformed,
+// unknown or over its limits.
+const RequestRejected = 0x1
+
+// AckLen is the size of a frame acknowledgement on the control stream: the
+// frame's sequence number followed by the client's receive time in Unix
+// nanoseconds.
+const AckLen = 12
+
+// PutAck writes the acknowledgement of frame seq, received at recv, into b.
+func PutAck(b []byte, seq uint32, recv time.Time) {
+	binary.BigEndian.PutUint32(b, seq)
+	binary.BigEndian.PutUint64(b[4:], uint64(recv.UnixNano()))
+}
+
+// ParseAck returns the frame and receive time from an acknowledgement.
+func ParseAck(b []byte) (uint32, time.Time, error) {
+	if len(b) < AckLen {
+		return 0, time.Time{}, fmt.Errorf("short ack: %d bytes", len(b))
+	}
+	return binary.BigEndian.Uint32(b), time.Unix(0, int64(binary.BigEndian.Uint64(b[4:]))), nil
+}
+
+// ReconcileOption, appended to a GETN or GETT request line, asks for the
+// frame count check on the request stream at the end of the session: once
+// its last frame is written the server sends the SendEnd line, the client
+// answers with a FormatReceived line of what it got, and the server replies
+// with a FormatSent line of what it sent.
+const ReconcileOption = "reconcile"
+
+// SendEnd is the line the server sends once it has written its last frame.
+const SendEnd = "END\n"
+
+// FormatReceived returns the client's count line: how many distinct frame
+// sequence numbers it received, and the highest of them.
+func FormatReceived(distinct int, maxSeq uint32) string {
+	return fmt.Sprintf("RECEIVED %d %d\n", distinct, maxSeq)
+}
+
+// ParseReceived parses a FormatReceived line.
+func ParseReceived(line string) (distinct int, maxSeq uint32, err error) {
+	if _, err := fmt.Sscanf(line, "RECEIVED %d %d\n", &distinct, &maxSeq); err != nil {
+		return 0, 0, fmt.Errorf("invalid count line %q: %w", line, err)
+	}
+	return distinct, maxSeq, nil
+}
+
+// FormatSent returns the server's count line: the highest frame sequence
+// number it generated, and how many of frames 1 to last it wrote in full;
+// the rest it dropped, shed or failed to send.
+func FormatSent(last, written int) string {
+	return fmt.Sprintf("SENT %d %d\n", last, written)
+}
+
+// ParseSent parses a FormatSent line.
+func ParseSent(line string) (last, written int, err error) {
+	if _, err := fmt.Sscanf(line, "SENT %d %d\n", &last, &written); err != nil {
+		return 0, 0, fmt.Errorf("invalid count line %q: %w", line, err)
+	}
+	return last, written, nil
+}
+
+// SendTimeLen is the number of payload bytes a server running with
+// -send-timestamps stamps with the frame's send time, in Unix nanoseconds.
+const SendTimeLen = 8
+
+// PutSendTime stamps the start of payload, the bytes after the header, with
+// the send time t.
+func PutSendTime(payload []byte, t time.Time) {
+	binary.BigEndian.PutUint64(payload, uint64(t.UnixNano()))
+}
+
+// ParseSendTime returns the send time stamped at the start of payload, or
+// false if the payload is too short to carry one.
+func ParseSendTime(payload []byte) (time.Time, bool) {
+	if len(payload) < SendTimeLen {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(payload))), true
+}
+
+// XOR folds src into dst byte by byte.
+func XOR(dst, src []byte) {
+	for i := range min(len(dst), len(src)) {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,64 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+)
+
+// The payload patterns a server can send: PatternEntropy follows -entropy,
+// PatternSignature stamps each frame with its offsets (see FillSignature).
+const (
+	PatternEntropy   = "entropy"
+	PatternSignature = "signature"
+)
+
+// signatureCell is the length of one stamp of FillSignature.
+const signatureCell = 16
+
+// FillSignature fills the payload b of frame seq with 16-byte cells
+// stamping the frame and the offset of the cell in its payload, in hex, as
+// in "0000002a+0001f0\n". In a hex dump of a decrypted packet capture the
+// frame and payload boundaries of each packet then read off directly, and
+// reordered or duplicated data shows up as stamps out of sequence. Offsets
+// wrap past 16 MB.
+func FillSignature(b []byte, seq uint32) {
+	const hex = "0123456789abcdef"
+	var cell [signatureCell]byte
+	for i, v := 7, seq; i >= 0; i-- {
+		cell[i] = hex[v&0xf]
+		v >>= 4
+	}
+	cell[8] = '+'
+	cell[signatureCell-1] = '\n'
+	for off := 0; off < len(b); off += signatureCell {
+		for i, v := signatureCell-2, off; i > 8; i-- {
+			cell[i] = hex[v&0xf]
+			v >>= 4
+		}
+		copy(b[off:], cell[:])
+	}
+}
+
+// seededStream is the PCG stream FillSeeded draws from. Any fixed value
+// works as long as server and client agree on it.
+const seededStream = 0x7274632d66726d65
+
+// FillSeeded fills b with pseudo-random bytes determined by seq alone, so a
+// receiver can regenerate the payload of any frame and check it exactly. A
+// shorter b receives a prefix of the bytes a longer one would.
+func FillSeeded(b []byte, seq uint32) {
+	rng := rand.New(rand.NewPCG(uint64(seq), seededStream))
+	var word [8]byte
+	for i := 0; i < len(b); i += len(word) {
+		binary.LittleEndian.PutUint64(word[:], rng.Uint64())
+		copy(b[i:], word[:])
+	}
+}
+
+// CheckSeeded reports whether b is the payload FillSeeded writes for seq.
+func CheckSeeded(b []byte, seq uint32) bool {
+	want := make([]byte, len(b))
+	FillSeeded(want, seq)
+	return bytes.Equal(b, want)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"quic-go-rtc/frame"
+)
+
+// abrController adapts the frame size between frames to keep the per-frame
+// RTT reported over the ACK back-channel under a target, the way a
+// congestion-aware encoder adapts its bitrate. It backs off
+// multiplicatively when the target is exceeded and probes upwards slowly
+// otherwise.
+type abrController struct {
+	target   time.Duration
+	min, max float64 // bits per second
+	rate     float64
+	fps      float64
+	seen     int
+}
+
+const (
+	abrDecrease = 0.85
+	abrIncrease = 1.05
+)
+
+func newABRController(target time.Duration, minMbps, maxMbps float64, frameSize int) *abrController {
+	fps := float64(time.Second) / float64(FRAME_INTERVAL)
+	c := &abrController{target: target, min: minMbps * 1e6, max: maxMbps * 1e6, fps: fps}
+	c.rate = min(max(float64(frameSize)*8*fps, c.min), c.max)
+	return c
+}
+
+// update adjusts the rate from the RTT samples acks has collected since the
+// last call. Without new samples the rate is held.
+func (c *abrController) update(acks *ackTracker, seq int) {
+	samples := acks.since(c.seen)
+	if len(samples) == 0 {
+		return
+	}
+	c.seen += len(samples)
+
+	worst := slices.Max(samples)
+	prev := c.rate
+	if worst > c.target {
+		c.rate = max(c.rate*abrDecrease, c.min)
+	} else {
+		c.rate = min(c.rate*abrIncrease, c.max)
+	}
+	if c.rate != prev {
+		log.Printf("ABR frame %d: %.2f Mbps, frame size %d B (worst RTT %.2f ms, target %.2f ms)",
+			seq, c.rate/1e6, c.frameSize(), toMs(worst), toMs(c.target))
+	}
+}
+
+// frameSize returns the frame size for the current rate.
+func (c *abrController) frameSize() int {
+	return max(int(c.rate/8/c.fps), frame.HeaderLen)
+}
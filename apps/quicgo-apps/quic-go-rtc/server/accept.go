@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// acceptedSession is a session taken off the listener by an accept worker.
+type acceptedSession struct {
+	session *quic.Conn
+	at      time.Time
+}
+
+// acceptSessions runs workers goroutines that accept sessions from listener
+// into the returned queue, which holds up to workers sessions. The queue is
+// closed once the listener is.
+func acceptSessions(listener *quic.Listener, workers int) <-chan acceptedSession {
+	queue := make(chan acceptedSession, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				session, err := listener.Accept(context.Background())
+				if errors.Is(err, quic.ErrServerClosed) {
+					return
+				}
+				if err != nil {
+					log.Println("Accept session error:", err)
+					continue
+				}
+				queue <- acceptedSession{session, time.Now()}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(queue)
+	}()
+	return queue
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-go-rtc/frame"
+)
+
+// ackTracker matches the per-frame acknowledgements a client sends with
+// -ack-frames against the frames' send times. The round trip covers the
+// frame's delivery plus the ACK's way back, and needs no clock sync.
+type ackTracker struct {
+	mu     sync.Mutex
+	sent   map[uint32]time.Time
+	rtts   []time.Duration
+	active bool
+	notify chan struct{}
+	// onAck, if set before serve starts, is called with each acknowledged
+	// frame
+	onAck func(seq uint32)
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{
+		sent:   make(map[uint32]time.Time),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// markSent records when frame seq was handed to its stream.
+func (a *ackTracker) markSent(seq uint32, t time.Time) {
+	a.mu.Lock()
+	a.sent[seq] = t
+	a.mu.Unlock()
+}
+
+// serve reads acknowledgements from the client's control stream until it
+// is closed.
+func (a *ackTracker) serve(s *quic.Stream) {
+	a.mu.Lock()
+	a.active = true
+	a.mu.Unlock()
+
+	buf := make([]byte, frame.AckLen)
+	for {
+		if _, err := io.ReadFull(s, buf); err != nil {
+			return
+		}
+		now := time.Now()
+		seq, recv, _ := frame.ParseAck(buf)
+
+		a.mu.Lock()
+		sent, ok := a.sent[seq]
+		if ok {
+			a.rtts = append(a.rtts, now.Sub(sent))
+		}
+		a.mu.Unlock()
+		if !ok {
+			log.Printf("ACK for unknown frame %d", seq)
+			continue
+		}
+		log.Printf("Frame %d RTT: %.3f ms (client recv time %.6f)", seq, toMs(now.Sub(sent)), float64(recv.UnixNano())/1e9)
+		if a.onAck != nil {
+			a.onAck(seq)
+		}
+
+		select {
+		case a.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// enabled reports whether the client is acknowledging frames.
+func (a *ackTracker) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active
+}
+
+// since returns the RTT samples collected after the first n.
+func (a *ackTracker) since(n int) []time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n >= len(a.rtts) {
+		return nil
+	}
+	return slices.Clone(a.rtts[n:])
+}
+
+// wait blocks until every sent frame has been acknowledged or timeout
+// passes without a new acknowledgement. It returns immediately if the
+// client did not ask for acknowledgements.
+func (a *ackTracker) wait(timeout time.Duration) {
+	for {
+		a.mu.Lock()
+		done := !a.active || len(a.rtts) >= len(a.sent)
+		a.mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case <-a.notify:
+		case <-time.After(timeout):
+			return
+		}
+	}
+}
+
+// report logs the RTT-per-frame distribution.
+func (a *ackTracker) report() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.active {
+		return
+	}
+	if len(a.rtts) == 0 {
+		log.Printf("No frame ACKs received")
+		return
+	}
+	rtts := slices.Clone(a.rtts)
+	slices.Sort(rtts)
+	var sum time.Duration
+	for _, r := range rtts {
+		sum += r
+	}
+	log.Printf("Frame RTT (send to ACK) over %d of %d frames: min %.2f ms, avg %.2f ms, p50 %.2f ms, p95 %.2f ms, max %.2f ms",
+		len(rtts), len(a.sent), toMs(rtts[0]), toMs(sum/time.Duration(len(rtts))), toMs(percentile(rtts, 0.50)), toMs(percentile(rtts, 0.95)), toMs(rtts[len(rtts)-1]))
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func setAffinity([]int) error {
+	return errors.New("CPU affinity is only supported on Linux")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// backlogDropCode is the stream error code of a frame shed from a full
+// backlog.
+const backlogDropCode quic.StreamErrorCode = 0x6
+
+// backlog bounds how many frames are being sent at once. When a new frame
+// finds it full, the oldest frame still in flight is abandoned to make room:
+// for live video a stale frame is worth less than a fresh one, and without
+// the bound a slow network lets frame streams pile up without limit.
+type backlog struct {
+	limit int
+
+	mu     sync.Mutex
+	order  []uint32 // in flight, oldest first
+	frames map[uint32]*pendingFrame
+	shed   int
+}
+
+// pendingFrame is a frame in the backlog. cancel aborts a stream open that
+// is still waiting for the client's stream limit.
+type pendingFrame struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stream  frameSendStream
+	dropped bool
+}
+
+func newBacklog(limit int) *backlog {
+	return &backlog{limit: limit, frames: make(map[uint32]*pendingFrame)}
+}
+
+// add admits frame seq, shedding the oldest frames in flight if the backlog
+// is full.
+func (b *backlog) add(seq uint32) *pendingFrame {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pendingFrame{ctx: ctx, cancel: cancel}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.order) >= b.limit {
+		oldest := b.order[0]
+		b.order = b.order[1:]
+		old := b.frames[oldest]
+		delete(b.frames, oldest)
+		old.dropped = true
+		old.cancel()
+		if old.stream != nil {
+			old.stream.CancelWrite(backlogDropCode)
+		}
+		b.shed++
+		log.Printf("Backlog of %d frames full, shed frame %d (drop-oldest)", b.limit, oldest)
+	}
+	b.order = append(b.order, seq)
+	b.frames[seq] = p
+	return p
+}
+
+// opened records the stream frame p is sent on. It reports false if p was
+// shed meanwhile, in which case the stream has been reset.
+func (b *backlog) opened(p *pendingFrame, s frameSendStream) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.dropped {
+		s.CancelWrite(backlogDropCode)
+		return false
+	}
+	p.stream = s
+	return true
+}
+
+// isDropped reports whether frame p was shed.
+func (b *backlog) isDropped(p *pendingFrame) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return p.dropped
+}
+
+// done removes frame seq once its stream is fully written.
+func (b *backlog) done(seq uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.frames[seq]
+	if !ok {
+		return
+	}
+	p.cancel()
+	delete(b.frames, seq)
+	for i, s := range b.order {
+		if s == seq {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// shedCount returns how many frames were dropped from the backlog.
+func (b *backlog) shedCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.shed
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"math"
+	mrand "math/rand/v2"
+
+	"quic-go-rtc/frame"
+)
+
+// The -frame-size-dist values: how a jittered frame size is drawn around
+// the mean.
+const (
+	sizeDistUniform = "uniform"
+	sizeDistNormal  = "normal"
+)
+
+// sizeJitter draws each frame's size around a mean, like an encoder whose
+// output varies frame to frame at a nominal bitrate, and keeps the moments
+// of the sizes sent. A uniform draw is within ±jitter of the mean; a
+// normal one has jitter times the mean as its standard deviation. Sizes
+// never fall below the frame header.
+type sizeJitter struct {
+	jitter float64
+	normal bool
+	rng    *mrand.Rand
+
+	n          int
+	sum, sumSq float64
+}
+
+// newSizeJitter returns the generator for one session, or nil for constant
+// frames. Every session draws the same sizes for a given seed.
+func newSizeJitter(jitter float64, dist string, seed uint64) *sizeJitter {
+	if jitter == 0 {
+		return nil
+	}
+	return &sizeJitter{
+		jitter: jitter,
+		normal: dist == sizeDistNormal,
+		rng:    mrand.New(mrand.NewPCG(seed, 0)),
+	}
+}
+
+// draw returns the size of the next frame, mean itself for constant frames.
+func (j *sizeJitter) draw(mean int) int {
+	if j == nil {
+		return mean
+	}
+	dev := 2*j.rng.Float64() - 1
+	if j.normal {
+		dev = j.rng.NormFloat64()
+	}
+	return max(frame.HeaderLen, int(math.Round(float64(mean)*(1+j.jitter*dev))))
+}
+
+// sent counts a frame of size bytes that was sent.
+func (j *sizeJitter) sent(size int) {
+	if j == nil {
+		return
+	}
+	j.n++
+	j.sum += float64(size)
+	j.sumSq += float64(size) * float64(size)
+}
+
+// report logs the mean and standard deviation of the sizes sent.
+func (j *sizeJitter) report() {
+	if j == nil || j.n == 0 {
+		return
+	}
+	avg := j.sum / float64(j.n)
+	stddev := math.Sqrt(max(0, j.sumSq/float64(j.n)-avg*avg))
+	dist := sizeDistUniform
+	if j.normal {
+		dist = sizeDistNormal
+	}
+	log.Printf("Frame sizes over %d frames: mean %.0f B, stddev %.0f B (%s jitter of %.0f%%)",
+		j.n, avg, stddev, dist, j.jitter*100)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat logs, every interval until stop is closed, how many bytes of
+// peer's transfer have been written so far and the rate since the last beat.
+func heartbeat(peer string, interval time.Duration, total *int64, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	last, lastAt := int64(0), start
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			sent := atomic.LoadInt64(total)
+			mbps := float64(sent-last) * 8.0 / 1e6 / now.Sub(lastAt).Seconds()
+			log.Printf("Heartbeat %s: %s sent in %.1f s, %.2f Mbps over the last %.1f s",
+				peer, printBytes(int(sent)), now.Sub(start).Seconds(), mbps, now.Sub(lastAt).Seconds())
+			last, lastAt = sent, now
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// The -inflight-policy values: what a frame that finds -max-inflight frames
+// outstanding does.
+const (
+	inflightDelay = "delay"
+	inflightDrop  = "drop"
+)
+
+// inflightLimiter caps how many frames are outstanding at once, like a
+// sender whose encoder output queue holds at most that many frames. A frame
+// is outstanding from before its stream is opened until the client
+// acknowledges it, for a client sending -ack-frames, or else until its
+// stream is fully written. Parity frames are never acknowledged, so they
+// count until they are written.
+type inflightLimiter struct {
+	limit int
+	drop  bool
+	slots chan struct{}
+
+	mu sync.Mutex
+	// held are the written frames waiting for their ACK; early are the
+	// ones whose ACK came before the sender saw the write complete
+	held    map[uint32]bool
+	early   map[uint32]bool
+	waits   int
+	blocked time.Duration
+	dropped int
+}
+
+func newInflightLimiter(limit int, policy string) *inflightLimiter {
+	return &inflightLimiter{
+		limit: limit,
+		drop:  policy == inflightDrop,
+		slots: make(chan struct{}, limit),
+		held:  make(map[uint32]bool),
+		early: make(map[uint32]bool),
+	}
+}
+
+// acquire takes a slot for a frame. At the cap, the delay policy waits for
+// a slot, or for ctx to end, while the drop policy gives up right away. It
+// reports whether the frame got a slot.
+func (l *inflightLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.drop {
+		l.mu.Lock()
+		l.dropped++
+		l.mu.Unlock()
+		return false
+	}
+	start := time.Now()
+	var ok bool
+	select {
+	case l.slots <- struct{}{}:
+		ok = true
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	l.waits++
+	l.blocked += time.Since(start)
+	l.mu.Unlock()
+	return ok
+}
+
+// done hands back the slot of frame seq once its stream is finished with,
+// unless await is set, in which case the slot is held until the frame's
+// ACK arrives.
+func (l *inflightLimiter) done(seq uint32, await bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if await && !l.early[seq] {
+		l.held[seq] = true
+		return
+	}
+	delete(l.early, seq)
+	<-l.slots
+}
+
+// acked hands back the slot of frame seq on its ACK.
+func (l *inflightLimiter) acked(seq uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held[seq] {
+		l.early[seq] = true
+		return
+	}
+	delete(l.held, seq)
+	<-l.slots
+}
+
+// report logs how often the cap was hit and what it cost.
+func (l *inflightLimiter) report(frames int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.drop:
+		log.Printf("In-flight cap of %d frames (drop): dropped %d of %d frames at the cap", l.limit, l.dropped, frames)
+	case l.waits > 0:
+		log.Printf("In-flight cap of %d frames (delay): %d frames waited, blocked %.2f ms in total", l.limit, l.waits, toMs(l.blocked))
+	default:
+		log.Printf("In-flight cap of %d frames (delay): never reached", l.limit)
+	}
+}
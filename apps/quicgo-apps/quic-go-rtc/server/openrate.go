@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// openLimiter spaces the server's stream opens at least 1/rate apart, a
+// token bucket holding a single token, independently of how the frames
+// are generated. Frames sent in a burst then queue up for their streams.
+type openLimiter struct {
+	rate     float64
+	interval time.Duration
+
+	mu          sync.Mutex
+	next        time.Time
+	first, last time.Time
+	opens       int
+	waits       int
+	waited      time.Duration
+}
+
+func newOpenLimiter(rate float64) *openLimiter {
+	return &openLimiter{rate: rate, interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// reserve books the next stream-open slot and returns when it is due.
+// Booking each frame's slot as it is generated keeps the opens in frame
+// order.
+func (l *openLimiter) reserve() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at := time.Now()
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	return at
+}
+
+// wait blocks until the slot at, or until either the frame's ctx or the
+// connection's ends. It reports whether the open may go ahead.
+func (l *openLimiter) wait(at time.Time, ctx, conn context.Context) bool {
+	d := time.Until(at)
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	start := time.Now()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return false
+	case <-conn.Done():
+		return false
+	}
+	l.mu.Lock()
+	l.waits++
+	l.waited += time.Since(start)
+	l.mu.Unlock()
+	return true
+}
+
+// opened records a stream open.
+func (l *openLimiter) opened() {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opens == 0 {
+		l.first = now
+	}
+	l.last = now
+	l.opens++
+}
+
+// report logs the stream-open rate achieved against the limit.
+func (l *openLimiter) report() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opens < 2 {
+		log.Printf("Stream opens: %d (limit %.1f/s)", l.opens, l.rate)
+		return
+	}
+	span := l.last.Sub(l.first)
+	log.Printf("Stream opens: %d in %.3f s, %.1f/s achieved (limit %.1f/s); %d opens waited %.2f ms in total",
+		l.opens, span.Seconds(), float64(l.opens-1)/span.Seconds(), l.rate, l.waits, toMs(l.waited))
+}
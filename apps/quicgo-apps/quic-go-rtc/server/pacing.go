@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"time"
+)
+
+// coarseTimers is set where time.Sleep is known to overshoot by several
+// milliseconds, enough to throw off 30fps pacing; -precise-pacing defaults
+// to it.
+var coarseTimers = runtime.GOOS == "windows"
+
+// spinMargin is how long before a deadline the precise pacer stops sleeping
+// and spins instead.
+const spinMargin = 2 * time.Millisecond
+
+// pacer spaces bursts of frames one interval apart and measures how far the
+// gaps between burst starts stray from it. By default it sleeps for the
+// interval after each burst, as the server always has. A precise pacer
+// instead waits for absolute deadlines, so time spent sending is not added
+// to the gap, and spins through the last spinMargin of each wait to
+// sidestep coarse sleep resolution.
+type pacer struct {
+	interval time.Duration
+	precise  bool
+
+	next  time.Time
+	last  time.Time
+	gaps  int
+	total time.Duration
+	worst time.Duration
+}
+
+func newPacer(interval time.Duration, precise bool, start time.Time) *pacer {
+	return &pacer{interval: interval, precise: precise, next: start}
+}
+
+// mark records the start of a burst.
+func (p *pacer) mark() {
+	now := time.Now()
+	if !p.last.IsZero() {
+		e := now.Sub(p.last) - p.interval
+		if e < 0 {
+			e = -e
+		}
+		p.gaps++
+		p.total += e
+		p.worst = max(p.worst, e)
+	}
+	p.last = now
+}
+
+// restart starts the schedule afresh from now, after frames sent
+// back-to-back that are not timed, such as an initial burst.
+func (p *pacer) restart() {
+	p.next = time.Now()
+	p.last = time.Time{}
+}
+
+// wait blocks until the next burst is due.
+func (p *pacer) wait() {
+	if !p.precise {
+		time.Sleep(p.interval)
+		return
+	}
+	p.next = p.next.Add(p.interval)
+	// a sender more than an interval behind skips the missed deadlines
+	// rather than bursting to catch up
+	if now := time.Now(); now.Sub(p.next) > p.interval {
+		p.next = now
+	}
+	if d := time.Until(p.next) - spinMargin; d > 0 {
+		time.Sleep(d)
+	}
+	for time.Now().Before(p.next) {
+		runtime.Gosched()
+	}
+}
+
+// report logs the mean and worst inter-frame timing error.
+func (p *pacer) report() {
+	if p.gaps == 0 {
+		return
+	}
+	mode := "sleep"
+	if p.precise {
+		mode = "precise"
+	}
+	log.Printf("Inter-frame timing error over %d gaps of %v: mean %.3f ms, max %.3f ms (%s pacing)",
+		p.gaps, p.interval, toMs(p.total/time.Duration(p.gaps)), toMs(p.worst), mode)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-go-rtc/frame"
+)
+
+// reconcileTimeout bounds how long the server waits for a -reconcile
+// client's count of the frames it received after the end marker.
+const reconcileTimeout = 5 * time.Second
+
+// reconcile runs the server's side of the frame count check on the request
+// stream s, once the last of frames 1 to last is written, written of them
+// in full: it marks the end of the frames, reads how many distinct frames
+// the client received, replies with what was sent and logs whether the
+// counts match. The read deadline also bounds the wait for the client to
+// take the reply.
+func reconcile(s *quic.Stream, last, written int) {
+	defer s.Close()
+	if _, err := s.Write([]byte(frame.SendEnd)); err != nil {
+		log.Printf("Frame count check error: %v", err)
+		return
+	}
+	s.SetReadDeadline(time.Now().Add(reconcileTimeout))
+	rd := bufio.NewReader(s)
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		log.Printf("Frame count check error: no count from the client: %v", err)
+		return
+	}
+	distinct, maxSeq, err := frame.ParseReceived(line)
+	if err != nil {
+		log.Printf("Frame count check error: %v", err)
+		return
+	}
+	if _, err := s.Write([]byte(frame.FormatSent(last, written))); err != nil {
+		log.Printf("Frame count check error: %v", err)
+		return
+	}
+	if distinct < written {
+		log.Printf("Frame count MISMATCH: %d frames written in full of %d generated, the client received %d (up to frame %d): %d missing",
+			written, last, distinct, maxSeq, written-distinct)
+	} else {
+		log.Printf("Frame count check: %d frames written in full of %d generated, the client received %d (up to frame %d)",
+			written, last, distinct, maxSeq)
+	}
+	// the client closes its side once it has the reply, which the session
+	// close would otherwise cut off
+	s.Close()
+	io.Copy(io.Discard, rd)
+}
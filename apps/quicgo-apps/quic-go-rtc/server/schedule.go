@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// schedule holds the send offset of each frame from the start of the
+// request, indexed by sequence number minus one. On disk it is a CSV with a
+// "frame,send_offset_s" header and one row per frame.
+type schedule []time.Duration
+
+// loadSchedule reads a schedule written by writeFile. Rows must cover frames
+// 1..n in order.
+func loadSchedule(path string) (schedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	if _, err := r.Read(); err != nil {
+		return nil, fmt.Errorf("%s: missing header: %w", path, err)
+	}
+	var s schedule
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		seq, err := strconv.Atoi(rec[0])
+		if err != nil || seq != len(s)+1 {
+			return nil, fmt.Errorf("%s: expected frame %d, got %q", path, len(s)+1, rec[0])
+		}
+		secs, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("%s: invalid offset %q for frame %d", path, rec[1], seq)
+		}
+		s = append(s, time.Duration(secs*float64(time.Second)))
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("%s: no frames", path)
+	}
+	return s, nil
+}
+
+// writeFile writes s as CSV to path.
+func (s schedule) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"frame", "send_offset_s"})
+	for i, d := range s {
+		w.Write([]string{strconv.Itoa(i + 1), strconv.FormatFloat(d.Seconds(), 'f', 6, 64)})
+	}
+	w.Flush()
+	return errors.Join(w.Error(), f.Close())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"hash/fnv"
+	mrand "math/rand/v2"
+)
+
+// seedBundle holds the seeds of the server's random choices. With -seed they
+// are all derived from it, so one number reproduces what a run sends.
+type seedBundle struct {
+	// Drop seeds the -drop-prob generator.
+	Drop uint64
+	// Payload seeds the -entropy payload of each frame together with its
+	// sequence number; zero leaves payloads unseeded.
+	Payload uint64
+	// Size seeds the -frame-size-jitter generator.
+	Size uint64
+}
+
+// deriveSeeds returns the bundle derived from seed.
+func deriveSeeds(seed uint64) seedBundle {
+	return seedBundle{
+		Drop:    deriveSeed(seed, "drop"),
+		Payload: deriveSeed(seed, "payload"),
+		Size:    deriveSeed(seed, "size"),
+	}
+}
+
+// deriveSeed returns the sub-seed named name of seed. The same seed and name
+// always give the same sub-seed.
+func deriveSeed(seed uint64, name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return mrand.New(mrand.NewPCG(seed, h.Sum64())).Uint64()
+}
+
+// payloadRand returns the generator for the payload of frame seq, or nil
+// for the global generator when payloads are unseeded.
+func (s seedBundle) payloadRand(seq uint32) *mrand.Rand {
+	if s.Payload == 0 {
+		return nil
+	}
+	return mrand.New(mrand.NewPCG(s.Payload, uint64(seq)))
+}
@@ -7,149 +7,1003 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/big"
+	mrand "math/rand/v2"
+	"net"
 	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+
+	"quic-go-common/payload"
+	"quic-go-common/preflight"
+	"quic-go-common/qtrace"
+	"quic-go-common/scenario"
+	"quic-go-common/telemetry"
+	"quic-go-common/tuning"
+	"quic-go-rtc/frame"
 )
 
 const (
 	FRAME_INTERVAL = 33 * time.Millisecond // 30fps
+
+	// ackTimeout bounds how long the server waits for outstanding frame
+	// ACKs before closing the connection.
+	ackTimeout = time.Second
+
+	// openTimeout bounds how long a frame waits for the client to raise
+	// the stream limit before it is dropped, so that backpressure costs
+	// frames rather than delaying every later one.
+	openTimeout = FRAME_INTERVAL
 )
 
 func main() {
 	addr := flag.String("p", "127.0.0.1:8080", "server port")
 	frameSize := flag.Int("f", 12500, "size of each frame in bytes")
+	sizeJitterFlag := flag.Float64("frame-size-jitter", 0, "draw each frame's size around -f, within this fraction of it for -frame-size-dist uniform (0.2 is ±20%) or with this fraction of it as the standard deviation for normal, seeded like -drop-prob, and log the mean and stddev of the sizes sent (0 sends constant frames)")
+	sizeDist := flag.String("frame-size-dist", sizeDistUniform, "distribution of the -frame-size-jitter sizes: uniform or normal")
 	t := flag.Float64("t", 0.0, "Start time of the test (unix seconds)")
+	dropProb := flag.Float64("drop-prob", 0.0, "probability of skipping each frame, for loss-accounting tests")
+	dropSeed := flag.Uint64("drop-seed", 1, "seed for the -drop-prob generator")
+	seed := flag.Uint64("seed", 0, "derive the seeds of all random choices, the -drop-prob and -frame-size-jitter generators and the -entropy payloads, from this one so the same seed sends the same bytes; overrides -drop-seed and logs the derived seeds (0 for unseeded)")
+	alpn := flag.String("alpn", "http/0.9", "comma-separated ALPN protocols to offer")
+	fec := flag.Int("fec", 0, "experimental: send an XOR parity frame after every this many frames, letting the client recover one lost frame per group (0 disables)")
+	certFile := flag.String("cert", "", "PEM certificate to serve; a self-signed one is generated when empty")
+	keyFile := flag.String("key", "", "PEM private key for -cert")
+	abrTarget := flag.Duration("abr-target-delay", 0, "adapt the bitrate to keep the per-frame RTT under this target; needs a client running with -ack-frames (0 disables)")
+	abrMin := flag.Float64("abr-min-bitrate", 0.5, "lower bitrate bound for -abr-target-delay in Mbps")
+	abrMax := flag.Float64("abr-max-bitrate", 20, "upper bitrate bound for -abr-target-delay in Mbps")
+	entropy := flag.Float64("entropy", 0, "frame payload entropy from 0 (zeros) to 1 (random); gzip compresses it by roughly 1/entropy")
+	payloadPattern := flag.String("payload-pattern", frame.PatternEntropy, "frame payload to send: entropy for the -entropy payload, or signature for 16-byte stamps of each cell's frame and offset, to read frame boundaries, reordering and duplication off a decrypted packet capture")
+	sendTimestamps := flag.Bool("send-timestamps", false, "stamp the first 8 payload bytes of each frame with its raw Unix send time in nanoseconds, taken when its stream opens, for a client running with -raw-timestamps")
+	seeded := flag.Bool("seeded", false, "fill each frame's payload from a PRNG seeded by its sequence number, so a client with -verify can check every frame")
+	maxInflight := flag.Int("max-inflight", 0, "have at most this many frames outstanding at once, from opening their stream until the client acknowledges them with -ack-frames (or until written, without), like a bounded encoder queue (0 is unbounded)")
+	inflightPolicy := flag.String("inflight-policy", inflightDelay, "what a frame does when -max-inflight frames are outstanding: delay, waiting for a slot and holding up the frames after it, or drop")
+	maxOpenRate := flag.Float64("max-stream-open-rate", 0, "open at most this many frame streams per second, spaced evenly whatever the frame interval and -burst, and log the achieved rate (0 is unlimited)")
+	maxBacklog := flag.Int("max-backlog", 0, "send at most this many frames at once, shedding the oldest in flight when a new frame finds the backlog full (0 is unbounded)")
+	heartbeatEvery := flag.Duration("heartbeat", 0, "log each session's bytes sent so far and current rate at this interval (e.g. 5s) during the transfer (0 disables)")
+	acceptWorkers := flag.Int("accept-workers", 0, "accept sessions from this many goroutines into a queue, logging each session's accept-to-handle latency (0 accepts inline)")
+	maxBytes := flag.Int64("max-bytes", 1<<30, "reject GETN requests for more than this many bytes (frames times frame size); 0 disables the cap")
+	chunkedFrame := flag.Int("chunked-frame", 0, "split each frame into this many chunks of its payload, sent on as many parallel streams and reassembled by the client, which reports the frame-complete time on the last chunk against each chunk stream's own delivery (0 or 1 sends each frame on one stream)")
+	streamType := flag.String("stream-type", streamUni, "stream type to send each frame on: uni, bidi, or alternate between the two per frame, to compare their delivery and stream-limit backpressure; the client accepts either")
+	burst := flag.Int("burst", 1, "send this many frames back-to-back at each interval, each on its own stream")
+	initialBurst := flag.Int("initial-burst", 0, "send frames back-to-back, unpaced, until they total this many bytes, like a video prebuffer, then switch to the frame schedule, and log when the burst completed (0 disables)")
+	scheduleOut := flag.String("schedule-out", "", "write each session's frame send offsets as a schedule CSV to this file, for -replay")
+	replayFile := flag.String("replay", "", "send frames at the offsets of a schedule CSV recorded with -schedule-out instead of at a fixed interval")
+	cpuList := flag.String("cpus", "", "pin the process to this CPU list, e.g. 0,2-3, to cut scheduling jitter in frame pacing")
+	precisePacing := flag.Bool("precise-pacing", coarseTimers, "pace frames to absolute deadlines, spinning through the last 2ms, for platforms with coarse sleep resolution (on by default on Windows)")
+	lockThread := flag.Bool("lock-thread", false, "run each session's frame pacing loop on its own locked OS thread")
+	wireStats := flag.Bool("wire-stats", false, "log each session's application goodput next to its estimated on-the-wire throughput and overhead")
+	captureSummary := flag.Bool("capture-summary", false, "log a packet-capture style summary at shutdown, over all sessions: QUIC packets sent and received by type, retransmissions, UDP bytes and the average packet size")
+	mtu := flag.Int("mtu", 0, "cap the datagrams the server sends at this IP MTU in bytes, headers included, dropping the larger ones as a link would, so path MTU discovery stops below it (0 for no cap)")
+	fcStats := flag.Bool("fc-stats", false, "log how long each session was blocked on connection and stream flow control")
+	stateDump := flag.String("state-dump", "", "write each session's final state (negotiated parameters, RTT, congestion window, bytes per packet-number space, stream counts and close reason) as a line of JSON to this file when it closes")
+	transportParams := flag.Bool("transport-params", false, "log each session's negotiated QUIC version and the transport parameters sent and received")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector (host:port or URL) to export trace spans to; tracing is off when empty")
+	cc := flag.String("cc", tuning.DefaultCongestionControl, "congestion controller; the linked quic-go only provides cubic and has no hook for custom:<name> controllers")
+	initialRTT := flag.Duration("initial-rtt", 0, "initial RTT estimate for the sender (if supported by quic-go)")
+	minCwnd := flag.Int("min-cwnd", 0, "minimum congestion window in packets (if supported by quic-go)")
+	maxCwnd := flag.Int("max-cwnd", 0, "maximum congestion window in packets (if supported by quic-go)")
+	initialStreamWindow := flag.Uint64("initial-stream-window", 0, "initial per-stream receive window in bytes (0 keeps the quic-go default)")
+	maxStreamWindow := flag.Uint64("max-stream-window", 0, "maximum per-stream receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	initialConnWindow := flag.Uint64("initial-conn-window", 0, "initial connection receive window in bytes (0 keeps the quic-go default)")
+	maxConnWindow := flag.Uint64("max-conn-window", 0, "maximum connection receive window in bytes that auto-tuning may grow to (0 keeps the quic-go default)")
+	scenarioFile := flag.String(scenario.FlagName, "", "JSON file of flag values to run with, keyed by flag name, e.g. {\"cc\": \"cubic\", \"initial-rtt\": \"50ms\"}; the flags given on the command line take precedence")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "write a heap profile at the end of the run to this file")
+	blockProfile := flag.String("blockprofile", "", "write a profile of every goroutine blocking event to this file (slows the run)")
+	syslogAddr := flag.String("syslog", "", "also send the logs to this syslog server (host:port for UDP, or tcp://host:port), dropping lines rather than waiting on it (off when empty)")
 	flag.Parse()
+	if err := scenario.Load(flag.CommandLine, *scenarioFile); err != nil {
+		log.Fatalf("invalid -scenario: %v", err)
+	}
 	disableGSO()
 
+	if *syslogAddr != "" {
+		stopSyslog, err := telemetry.MirrorLogs(*syslogAddr, "quic-go-rtc-server")
+		if err != nil {
+			log.Fatalf("invalid -syslog %q: %v", *syslogAddr, err)
+		}
+		defer stopSyslog()
+	}
+
+	if err := tuning.CheckCongestionControl(*cc); err != nil {
+		log.Fatalf("invalid -cc %q: %v", *cc, err)
+	}
+
+	if *frameSize < frame.HeaderLen {
+		log.Fatalf("frame size must be at least %d bytes", frame.HeaderLen)
+	}
+	if *sizeJitterFlag < 0 || *sizeJitterFlag > 1 {
+		log.Fatalf("invalid -frame-size-jitter %v: must be within [0, 1]", *sizeJitterFlag)
+	}
+	if *sizeDist != sizeDistUniform && *sizeDist != sizeDistNormal {
+		log.Fatalf("invalid -frame-size-dist %q: must be %s or %s", *sizeDist, sizeDistUniform, sizeDistNormal)
+	}
+	if *sizeJitterFlag > 0 && *fec > 0 {
+		// the parity covers only the first -f bytes of each payload
+		log.Fatal("-frame-size-jitter cannot be combined with -fec")
+	}
+	if *maxBacklog < 0 {
+		log.Fatalf("invalid -max-backlog %d: must not be negative", *maxBacklog)
+	}
+	if *maxOpenRate < 0 {
+		log.Fatalf("invalid -max-stream-open-rate %v: must not be negative", *maxOpenRate)
+	}
+	if *maxInflight < 0 {
+		log.Fatalf("invalid -max-inflight %d: must not be negative", *maxInflight)
+	}
+	if *inflightPolicy != inflightDelay && *inflightPolicy != inflightDrop {
+		log.Fatalf("invalid -inflight-policy %q: must be %s or %s", *inflightPolicy, inflightDelay, inflightDrop)
+	}
+	if *acceptWorkers < 0 {
+		log.Fatalf("invalid -accept-workers %d: must not be negative", *acceptWorkers)
+	}
+	if *maxBytes < 0 {
+		log.Fatalf("invalid -max-bytes %d: must not be negative", *maxBytes)
+	}
+	if *fec < 0 || *fec > math.MaxUint16 {
+		log.Fatalf("invalid -fec %d: must be within [0, %d]", *fec, math.MaxUint16)
+	}
+	if *abrTarget > 0 {
+		if *fec > 0 {
+			log.Fatal("-abr-target-delay cannot be combined with -fec")
+		}
+		if *abrMin <= 0 || *abrMax < *abrMin {
+			log.Fatalf("invalid ABR bitrate bounds %v-%v Mbps", *abrMin, *abrMax)
+		}
+	}
+	if *streamType != streamUni && *streamType != streamBidi && *streamType != streamAlternate {
+		log.Fatalf("invalid -stream-type %q: must be %s, %s or %s", *streamType, streamUni, streamBidi, streamAlternate)
+	}
+	if *chunkedFrame < 0 || *chunkedFrame > math.MaxUint16 {
+		log.Fatalf("invalid -chunked-frame %d: must be within [0, %d]", *chunkedFrame, math.MaxUint16)
+	}
+	if *chunkedFrame > 1 {
+		if *fec > 0 || *maxBacklog > 0 || *maxOpenRate > 0 {
+			log.Fatal("-chunked-frame cannot be combined with -fec, -max-backlog or -max-stream-open-rate")
+		}
+		log.Printf("Splitting each frame into %d chunks on parallel streams", *chunkedFrame)
+	}
+	if *burst < 1 {
+		log.Fatalf("invalid -burst %d: must be at least 1", *burst)
+	}
+	if *burst > 1 && (*abrTarget > 0 || *replayFile != "") {
+		log.Fatal("-burst cannot be combined with -abr-target-delay or -replay")
+	}
+	if *mtu != 0 && *mtu < qtrace.MinMTU {
+		log.Fatalf("invalid -mtu %d: must be 0 or at least %d", *mtu, qtrace.MinMTU)
+	}
+	if *initialBurst < 0 {
+		log.Fatalf("invalid -initial-burst %d: must not be negative", *initialBurst)
+	}
+	if *initialBurst > 0 {
+		if *replayFile != "" {
+			log.Fatal("-initial-burst cannot be combined with -replay")
+		}
+		log.Printf("Initial burst: %d bytes of frames unpaced before the frame schedule", *initialBurst)
+	}
+	if *entropy < 0 || *entropy > 1 {
+		log.Fatalf("invalid -entropy %v: must be within [0, 1]", *entropy)
+	}
+	if *seeded && *entropy > 0 {
+		log.Fatal("-seeded payloads are fully random and cannot be combined with -entropy")
+	}
+	switch *payloadPattern {
+	case frame.PatternEntropy:
+	case frame.PatternSignature:
+		if *entropy > 0 || *seeded {
+			log.Fatal("-payload-pattern signature cannot be combined with -entropy or -seeded")
+		}
+	default:
+		log.Fatalf("invalid -payload-pattern %q: must be %s or %s", *payloadPattern, frame.PatternEntropy, frame.PatternSignature)
+	}
+	if *sendTimestamps {
+		if *seeded || *payloadPattern == frame.PatternSignature || *fec > 0 {
+			// the stamp overwrites payload bytes these check or fold in
+			log.Fatal("-send-timestamps cannot be combined with -seeded, -payload-pattern signature or -fec")
+		}
+		if *frameSize < frame.HeaderLen+frame.SendTimeLen {
+			log.Fatalf("-send-timestamps needs a frame size of at least %d bytes", frame.HeaderLen+frame.SendTimeLen)
+		}
+	}
+	if *dropProb < 0 || *dropProb > 1 {
+		log.Fatalf("invalid -drop-prob %v: must be within [0, 1]", *dropProb)
+	}
+	seeds := seedBundle{Drop: *dropSeed, Size: 1}
+	if *seed != 0 {
+		seeds = deriveSeeds(*seed)
+		log.Printf("Seeds from -seed %d: drop %d, payload %d, size %d", *seed, seeds.Drop, seeds.Payload, seeds.Size)
+	}
+
+	if *cpuList != "" {
+		cpus, err := parseCPUList(*cpuList)
+		if err != nil {
+			log.Fatalf("invalid -cpus: %v", err)
+		}
+		if err := setAffinity(cpus); err != nil {
+			log.Printf("Warning: cannot set CPU affinity, continuing unpinned: %v", err)
+		}
+	}
+
+	var replay schedule
+	if *replayFile != "" {
+		var err error
+		if replay, err = loadSchedule(*replayFile); err != nil {
+			log.Fatalf("Replay schedule error: %v", err)
+		}
+	}
+
 	// compute start time baseline: use provided unix seconds (with fraction)
 	var baseline time.Time
 	sec := int64(*t)
 	nsec := int64((*t - float64(sec)) * 1e9)
 	baseline = time.Unix(sec, nsec)
 
+	// preflight: bind and load the TLS material before anything else, so a
+	// misconfiguration fails here rather than mid-run
+	udpAddr, err := net.ResolveUDPAddr("udp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to resolve UDP address: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Fatal(preflight.ExplainBindError(*addr, err))
+	}
+
 	tlsConf := generateTLSConfig()
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("TLS certificate error: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	if err := preflight.CheckCertificates(tlsConf); err != nil {
+		log.Fatalf("TLS certificate error: %v", err)
+	}
+	tlsConf.NextProtos = parseALPN(*alpn)
+
+	// spans are batched and exported in the background while the server runs
+	shutdownTracing, err := telemetry.Setup(context.Background(), "quic-go-rtc-server", *otlpEndpoint)
+	if err != nil {
+		log.Fatalf("Tracing setup error: %v", err)
+	}
+	// flushes, within a bounded time, the spans of the last sessions still
+	// queued in the batch exporter once the server has drained
+	defer func() {
+		if err := shutdownTracing(); err != nil {
+			log.Printf("Tracing shutdown error: %v", err)
+		}
+	}()
+	stopProfiles, err := telemetry.Profiles{CPU: *cpuProfile, Mem: *memProfile, Block: *blockProfile}.Start()
+	if err != nil {
+		log.Fatalf("Profiling error: %v", err)
+	}
+	defer func() {
+		if err := stopProfiles(); err != nil {
+			log.Printf("Profiling error: %v", err)
+		}
+	}()
 	quicConfig := &quic.Config{
 		MaxIncomingStreams:    3000,
 		MaxIncomingUniStreams: 3000,
 	}
+	tuning.CongestionTuning{
+		InitialRTT:     *initialRTT,
+		MinCwndPackets: *minCwnd,
+		MaxCwndPackets: *maxCwnd,
+	}.Apply(quicConfig)
+	windows := tuning.FlowControlWindows{
+		InitialStream: *initialStreamWindow,
+		MaxStream:     *maxStreamWindow,
+		InitialConn:   *initialConnWindow,
+		MaxConn:       *maxConnWindow,
+	}
+	if err := windows.Apply(quicConfig); err != nil {
+		log.Fatalf("invalid receive windows: %v", err)
+	}
 
-	listener, err := quic.ListenAddr(*addr, tlsConf, quicConfig)
+	var sender *qtrace.SenderCounter
+	var senderTracer, paramsTracer, dumpTracer, captureTracer qtrace.Tracer
+	if *wireStats || *fcStats {
+		sender = qtrace.NewSenderCounter()
+		senderTracer = sender.Tracer
+	}
+	var params *qtrace.ParamsRecorder
+	if *transportParams {
+		params = qtrace.NewParamsRecorder()
+		paramsTracer = params.Tracer
+	}
+	var dump *qtrace.StateDump
+	if *stateDump != "" {
+		if dump, err = qtrace.NewStateDump(*stateDump); err != nil {
+			log.Fatal("State dump error:", err)
+		}
+		defer dump.Close()
+		dumpTracer = dump.Tracer
+	}
+	var capture *qtrace.Capture
+	if *captureSummary {
+		capture = qtrace.NewCapture()
+		captureTracer = capture.Tracer
+	}
+	var packetConn net.PacketConn = conn
+	if *mtu > 0 {
+		if packetConn, err = qtrace.CapMTU(quicConfig, conn, *mtu); err != nil {
+			log.Fatalf("invalid -mtu %d: %v", *mtu, err)
+		}
+	}
+	mtus := qtrace.NewMTURecorder(quicConfig, *mtu)
+	quicConfig.Tracer = qtrace.Multi(senderTracer, paramsTracer, dumpTracer, captureTracer, mtus.Tracer)
+
+	listener, err := quic.Listen(packetConn, tlsConf, quicConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Printf("Server running on %s, frame size: %d bytes", *addr, *frameSize)
 
-	for {
-		session, err := listener.Accept(context.Background())
-		if err != nil {
-			log.Println("Accept session error:", err)
-			continue
+	// the first interrupt stops accepting sessions and lets the ones in
+	// progress finish; a second one exits right away
+	stats := newServerStats()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		log.Printf("Shutting down after the %d sessions in progress; interrupt again to exit now", stats.active.Load())
+		listener.Close()
+	}()
+
+	sessCfg := &sessionConfig{
+		frameSize:      *frameSize,
+		sizeJitter:     *sizeJitterFlag,
+		sizeDist:       *sizeDist,
+		startTime:      baseline,
+		dropProb:       *dropProb,
+		seeds:          seeds,
+		fec:            *fec,
+		entropy:        *entropy,
+		seeded:         *seeded,
+		signature:      *payloadPattern == frame.PatternSignature,
+		stampSend:      *sendTimestamps,
+		maxBytes:       *maxBytes,
+		replay:         replay,
+		scheduleOut:    *scheduleOut,
+		streamType:     *streamType,
+		chunks:         *chunkedFrame,
+		burst:          *burst,
+		initialBurst:   *initialBurst,
+		maxBacklog:     *maxBacklog,
+		maxInflight:    *maxInflight,
+		inflightPolicy: *inflightPolicy,
+		maxOpenRate:    *maxOpenRate,
+		lockThread:     *lockThread,
+		precisePacing:  *precisePacing,
+		heartbeatEvery: *heartbeatEvery,
+		senders:        sender,
+		params:         params,
+		reportWire:     *wireStats,
+		reportFC:       *fcStats,
+	}
+	serve := func(session *quic.Conn) {
+		var abr *abrController
+		if *abrTarget > 0 {
+			abr = newABRController(*abrTarget, *abrMin, *abrMax, *frameSize)
+		}
+		stats.opened()
+		go func() {
+			defer func() { stats.closed(session.ConnectionStats().BytesSent) }()
+			handleSession(session, sessCfg, abr)
+			// handleSession has closed the session by now
+			if err := dump.Record(session); err != nil {
+				log.Printf("State dump error: %v", err)
+			}
+		}()
+	}
+
+	if *acceptWorkers == 0 {
+		for {
+			session, err := listener.Accept(context.Background())
+			if errors.Is(err, quic.ErrServerClosed) {
+				break
+			}
+			if err != nil {
+				log.Println("Accept session error:", err)
+				continue
+			}
+			serve(session)
+		}
+	} else {
+		queue := acceptSessions(listener, *acceptWorkers)
+		for a := range queue {
+			log.Printf("Accept-to-handle latency: %.3f ms (%d more queued)",
+				float64(time.Since(a.at).Microseconds())/1000.0, len(queue))
+			serve(a.session)
 		}
-		go handleSession(session, *frameSize, baseline)
 	}
+	stats.running.Wait()
+	stats.report()
+	mtus.Report()
+	if capture != nil {
+		capture.Report()
+	}
+}
+
+// sessionConfig holds the settings every session is served with, fixed once
+// the flags are validated.
+type sessionConfig struct {
+	frameSize      int
+	sizeJitter     float64
+	sizeDist       string
+	startTime      time.Time
+	dropProb       float64
+	seeds          seedBundle
+	fec            int
+	entropy        float64
+	seeded         bool
+	signature      bool
+	stampSend      bool
+	maxBytes       int64
+	replay         schedule
+	scheduleOut    string
+	streamType     string
+	chunks         int
+	burst          int
+	initialBurst   int
+	maxBacklog     int
+	maxInflight    int
+	inflightPolicy string
+	maxOpenRate    float64
+	lockThread     bool
+	precisePacing  bool
+	heartbeatEvery time.Duration
+	senders        *qtrace.SenderCounter
+	params         *qtrace.ParamsRecorder
+	reportWire     bool
+	reportFC       bool
 }
 
-func handleSession(session *quic.Conn, frameSize int, startTime time.Time) {
+func handleSession(session *quic.Conn, cfg *sessionConfig, abr *abrController) {
 	defer session.CloseWithError(0, "")
+	// ABR moves the frame size of this session away from -f
+	frameSize := cfg.frameSize
+	// looked up now, as the counter forgets the session once it closes,
+	// which the client may do before the report at the end
+	sender := cfg.senders.Conn(session.Context())
+
+	ctx, connSpan := telemetry.Tracer().Start(context.Background(), "connection")
+	connSpan.SetAttributes(attribute.String("net.peer.addr", session.RemoteAddr().String()))
+	defer connSpan.End()
+	log.Printf("Negotiated ALPN: %s", session.ConnectionState().TLS.NegotiatedProtocol)
+	if cfg.params != nil {
+		cfg.params.Negotiated(session).Log()
+	}
 
 	buf := make([]byte, 4096)
 
+	_, reqSpan := telemetry.Tracer().Start(ctx, "request")
 	stream, err := session.AcceptStream(context.Background())
 	if err != nil {
 		log.Println("Accept stream error:", err)
+		reqSpan.End()
 		return
 	}
 
 	n, err := stream.Read(buf)
 	if err != nil && err != io.EOF {
 		log.Println("Read request error:", err)
+		reqSpan.End()
 		return
 	}
 
 	req := strings.TrimSpace(string(buf[:n]))
-	if !strings.HasPrefix(req, "GETN") {
+	reqSpan.SetAttributes(attribute.String("request", req))
+	reqSpan.End()
+	// a client running with -reconcile checks the frame count at the end
+	req, reconcileCount := strings.CutSuffix(req, " "+frame.ReconcileOption)
+
+	// GETN asks for a fixed number of frames, GETT for a stream duration
+	var numFrames int
+	var duration time.Duration
+	switch {
+	case strings.HasPrefix(req, "GETN"):
+		numFrames, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(req, "GETN")))
+		if err == nil && numFrames < 0 {
+			err = fmt.Errorf("negative frame count %d", numFrames)
+		}
+		if err != nil {
+			log.Println("Invalid GETN request number:", err)
+			session.CloseWithError(frame.RequestRejected, "invalid GETN request")
+			return
+		}
+		// compared by division, as the product can overflow
+		if cfg.maxBytes > 0 && int64(numFrames) > cfg.maxBytes/int64(frameSize) {
+			log.Printf("Rejected GETN request: %d frames of %d B exceed the %d byte cap", numFrames, frameSize, cfg.maxBytes)
+			session.CloseWithError(frame.RequestRejected, "request exceeds the byte cap")
+			return
+		}
+		log.Printf("RTC Server GetN request: %d frames, each is %d B", numFrames, frameSize)
+	case strings.HasPrefix(req, "GETT"):
+		secs, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(req, "GETT")), 64)
+		if err != nil || secs <= 0 {
+			log.Println("Invalid GETT request duration:", req)
+			session.CloseWithError(frame.RequestRejected, "invalid GETT request")
+			return
+		}
+		duration = time.Duration(secs * float64(time.Second))
+		log.Printf("RTC Server GetT request: %v, each frame is %d B", duration, frameSize)
+	default:
 		log.Println("Unknown request:", req)
+		session.CloseWithError(frame.RequestRejected, "unknown request")
 		return
 	}
 
-	numFrames, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(req, "GETN")))
-	if err != nil {
-		log.Println("Invalid GETN request number:", err)
-		return
+	var wg sync.WaitGroup
+	var totalBytes int64
+
+	// with -max-inflight, a frame holds a slot from before its stream is
+	// opened until it is acknowledged
+	var inflight *inflightLimiter
+	if cfg.maxInflight > 0 {
+		inflight = newInflightLimiter(cfg.maxInflight, cfg.inflightPolicy)
 	}
 
-	log.Printf("RTC Server GetN request: %d frames, each is %d B", numFrames, frameSize)
+	// a client running with -ack-frames opens a second stream for ACKs
+	acks := newAckTracker()
+	if inflight != nil {
+		acks.onAck = inflight.acked
+	}
+	go func() {
+		s, err := session.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		acks.serve(s)
+	}()
 
-	var wg sync.WaitGroup
-	var totalBytes int64
+	// every session replays the same drop pattern for a given seed
+	rng := mrand.New(mrand.NewPCG(cfg.seeds.Drop, 0))
+	dropped := 0
+	sizes := newSizeJitter(cfg.sizeJitter, cfg.sizeDist, cfg.seeds.Size)
+
+	_, xferSpan := telemetry.Tracer().Start(ctx, "transfer")
+	defer xferSpan.End()
 
 	// record actual request start time for elapsed/goodput
 	requestStart := time.Now()
+	if cfg.heartbeatEvery > 0 {
+		stopHeartbeat := make(chan struct{})
+		defer close(stopHeartbeat)
+		go heartbeat(session.RemoteAddr().String(), cfg.heartbeatEvery, &totalBytes, stopHeartbeat)
+	}
 
-	for i := 0; i < numFrames; i++ {
-		frame := make([]byte, frameSize)
-		wg.Add(1)
-		idx := i + 1
-		go func(idx int, f []byte) {
-			defer wg.Done()
+	// send writes f on its own uni stream; seq is 0 for parity frames, which
+	// stay out of the per-frame output
+	// a stream-level error costs only its frame; a connection-level error
+	// ends the session, logged once rather than for every remaining frame
+	var failedFrames atomic.Int64
+	// writtenFrames counts the data frames written in full, for -reconcile
+	var writtenFrames atomic.Int64
+	var connFailed atomic.Bool
+	var connOnce sync.Once
+	streamFailed := func(what string, err error) {
+		if !isConnectionError(session, err) {
+			failedFrames.Add(1)
+			log.Printf("%s error: %v", what, err)
+			return
+		}
+		connFailed.Store(true)
+		connOnce.Do(func() {
+			if qerr, ok := err.(*quic.ApplicationError); ok && qerr.ErrorCode == 0 {
+				return
+			}
+			log.Printf("Connection error, ending session: %v", err)
+		})
+	}
+
+	// opens that had to wait for the client to raise the stream limit are
+	// the backpressure a burst can run into
+	var blockedOpens, blockedNanos atomic.Int64
+	var openTimeouts atomic.Int64
+
+	// with -max-stream-open-rate, stream opens are spaced apart on their own
+	var opens *openLimiter
+	if cfg.maxOpenRate > 0 {
+		opens = newOpenLimiter(cfg.maxOpenRate)
+	}
+
+	// with -max-backlog, data frames in flight are bounded and the oldest
+	// are shed to make room for new ones
+	var bl *backlog
+	if cfg.maxBacklog > 0 {
+		bl = newBacklog(cfg.maxBacklog)
+	}
 
-			fs, err := session.OpenUniStreamSync(context.Background())
+	streams := newFrameStreams(session, cfg.streamType)
+
+	// openStream opens the stream for frame seq, or a parity frame for seq
+	// 0, waiting up to openTimeout on the client's stream limit. It reports
+	// false once the frame is lost to the limit or the stream failed.
+	openStream := func(openCtx context.Context, bidi bool, seq int, shed func() bool) (frameSendStream, bool) {
+		fs, err := streams.open(bidi)
+		var limitErr *quic.StreamLimitReachedError
+		if errors.As(err, &limitErr) {
+			blockedOpens.Add(1)
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(openCtx, openTimeout)
+			fs, err = streams.openSync(ctx, bidi)
+			cancel()
+			blockedNanos.Add(int64(time.Since(start)))
+		}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				openTimeouts.Add(1)
+				if seq > 0 {
+					log.Printf("Dropped frame %d: no stream within %v on the stream limit", seq, openTimeout)
+				} else {
+					log.Printf("Dropped parity frame: no stream within %v on the stream limit", openTimeout)
+				}
+				return nil, false
+			}
+			if !shed() {
+				streamFailed("OpenStreamSync", err)
+			}
+			return nil, false
+		}
+		streams.done(bidi)
+		return fs, true
+	}
+
+	// writeStream writes b on fs and closes it, and reports whether all of
+	// b was written
+	writeStream := func(fs frameSendStream, b []byte, shed func() bool) bool {
+		// write loop to handle partial writes
+		remaining := b
+		for len(remaining) > 0 {
+			n, err := fs.Write(remaining)
+			if n > 0 {
+				atomic.AddInt64(&totalBytes, int64(n))
+				remaining = remaining[n:]
+			}
 			if err != nil {
-				if qerr, ok := err.(*quic.ApplicationError); ok && qerr.ErrorCode == 0 {
-					return
+				// if stream write returns EOF or other error, stop trying for this stream
+				if err != io.EOF && !shed() {
+					streamFailed("Stream write", err)
 				}
-				log.Println("OpenStreamSync error:", err)
-				return
+				break
 			}
+		}
 
-			fmt.Printf("frame %d, sent time: %.6f\n", idx, time.Since(startTime).Seconds())
+		fs.Close()
+		return len(remaining) == 0
+	}
 
-			// write loop to handle partial writes
-			remaining := f
-			for len(remaining) > 0 {
-				n, err := fs.Write(remaining)
-				if n > 0 {
-					atomic.AddInt64(&totalBytes, int64(n))
-					remaining = remaining[n:]
+	// markSent records frame seq as sent now, stamping f with the time for
+	// -send-timestamps
+	markSent := func(f []byte, seq int) {
+		now := time.Now()
+		acks.markSent(uint32(seq), now)
+		// jittered frames may be too short to carry the stamp
+		if cfg.stampSend && len(f) >= frame.HeaderLen+frame.SendTimeLen {
+			frame.PutSendTime(f[frame.HeaderLen:], now)
+		}
+		fmt.Printf("frame %d, sent time: %.6f\n", seq, time.Since(cfg.startTime).Seconds())
+	}
+
+	// sendChunks splits frame f into chunks sent on their own streams, in
+	// parallel, which are all opened before any is written. It reports
+	// whether every chunk was written.
+	sendChunks := func(f []byte, seq int, bidis []bool) bool {
+		never := func() bool { return false }
+		var fss []frameSendStream
+		for _, bidi := range bidis {
+			fs, ok := openStream(context.Background(), bidi, seq, never)
+			if !ok {
+				// the chunks already opened still go out, but the frame
+				// is incomplete at the client
+				break
+			}
+			fss = append(fss, fs)
+		}
+		markSent(f, seq)
+		chunks := frame.SplitFrame(f, len(bidis))
+		var written sync.WaitGroup
+		var all atomic.Bool
+		all.Store(len(fss) == len(chunks))
+		for i, fs := range fss {
+			written.Add(1)
+			go func() {
+				defer written.Done()
+				if !writeStream(fs, chunks[i], never) {
+					all.Store(false)
 				}
-				if err != nil {
-					// if stream write returns EOF or other error, log and stop trying for this stream
-					if err == io.EOF {
-						break
-					}
-					log.Println("Stream write error:", err)
-					break
+			}()
+		}
+		written.Wait()
+		return all.Load()
+	}
+
+	// send writes f on its own stream, or its chunks on theirs; seq is 0
+	// for parity frames, which stay out of the per-frame output
+	send := func(f []byte, seq int) {
+		var p *pendingFrame
+		openCtx := context.Background()
+		if bl != nil && seq > 0 {
+			p = bl.add(uint32(seq))
+			openCtx = p.ctx
+		}
+		shed := func() bool { return p != nil && bl.isDropped(p) }
+		if inflight != nil && !inflight.acquire(session.Context()) {
+			if p != nil {
+				bl.done(uint32(seq))
+			}
+			switch {
+			case session.Context().Err() != nil:
+			case seq > 0:
+				log.Printf("Dropped frame %d: %d frames in flight", seq, cfg.maxInflight)
+			default:
+				log.Printf("Dropped parity frame: %d frames in flight", cfg.maxInflight)
+			}
+			return
+		}
+
+		// the stream types are picked in send order
+		var bidis []bool
+		if cfg.chunks > 1 && seq > 0 {
+			bidis = make([]bool, cfg.chunks)
+			for i := range bidis {
+				bidis[i] = streams.pick()
+			}
+		}
+		bidi := bidis == nil && streams.pick()
+		var openAt time.Time
+		if opens != nil {
+			openAt = opens.reserve()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			written := false
+			defer func() {
+				if written && seq > 0 {
+					writtenFrames.Add(1)
 				}
+			}()
+			if inflight != nil {
+				defer func() { inflight.done(uint32(seq), written && seq > 0 && acks.enabled()) }()
+			}
+			if bidis != nil {
+				written = sendChunks(f, seq, bidis)
+				return
 			}
 
-			fs.Close()
-		}(idx, frame)
+			if opens != nil && !opens.wait(openAt, openCtx, session.Context()) {
+				return
+			}
+			fs, ok := openStream(openCtx, bidi, seq, shed)
+			if !ok {
+				return
+			}
+			if opens != nil {
+				opens.opened()
+			}
+			if p != nil {
+				if !bl.opened(p, fs) {
+					return
+				}
+				defer bl.done(uint32(seq))
+			}
+
+			if seq > 0 {
+				markSent(f, seq)
+			}
+			written = writeStream(fs, f, shed)
+		}()
+	}
+
+	// with FEC, every fec frames are followed by the XOR of their payloads;
+	// dropped frames are still folded in so the client can recover them
+	var parity []byte
+	parityFrames := 0
+	if cfg.fec > 0 {
+		parity = make([]byte, frame.ParityHeaderLen+frameSize-frame.HeaderLen)
+	}
+	sendParity := func(first uint32, k int) {
+		frame.PutParityHeader(parity, first, k)
+		send(parity, 0)
+		parityFrames++
+		parity = make([]byte, len(parity))
+	}
 
+	// with a replay schedule, frames go out at its offsets and the session
+	// ends with it at the latest
+	var recorded schedule
+	if cfg.lockThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+	pace := newPacer(FRAME_INTERVAL, cfg.precisePacing, requestStart)
+	sentFrames := 0
+	// frames go out unpaced until burstLeft bytes of them are sent; the
+	// schedule, -burst groups included, starts after the burstFrames
+	burstLeft, burstFrames := cfg.initialBurst, 0
+	for idx := 1; ; idx++ {
+		if connFailed.Load() {
+			break
+		}
+		if duration > 0 {
+			if time.Since(requestStart) >= duration {
+				break
+			}
+		} else if idx > numFrames {
+			break
+		}
+		if cfg.replay != nil {
+			if idx > len(cfg.replay) {
+				break
+			}
+			time.Sleep(time.Until(requestStart.Add(cfg.replay[idx-1])))
+		}
+		sentFrames = idx
+		inBurst := burstLeft > 0
+		if inBurst {
+			burstFrames++
+		} else if cfg.replay == nil && (idx-burstFrames-1)%cfg.burst == 0 {
+			pace.mark()
+		}
+		if abr != nil {
+			abr.update(acks, idx)
+			frameSize = abr.frameSize()
+		}
+		f := make([]byte, sizes.draw(frameSize))
+		frame.PutHeader(f, uint32(idx))
+		if cfg.seeded {
+			frame.FillSeeded(f[frame.HeaderLen:], uint32(idx))
+		} else if cfg.signature {
+			frame.FillSignature(f[frame.HeaderLen:], uint32(idx))
+		} else if cfg.entropy > 0 {
+			payload.Fill(f[frame.HeaderLen:], cfg.entropy, cfg.seeds.payloadRand(uint32(idx)))
+		}
+		if cfg.fec > 0 {
+			frame.XOR(parity[frame.ParityHeaderLen:], f[frame.HeaderLen:])
+		}
+		if cfg.scheduleOut != "" {
+			recorded = append(recorded, time.Since(requestStart))
+		}
+		if cfg.dropProb > 0 && rng.Float64() < cfg.dropProb {
+			log.Printf("Dropped frame %d", idx)
+			dropped++
+		} else {
+			sizes.sent(len(f))
+			send(f, idx)
+			burstLeft -= len(f)
+		}
+		if cfg.fec > 0 && idx%cfg.fec == 0 {
+			sendParity(uint32(idx-cfg.fec+1), cfg.fec)
+		}
+
+		if inBurst {
+			if burstLeft <= 0 {
+				burstSpan := time.Since(requestStart)
+				xferSpan.SetAttributes(attribute.Int("burst_frames", burstFrames), attribute.Float64("burst_ms", toMs(burstSpan)))
+				log.Printf("Initial burst of %d frames (%s) completed in %.2f ms; switching to the frame schedule",
+					burstFrames, printBytes(cfg.initialBurst-burstLeft), toMs(burstSpan))
+				pace.restart()
+			}
+		} else if cfg.replay == nil && (idx-burstFrames)%cfg.burst == 0 {
+			pace.wait()
+		}
+	}
+	if burstLeft > 0 {
+		log.Printf("Initial burst unfinished: the session ended after %d frames (%s) of it",
+			burstFrames, printBytes(cfg.initialBurst-burstLeft))
+	}
+	if cfg.replay != nil || burstLeft > 0 || (sentFrames-burstFrames)%cfg.burst != 0 {
+		// the same trailing gap the fixed interval leaves after the last
+		// frame, so it is not cut off by the connection close
 		time.Sleep(FRAME_INTERVAL)
 	}
+	if cfg.fec > 0 && sentFrames%cfg.fec != 0 {
+		sendParity(uint32(sentFrames-sentFrames%cfg.fec+1), sentFrames%cfg.fec)
+	}
 
 	wg.Wait()
-
+	if opens != nil {
+		// the last streams may open well after the last frame was
+		// generated; give them the same trailing gap before the close
+		time.Sleep(FRAME_INTERVAL)
+	}
 	elapsed := time.Since(requestStart).Seconds()
+	acks.wait(ackTimeout)
+	if reconcileCount {
+		reconcile(stream, sentFrames, int(writtenFrames.Load()))
+	}
 	total := atomic.LoadInt64(&totalBytes)
 	goodput := 0.0
 	if elapsed > 0 {
 		goodput = float64(total) * 8.0 / 1e6 / elapsed // Mbps
 	}
+	xferSpan.SetAttributes(attribute.Int64("bytes", total), attribute.Int("frames", sentFrames), attribute.Float64("goodput_mbps", goodput))
 	log.Printf("Sent %s in %.3f seconds, goodput: %.2f Mbps", printBytes(int(total)), elapsed, goodput)
+	if dropped > 0 {
+		log.Printf("Dropped %d of %d frames", dropped, sentFrames)
+	}
+	sizes.report()
+	if parityFrames > 0 {
+		log.Printf("Sent %d FEC parity frames (one per %d frames)", parityFrames, cfg.fec)
+	}
+	if bl != nil {
+		if n := bl.shedCount(); n > 0 {
+			log.Printf("Shed %d of %d frames from the full backlog (limit %d, drop-oldest)", n, sentFrames, cfg.maxBacklog)
+		}
+	}
+	if inflight != nil {
+		inflight.report(sentFrames)
+	}
+	if opens != nil {
+		opens.report()
+	}
+	if n := openTimeouts.Load(); n > 0 {
+		log.Printf("Dropped %d frames whose stream could not be opened within %v", n, openTimeout)
+	}
+	if n := failedFrames.Load(); n > 0 {
+		log.Printf("Failed to send %d frames", n)
+	}
+	if cfg.burst > 1 {
+		if n := blockedOpens.Load(); n > 0 {
+			log.Printf("Burst of %d: %d stream opens blocked on the stream limit, waiting %.2f ms summed over streams",
+				cfg.burst, n, toMs(time.Duration(blockedNanos.Load())))
+		} else {
+			log.Printf("Burst of %d: no stream-limit backpressure", cfg.burst)
+		}
+	}
+	if cfg.replay != nil && sentFrames == len(cfg.replay) && (duration > 0 || numFrames > len(cfg.replay)) {
+		log.Printf("Replay schedule ended after %d frames", len(cfg.replay))
+	}
+	if cfg.scheduleOut != "" {
+		if err := recorded.writeFile(cfg.scheduleOut); err != nil {
+			log.Println("Write schedule error:", err)
+		}
+	}
+	pace.report()
+	streams.report()
+	acks.report()
+	if s, ok := sender.Stats(); ok {
+		if cfg.reportWire {
+			log.Printf("Wire: %s", s.WireSummary())
+		}
+		if cfg.reportFC {
+			log.Printf("Flow control: %s", s.FlowControlSummary())
+		}
+	}
+}
+
+// isConnectionError reports whether err means the whole connection is gone,
+// rather than only the stream it was returned for.
+func isConnectionError(session *quic.Conn, err error) bool {
+	if session.Context().Err() != nil || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var appErr *quic.ApplicationError
+	var transportErr *quic.TransportError
+	return errors.As(err, &appErr) || errors.As(err, &transportErr)
 }
 
 // printBytes formats bytes into human-readable string similar to Rust impl
@@ -185,6 +1039,18 @@ func generateTLSConfig() *tls.Config {
 	}
 }
 
+// parseALPN splits a comma-separated ALPN protocol list, dropping empty
+// entries.
+func parseALPN(list string) []string {
+	var protos []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protos = append(protos, p)
+		}
+	}
+	return protos
+}
+
 // disable GSO; in Mininet’s virtual links, GSO behaves unexpectedly and
 // results in oversized UDP packets being transmitted without MTU-based segmentation.
 // It should instead produce multiple MTU-sized UDP packets before transmission.
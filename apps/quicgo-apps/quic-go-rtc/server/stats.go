@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStats are aggregate counters over every session the server
+// handles, updated with atomics once per session so they stay off the frame
+// path.
+type serverStats struct {
+	start    time.Time
+	sessions atomic.Int64
+	active   atomic.Int64
+	peak     atomic.Int64
+	bytes    atomic.Int64
+	// running tracks the sessions in progress, for draining on shutdown
+	running sync.WaitGroup
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{start: time.Now()}
+}
+
+// opened counts a new session.
+func (s *serverStats) opened() {
+	s.running.Add(1)
+	s.sessions.Add(1)
+	n := s.active.Add(1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// closed counts the end of a session that sent bytes, counting every packet
+// byte, retransmissions included.
+func (s *serverStats) closed(bytes uint64) {
+	s.active.Add(-1)
+	s.bytes.Add(int64(bytes))
+	s.running.Done()
+}
+
+// report logs the totals since newServerStats.
+func (s *serverStats) report() {
+	log.Printf("Served %d sessions in %s of uptime: %s sent, peak %d concurrent",
+		s.sessions.Load(), time.Since(s.start).Round(time.Second), printBytes(int(s.bytes.Load())), s.peak.Load())
+}
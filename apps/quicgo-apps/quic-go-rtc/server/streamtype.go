@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+)
+
+// The -stream-type values.
+const (
+	streamUni       = "uni"
+	streamBidi      = "bidi"
+	streamAlternate = "alternate"
+)
+
+// frameSendStream is the send side of a frame's stream, a *quic.SendStream
+// or a *quic.Stream.
+type frameSendStream interface {
+	io.Writer
+	Close() error
+	CancelWrite(quic.StreamErrorCode)
+}
+
+// frameStreams opens the frame streams of a session as uni or bidi streams,
+// or alternating between the two, and counts the streams of each type.
+// Uni streams count against the client's uni stream limit and bidi streams
+// against its bidi one, and a bidi stream also gets a receive window on
+// both ends.
+type frameStreams struct {
+	session *quic.Conn
+	kind    string
+	next    int
+	// opened and blocked count the streams of each type, uni then bidi, and
+	// the opens of them that waited for the client's stream limit
+	opened, blocked [2]atomic.Int64
+}
+
+func newFrameStreams(session *quic.Conn, kind string) *frameStreams {
+	return &frameStreams{session: session, kind: kind}
+}
+
+// pick returns whether the next frame goes on a bidi stream. It is called
+// in send order, so that alternate starts with a uni stream.
+func (s *frameStreams) pick() bool {
+	i := s.next
+	s.next++
+	switch s.kind {
+	case streamBidi:
+		return true
+	case streamAlternate:
+		return i%2 == 1
+	}
+	return false
+}
+
+// open opens a stream of the type picked, failing with a
+// *quic.StreamLimitReachedError rather than waiting for the client.
+func (s *frameStreams) open(bidi bool) (frameSendStream, error) {
+	if !bidi {
+		fs, err := s.session.OpenUniStream()
+		if err != nil {
+			return nil, err
+		}
+		return fs, nil
+	}
+	fs, err := s.session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return readNothing(fs), nil
+}
+
+// openSync is open, waiting until ctx is done for the client to raise its
+// stream limit.
+func (s *frameStreams) openSync(ctx context.Context, bidi bool) (frameSendStream, error) {
+	s.blocked[typeIndex(bidi)].Add(1)
+	if !bidi {
+		fs, err := s.session.OpenUniStreamSync(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return fs, nil
+	}
+	fs, err := s.session.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return readNothing(fs), nil
+}
+
+// readNothing stops the receive side of bidi frame stream fs: the client
+// sends nothing on it, and the stream is only released once both sides are
+// done.
+func readNothing(fs *quic.Stream) *quic.Stream {
+	fs.CancelRead(0)
+	return fs
+}
+
+// done counts a stream of the type picked as opened.
+func (s *frameStreams) done(bidi bool) {
+	s.opened[typeIndex(bidi)].Add(1)
+}
+
+// typeIndex returns the index of a stream type in the counts.
+func typeIndex(bidi bool) int {
+	if bidi {
+		return 1
+	}
+	return 0
+}
+
+// report logs how many frame streams of each type were opened, and how
+// many of those opens waited on the stream limit.
+func (s *frameStreams) report() {
+	if s.kind == streamUni {
+		return
+	}
+	log.Printf("Frame streams (-stream-type %s): %d uni, %d bidi; opens blocked on the stream limit: %d uni, %d bidi",
+		s.kind, s.opened[0].Load(), s.opened[1].Load(), s.blocked[0].Load(), s.blocked[1].Load())
+}
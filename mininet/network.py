@@ -423,7 +423,7 @@ class PEMINetwork:
     def start_quicgo_goodput_client(self, log_level, request_kb=10):
         pemilog("Starting the client on h1...")
         self.h1.cmdPrint(
-            f"{self.client_mm_prefix} ./apps/quicgo-apps/quic-go-goodput/client/client -p {self.h2.IP()}:4433 -n {request_kb} 2> c1.log{self.client_mm_suffix}"
+            f"{self.client_mm_prefix} ./apps/quicgo-apps/quic-go-goodput/client/client -insecure -p {self.h2.IP()}:4433 -n {request_kb} 2> c1.log{self.client_mm_suffix}"
         )
 
     def start_quicgo_rtc_server(self, log_level):
@@ -449,7 +449,7 @@ class PEMINetwork:
         frames = 30 * video_long
         pemilog("Starting the client on h1...")
         self.h1.cmdPrint(
-            f"{self.client_mm_prefix} ./apps/quicgo-apps/quic-go-rtc/client/client -p {self.h2.IP()}:4433 -f {frames} &> c1.log{self.client_mm_suffix}"
+            f"{self.client_mm_prefix} ./apps/quicgo-apps/quic-go-rtc/client/client -insecure -p {self.h2.IP()}:4433 -f {frames} &> c1.log{self.client_mm_suffix}"
         )
 
     def start_capture(self, args):